@@ -8,6 +8,7 @@ import (
 	"github.com/junaozun/go-lrpxc/codes"
 	"github.com/junaozun/go-lrpxc/interceptor"
 	"github.com/junaozun/go-lrpxc/metadata"
+	"github.com/junaozun/go-lrpxc/plugin"
 	"github.com/junaozun/go-lrpxc/pool/connpool"
 	"github.com/junaozun/go-lrpxc/protocol"
 	"github.com/junaozun/go-lrpxc/selector"
@@ -22,6 +23,10 @@ import (
 
 type Client interface {
 	Invoke(ctx context.Context, req, rsp interface{}, path string, opts ...ClientOption) error
+	// InvokeOneWay 只发不收，请求帧写入连接后立即返回，server 执行完 handler 后不回包
+	InvokeOneWay(ctx context.Context, req interface{}, path string, opts ...ClientOption) error
+	// NewStream 发起一个流式调用，desc 描述 client 和 server 是否发送消息流
+	NewStream(ctx context.Context, desc *StreamDesc, path string, opts ...ClientOption) (ClientStream, error)
 }
 
 type defaultClient struct {
@@ -38,6 +43,24 @@ var New = func() *defaultClient {
 	}
 }
 
+// Close 在 client 进程退出前调用：关闭通过 target 创建的 Selector，并按相反的顺序停止 plugins。
+// 插件由初始化它的一方停止：client 通过 jaeger.Init、consul.Init 等初始化的插件需要传给 Close，
+// 比如 client.Close(ctx, jaeger.JaegerSvr)，关闭 tracer 并上报还在缓冲中的 span；
+// 同一进程中 server 的插件由 server 的 Shutdown 或者 Close 停止，Close 不会停止它们。错误会被汇总返回
+func Close(ctx context.Context, plugins ...plugin.Plugin) error {
+	var errs plugin.Errors
+	if err := selector.CloseSelectors(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := plugin.Stop(ctx, plugins...); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
 // 使用gostruct定义结构体的方式，需要调用call
 func (c *defaultClient) Call(ctx context.Context, servicePath string, req interface{}, rsp interface{},
 	opts ...ClientOption) error {
@@ -56,15 +79,39 @@ func (c *defaultClient) Call(ctx context.Context, servicePath string, req interf
 	return nil
 }
 
+// CallOneWay 是 Call 的只发不收版本，使用 msgpack 序列化请求
+func (c *defaultClient) CallOneWay(ctx context.Context, servicePath string, req interface{}, opts ...ClientOption) error {
+
+	callOpts := make([]ClientOption, 0, len(opts)+1)
+	callOpts = append(callOpts, opts...)
+	callOpts = append(callOpts, WithSerializationType(serialization.MsgPack))
+
+	return c.InvokeOneWay(ctx, req, servicePath, callOpts...)
+}
+
 // 两种方式，不论是使用gostruct的反射方式还是proto代码生成，最终都会调用 invoke 函数。invoke 完成了一个客户端的完整动作
 func (c *defaultClient) Invoke(ctx context.Context, req, rsp interface{}, path string, opts ...ClientOption) error {
+	// opts 只对本次调用生效，拷贝一份 ClientOptions，避免污染 DefaultClient 这类共享的 client
+	c = c.clone()
+	c.opts.reqType = codec.ReqTypeSendAndRecv
+	return c.call(ctx, req, rsp, path, opts...)
+}
+
+// InvokeOneWay 发起只发不收的调用，同样会执行拦截器，rsp 为 nil
+func (c *defaultClient) InvokeOneWay(ctx context.Context, req interface{}, path string, opts ...ClientOption) error {
+	c = c.clone()
+	c.opts.reqType = codec.ReqTypeSendOnly
+	return c.call(ctx, req, nil, path, opts...)
+}
+
+func (c *defaultClient) call(ctx context.Context, req, rsp interface{}, path string, opts ...ClientOption) error {
 	for _, o := range opts {
 		o(c.opts)
 	}
 
 	if c.opts.timeout > 0 {
-		// var cancel context.CancelFunc
-		_, cancel := context.WithTimeout(ctx, c.opts.timeout)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.timeout)
 		defer cancel()
 	}
 
@@ -77,6 +124,9 @@ func (c *defaultClient) Invoke(ctx context.Context, req, rsp interface{}, path s
 	}
 	WithMethod(method)(c.opts)
 	WithServiceName(serviceName)(c.opts)
+	if err := c.resolveTarget(); err != nil {
+		return err
+	}
 	// c.opts.serviceName = serviceName
 	// c.opts.method = method
 
@@ -85,7 +135,7 @@ func (c *defaultClient) Invoke(ctx context.Context, req, rsp interface{}, path s
 	clientStream.WithMethod(method)
 
 	// execute the interceptor first
-	return interceptor.ClientIntercept(newCtx, req, rsp, c.opts.interceptors, c.invoke)
+	return interceptor.ClientIntercept(newCtx, req, rsp, c.opts.interceptors, c.invokeWithRetry)
 }
 
 func (c *defaultClient) invoke(ctx context.Context, req, rsp interface{}) error {
@@ -107,49 +157,73 @@ func (c *defaultClient) invoke(ctx context.Context, req, rsp interface{}) error
 		return err
 	}
 
-	clientCodec := codec.GetCodec(c.opts.protocol)
+	compressType, err := codec.GetCompressType(c.opts.compressor, len(reqbuf), c.opts.compressMinSize)
+	if err != nil {
+		return codes.NewFrameworkError(codes.ConfigErrorCode, err.Error())
+	}
+
+	clientCodec := codec.GetFrameCodec(c.opts.protocol)
 	// 包头+包体序列化后拼上帧头，编码成二进制
-	reqbody, err := clientCodec.Encode(reqbuf)
+	reqHeader := &codec.FrameHeader{
+		ReqType:      c.opts.reqType,
+		CompressType: compressType,
+	}
+	reqbody, err := clientCodec.EncodeFrame(reqHeader, reqbuf)
 	if err != nil {
 		return err
 	}
 
-	// 底层 tcp 通信的能力是通过 transport 实现的，这里先创建一个client transport，
+	// 开启对冲的方法会向多个节点发送同一个请求帧
+	var rspPayload []byte
+	if p := c.opts.hedgingPolicy; p != nil && c.opts.reqType == codec.ReqTypeSendAndRecv && p.enabled(c.opts.serviceName, c.opts.method) {
+		rspPayload, err = c.hedge(ctx, reqbody, p)
+	} else {
+		rspPayload, err = c.roundTrip(ctx, reqbody, c.transportOptions()...)
+	}
+	if err != nil {
+		return err
+	}
 
-	clientTransport := c.NewClientTransport()
-	clientTransportOpts := []client_transport.ClientTransportOption{
-		client_transport.WithServiceName(c.opts.serviceName),
-		client_transport.WithClientTarget(c.opts.target),
-		client_transport.WithClientNetwork(c.opts.network),
-		client_transport.WithClientPool(connpool.GetPool("default")),
-		client_transport.WithSelector(selector.GetSelector(c.opts.selectorName)),
-		client_transport.WithTimeout(c.opts.timeout),
+	// 只发不收，请求写出去就结束了
+	if c.opts.reqType == codec.ReqTypeSendOnly {
+		return nil
 	}
+
+	return serialization.Unmarshal(rspPayload, rsp)
+}
+
+// roundTrip 通过 client transport 发送请求帧，解析回包帧，返回回包的包体
+func (c *defaultClient) roundTrip(ctx context.Context, reqbody []byte, opts ...client_transport.ClientTransportOption) ([]byte, error) {
+	// 底层 tcp 通信的能力是通过 transport 实现的，这里先创建一个client transport，
+	clientTransport := c.NewClientTransport()
 	// 然后调用 transport 的 Send 函数往下游发送请求，会收到 server 返回的一个完整响应帧数据
 	// 客户端将请求数据send到服务器，接受服务器返回的frame，这个frame包括帧头+包头+包体
-	frame, err := clientTransport.Send(ctx, reqbody, clientTransportOpts...)
+	frame, err := clientTransport.Send(ctx, reqbody, opts...)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	if c.opts.reqType == codec.ReqTypeSendOnly {
+		return nil, nil
 	}
 
 	// 解码这里直接过滤了帧头，返回包头+包体
-	rspbuf, err := clientCodec.Decode(frame)
+	_, rspbuf, err := codec.GetFrameCodec(c.opts.protocol).DecodeFrame(frame)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// parse protocol header
 	response := &protocol.Response{}
 	if err = proto.Unmarshal(rspbuf, response); err != nil {
-		return err
+		return nil, err
 	}
 
 	if response.RetCode != 0 {
-		return codes.New(response.RetCode, response.RetMsg)
+		return nil, codes.New(response.RetCode, response.RetMsg)
 	}
 
-	return serialization.Unmarshal(response.Payload, rsp)
-
+	return response.Payload, nil
 }
 
 func addReqHeader(ctx context.Context, client *defaultClient, payload []byte) *protocol.Request {
@@ -175,6 +249,31 @@ func addReqHeader(ctx context.Context, client *defaultClient, payload []byte) *p
 	return request
 }
 
+func (c *defaultClient) clone() *defaultClient {
+	opts := *c.opts
+	opts.interceptors = append([]interceptor.ClientInterceptor(nil), c.opts.interceptors...)
+	return &defaultClient{
+		opts: &opts,
+	}
+}
+
+func (c *defaultClient) transportOptions() []client_transport.ClientTransportOption {
+	return []client_transport.ClientTransportOption{
+		client_transport.WithServiceName(c.opts.serviceName),
+		client_transport.WithMethod(c.opts.method),
+		client_transport.WithClientTarget(c.opts.target),
+		client_transport.WithClientNetwork(c.opts.network),
+		client_transport.WithClientPool(connpool.GetPool("default")),
+		client_transport.WithSelector(c.selector()),
+		client_transport.WithHashKey(c.opts.hashKey),
+		client_transport.WithBreaker(c.opts.breaker),
+		client_transport.WithOutlierDetector(c.opts.outlierDetector),
+		client_transport.WithTimeout(c.opts.timeout),
+		client_transport.WithMultiplexed(c.opts.multiplexed),
+		client_transport.WithHeartbeat(c.opts.heartbeatInterval, c.opts.maxMissedHeartbeats),
+	}
+}
+
 func (c *defaultClient) NewClientTransport() transport.ClientTransport {
 	return client_transport.GetClientTransport(c.opts.protocol)
 }
@@ -4,21 +4,36 @@ import (
 	"time"
 
 	"github.com/junaozun/go-lrpxc/interceptor"
+	"github.com/junaozun/go-lrpxc/selector"
+	"github.com/junaozun/go-lrpxc/selector/circuitbreaker"
+	"github.com/junaozun/go-lrpxc/selector/outlier"
 	"github.com/junaozun/go-lrpxc/transport/client_transport"
 )
 
 // ClientOptions defines the client call parameters
 type ClientOptions struct {
-	serviceName       string        // service name
-	method            string        // method name
-	target            string        // format e.g.:  ip:port 127.0.0.1:8000
-	timeout           time.Duration // timeout
-	network           string        // network type, e.g.:  tcp、udp
-	protocol          string        // protocol type , e.g. : proto、json
-	serializationType string        // seralization type , e.g. : proto、msgpack
-	transportOpts     client_transport.ClientTransportOptions
-	interceptors      []interceptor.ClientInterceptor
-	selectorName      string // service discovery name, e.g. : consul、zookeeper、etcd
+	serviceName         string        // service name
+	method              string        // method name
+	target              string        // format e.g.:  ip:port 127.0.0.1:8000, static://127.0.0.1:8000,127.0.0.1:8001
+	timeout             time.Duration // timeout
+	network             string        // network type, e.g.:  tcp、udp
+	protocol            string        // protocol type , e.g. : proto、json
+	serializationType   string        // seralization type , e.g. : proto、msgpack
+	transportOpts       client_transport.ClientTransportOptions
+	interceptors        []interceptor.ClientInterceptor
+	selectorName        string                  // service discovery name, e.g. : consul、zookeeper、etcd
+	targetSelector      selector.Selector       // selector built from the scheme of the target, overrides selectorName
+	hashKey             string                  // hash key of the consistent hash balancer, e.g. : user id
+	multiplexed         bool                    // whether concurrent calls share one connection per address
+	compressor          string                  // compressor name, e.g. : gzip、zlib、snappy、zstd, default: no compression
+	compressMinSize     int                     // requests smaller than compressMinSize are not compressed
+	heartbeatInterval   time.Duration           // heartbeat interval of multiplexed connections, 0 means no heartbeat
+	maxMissedHeartbeats int                     // close the multiplexed connection after missing maxMissedHeartbeats heartbeats
+	reqType             uint8                   // request type of the frame header, set by Invoke or InvokeOneWay
+	retryPolicy         *RetryPolicy            // retry policy of failed calls, nil means no retry
+	hedgingPolicy       *HedgingPolicy          // hedging policy of idempotent methods, nil means no hedging
+	breaker             *circuitbreaker.Breaker // per-node circuit breaker, nil means no circuit breaker
+	outlierDetector     *outlier.Detector       // ejects nodes with elevated error rates or latency, nil means no outlier detection
 	// perRPCAuth        []auth.PerRPCAuth // authentication information required for each RPC call
 	// transportAuth     auth.TransportAuth
 }
@@ -41,6 +56,9 @@ func WithMethod(method string) ClientOption {
 	}
 }
 
+// WithTarget 设置下游地址，可以是 ip:port，也可以是带 scheme 的 target，比如
+// consul://127.0.0.1:8500/helloworld.Greeter?balancer=roundRobin、static://127.0.0.1:8000,127.0.0.1:8001、
+// dns:///svc.local:8000、file:///etc/nodes.yaml、unix:///tmp/x.sock，带 scheme 时 Selector、负载均衡方式和网络类型由 target 决定
 func WithTarget(target string) ClientOption {
 	return func(o *ClientOptions) {
 		o.target = target
@@ -77,6 +95,74 @@ func WithSelectorName(selectorName string) ClientOption {
 	}
 }
 
+// WithHashKey 设置一致性哈希负载均衡使用的 key，比如用户 ID，相同 key 的调用会被路由到同一个节点。
+// 也可以通过 selector.ContextWithHashKey 或者元数据中的 selector.HashKeyMetadataKey 为每次调用设置
+func WithHashKey(hashKey string) ClientOption {
+	return func(o *ClientOptions) {
+		o.hashKey = hashKey
+	}
+}
+
+// WithMultiplexed 开启连接多路复用，同一个地址的并发调用共用一条连接，通过帧头的流 ID 区分请求
+func WithMultiplexed(multiplexed bool) ClientOption {
+	return func(o *ClientOptions) {
+		o.multiplexed = multiplexed
+	}
+}
+
+// WithCompressor 设置请求使用的压缩算法，回包是否压缩由 server 的配置决定，压缩算法未注册时调用返回错误
+func WithCompressor(compressor string) ClientOption {
+	return func(o *ClientOptions) {
+		o.compressor = compressor
+	}
+}
+
+// WithCompressMinSize 设置最小压缩长度，包头+包体小于 size 的请求不压缩
+func WithCompressMinSize(size int) ClientOption {
+	return func(o *ClientOptions) {
+		o.compressMinSize = size
+	}
+}
+
+// WithHeartbeat 设置多路复用连接的心跳，连接空闲 interval 后发送心跳，连续 maxMissed 个心跳没有回应时关闭连接。
+// 连接池中连接的心跳通过 connpool.WithHeartbeatInterval 等选项在连接池上配置
+func WithHeartbeat(interval time.Duration, maxMissed int) ClientOption {
+	return func(o *ClientOptions) {
+		o.heartbeatInterval = interval
+		o.maxMissedHeartbeats = maxMissed
+	}
+}
+
+// WithRetryPolicy 设置调用失败后的重试策略，只有网络错误等可重试的错误才会重试，每次重试会重新选择节点
+func WithRetryPolicy(policy *RetryPolicy) ClientOption {
+	return func(o *ClientOptions) {
+		o.retryPolicy = policy
+	}
+}
+
+// WithHedgingPolicy 设置对冲策略，只对策略中开启的方法生效
+func WithHedgingPolicy(policy *HedgingPolicy) ClientOption {
+	return func(o *ClientOptions) {
+		o.hedgingPolicy = policy
+	}
+}
+
+// WithCircuitBreaker 设置按节点熔断的熔断器，熔断中的节点会被 Selector 跳过，发往这个节点的请求直接失败。
+// 同一个下游的调用应该共享一个熔断器
+func WithCircuitBreaker(breaker *circuitbreaker.Breaker) ClientOption {
+	return func(o *ClientOptions) {
+		o.breaker = breaker
+	}
+}
+
+// WithOutlierDetector 设置异常节点检测器，每次调用的结果会反馈给检测器，失败率或者延迟异常的节点会被暂时剔除。
+// 同一个下游的调用应该共享一个检测器
+func WithOutlierDetector(detector *outlier.Detector) ClientOption {
+	return func(o *ClientOptions) {
+		o.outlierDetector = detector
+	}
+}
+
 func WithInterceptor(interceptors ...interceptor.ClientInterceptor) ClientOption {
 	return func(o *ClientOptions) {
 		o.interceptors = append(o.interceptors, interceptors...)
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/junaozun/go-lrpxc/plugin"
+)
+
+// lifecyclePlugin 记录 Stop 被调用的次数
+type lifecyclePlugin struct {
+	stops   int
+	stopErr error
+}
+
+func (p *lifecyclePlugin) Start(ctx context.Context) error { return nil }
+
+func (p *lifecyclePlugin) Stop(ctx context.Context) error {
+	p.stops++
+	return p.stopErr
+}
+
+func TestCloseStopsOnlyGivenPlugins(t *testing.T) {
+	// 同一进程中 server 注册的插件
+	serverPlugin := &lifecyclePlugin{}
+	plugin.Register("test-server-plugin", serverPlugin)
+
+	clientPlugin := &lifecyclePlugin{}
+	if err := Close(context.Background(), clientPlugin); err != nil {
+		t.Fatal(err)
+	}
+	if clientPlugin.stops != 1 {
+		t.Fatalf("client plugin stopped %d times, want 1", clientPlugin.stops)
+	}
+	if serverPlugin.stops != 0 {
+		t.Fatalf("server plugin stopped %d times by client.Close, want 0", serverPlugin.stops)
+	}
+}
+
+func TestCloseReturnsPluginErrors(t *testing.T) {
+	a := &lifecyclePlugin{stopErr: errors.New("a failed")}
+	b := &lifecyclePlugin{}
+	err := Close(context.Background(), a, b)
+
+	var errs plugin.Errors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("Close got %v, want the error of plugin a", err)
+	}
+	if a.stops != 1 || b.stops != 1 {
+		t.Fatalf("plugins stopped %d and %d times, want 1 and 1", a.stops, b.stops)
+	}
+}
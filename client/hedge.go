@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/metadata"
+	"github.com/junaozun/go-lrpxc/selector"
+	"github.com/junaozun/go-lrpxc/transport/client_transport"
+)
+
+/*
+对冲请求：先向一个节点发送请求，delay 后还没有回包时，通过 Selector 选择另一个节点再发送一份同样的请求，
+最先成功的回包作为调用的结果，其余还在进行中的请求被取消。某个请求返回网络错误时立即发送下一份请求，
+返回业务错误时说明请求已经被处理，直接返回这个错误。
+对冲请求会被 server 多次执行，只能用于幂等的方法，所以需要通过 WithHedgingMethods 按方法开启。
+*/
+
+const defaultHedgingMaxAttempts = 2
+
+// HedgingPolicy 是对冲请求的策略
+type HedgingPolicy struct {
+	delay       time.Duration   // send the next copy if no response arrives within delay
+	maxAttempts int             // max number of copies, including the first one
+	methods     map[string]bool // methods enabled, e.g. : SayHello or /helloworld.Greeter/SayHello
+}
+
+type HedgingOption func(*HedgingPolicy)
+
+// NewHedgingPolicy 创建一个对冲策略，请求发出 delay 后还没有回包时向另一个节点再发送一份请求，默认最多发送 2 份
+func NewHedgingPolicy(delay time.Duration, opts ...HedgingOption) *HedgingPolicy {
+	p := &HedgingPolicy{
+		delay:       delay,
+		maxAttempts: defaultHedgingMaxAttempts,
+		methods:     make(map[string]bool),
+	}
+	for _, o := range opts {
+		o(p)
+	}
+	return p
+}
+
+// WithHedgingMaxAttempts 设置最多发送的请求份数，包括第一份
+func WithHedgingMaxAttempts(maxAttempts int) HedgingOption {
+	return func(p *HedgingPolicy) {
+		p.maxAttempts = maxAttempts
+	}
+}
+
+// WithHedgingMethods 设置开启对冲的方法，方法可以是方法名，比如 SayHello，也可以是服务路径，比如 /helloworld.Greeter/SayHello
+func WithHedgingMethods(methods ...string) HedgingOption {
+	return func(p *HedgingPolicy) {
+		for _, m := range methods {
+			p.methods[m] = true
+		}
+	}
+}
+
+// enabled 判断方法是否开启了对冲
+func (p *HedgingPolicy) enabled(serviceName, method string) bool {
+	return p.methods[method] || p.methods["/"+serviceName+"/"+method]
+}
+
+type hedgeResult struct {
+	payload []byte
+	err     error
+}
+
+// hedge 按对冲策略发送请求帧，返回最先成功的回包的包体
+func (c *defaultClient) hedge(ctx context.Context, reqbody []byte, p *HedgingPolicy) ([]byte, error) {
+	// 返回时取消还在进行中的请求
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, p.maxAttempts)
+	var tried []string
+
+	// send 选择一个还没有发送过的节点发送请求，没有这样的节点时返回 false
+	send := func() (bool, error) {
+		addr, err := c.selectNode(ctx, tried)
+		if err != nil {
+			return false, err
+		}
+		for _, t := range tried {
+			if t == addr {
+				return false, nil
+			}
+		}
+		tried = append(tried, addr)
+
+		// 多路复用连接会在请求帧中写入流 ID，每份请求使用自己的请求帧
+		buf := append([]byte(nil), reqbody...)
+		opts := append(c.transportOptions(),
+			client_transport.WithClientTarget(addr),
+			client_transport.WithSelector(selector.DefaultSelector))
+		go func() {
+			payload, err := c.roundTrip(ctx, buf, opts...)
+			results <- hedgeResult{payload, err}
+		}()
+		return true, nil
+	}
+
+	if _, err := send(); err != nil {
+		return nil, err
+	}
+	inflight := 1
+
+	timer := time.NewTimer(p.delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			if len(tried) < p.maxAttempts {
+				if ok, _ := send(); ok {
+					inflight++
+					timer.Reset(p.delay)
+				}
+			}
+
+		case r := <-results:
+			inflight--
+			if r.err == nil || !codes.IsRetryable(r.err) {
+				return r.payload, r.err
+			}
+			// 网络错误时不再等待，立即向下一个节点发送
+			if len(tried) < p.maxAttempts {
+				if ok, _ := send(); ok {
+					inflight++
+				}
+			}
+			if inflight == 0 {
+				return nil, r.err
+			}
+		}
+	}
+}
+
+// selectNode 通过 Selector 选择一个节点，exclude 中的节点会被尽量避开
+func (c *defaultClient) selectNode(ctx context.Context, exclude []string) (string, error) {
+	opts := []selector.Option{
+		selector.WithContext(ctx),
+		selector.WithMethod(c.opts.method),
+		selector.WithMetadata(metadata.ClientMetadata(ctx)),
+		selector.WithHashKey(c.opts.hashKey),
+		selector.WithExclude(exclude...),
+	}
+	if c.opts.breaker != nil {
+		opts = append(opts, selector.WithBreaker(c.opts.breaker))
+	}
+	if c.opts.outlierDetector != nil {
+		opts = append(opts, selector.WithFilters(c.opts.outlierDetector))
+	}
+
+	addr, err := c.selector().Select(c.opts.serviceName, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	// defaultSelector returns "", use the target as address
+	if addr == "" {
+		addr = c.opts.target
+	}
+
+	return addr, nil
+}
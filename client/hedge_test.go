@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/selector"
+	"github.com/junaozun/go-lrpxc/selector/circuitbreaker"
+	"github.com/junaozun/go-lrpxc/selector/outlier"
+)
+
+func TestHedgingPolicyEnabled(t *testing.T) {
+	p := NewHedgingPolicy(time.Millisecond, WithHedgingMethods("Read", "/test.Service/Get"))
+
+	cases := []struct {
+		service, method string
+		want            bool
+	}{
+		{"test.Service", "Read", true},
+		{"other.Service", "Read", true},
+		{"test.Service", "Get", true},
+		{"other.Service", "Get", false},
+		{"test.Service", "Write", false},
+	}
+	for _, c := range cases {
+		if got := p.enabled(c.service, c.method); got != c.want {
+			t.Errorf("enabled(%q, %q) = %v, want %v", c.service, c.method, got, c.want)
+		}
+	}
+}
+
+// sameServer 返回指向同一个 server 的两个不同地址，对冲请求会把它们当成两个节点
+func sameServer(addr string) []string {
+	return []string{addr, strings.Replace(addr, "127.0.0.1", "localhost", 1)}
+}
+
+func TestHedgeSlowNode(t *testing.T) {
+	addr := testServer(t)
+	atomic.StoreInt32(&service.slow, 0)
+
+	sel := &listSelector{addrs: sameServer(addr)}
+	selector.RegisterSelector("test-hedge-slow", sel)
+
+	p := NewHedgingPolicy(50*time.Millisecond, WithHedgingMethods("Slow"))
+	rsp := &testRsp{}
+	start := time.Now()
+	err := DefaultClient.Call(context.Background(), "/test.Service/Slow", &testReq{}, rsp,
+		WithNetwork("tcp"), WithSelectorName("test-hedge-slow"), WithTimeout(2*time.Second), WithHedgingPolicy(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Msg != "fast" {
+		t.Fatalf("rsp = %q, want the hedged response", rsp.Msg)
+	}
+	if d := time.Since(start); d > 250*time.Millisecond {
+		t.Fatalf("call took %v, the slow node was not hedged", d)
+	}
+	if n := sel.attempts(); n != 2 {
+		t.Fatalf("%d nodes selected, want 2", n)
+	}
+}
+
+func TestHedgeNetworkErrorSendsNext(t *testing.T) {
+	addr := testServer(t)
+
+	// 第一个节点建连失败，不等 delay 立即向第二个节点发送
+	sel := &listSelector{addrs: []string{freeAddr(t), addr}}
+	selector.RegisterSelector("test-hedge-network", sel)
+
+	p := NewHedgingPolicy(time.Second, WithHedgingMethods("Ok"))
+	rsp := &testRsp{}
+	start := time.Now()
+	err := DefaultClient.Call(context.Background(), "/test.Service/Ok", &testReq{}, rsp,
+		WithNetwork("tcp"), WithSelectorName("test-hedge-network"), WithTimeout(2*time.Second), WithHedgingPolicy(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Msg != "ok" {
+		t.Fatalf("rsp = %q, want ok", rsp.Msg)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("call took %v, the next copy waited for the hedging delay", d)
+	}
+}
+
+func TestHedgeBusinessErrorReturned(t *testing.T) {
+	addr := testServer(t)
+	before := atomic.LoadInt32(&service.biz)
+
+	sel := &listSelector{addrs: sameServer(addr)}
+	selector.RegisterSelector("test-hedge-biz", sel)
+
+	// 业务错误说明请求已经被处理，直接返回，不再发送下一份
+	p := NewHedgingPolicy(time.Second, WithHedgingMethods("Biz"))
+	err := DefaultClient.Call(context.Background(), "/test.Service/Biz", &testReq{}, &testRsp{},
+		WithNetwork("tcp"), WithSelectorName("test-hedge-biz"), WithTimeout(2*time.Second), WithHedgingPolicy(p))
+	if err == nil || codes.IsRetryable(err) {
+		t.Fatalf("got %v, want a business error", err)
+	}
+	if n := atomic.LoadInt32(&service.biz) - before; n != 1 {
+		t.Fatalf("business error was called %d times, want 1", n)
+	}
+	if n := sel.attempts(); n != 1 {
+		t.Fatalf("%d nodes selected, want 1", n)
+	}
+}
+
+func TestHedgeCanceledCopyIsNotAFailure(t *testing.T) {
+	addr := testServer(t)
+	atomic.StoreInt32(&service.slow, 0)
+
+	addrs := sameServer(addr)
+	sel := &listSelector{addrs: addrs}
+	selector.RegisterSelector("test-hedge-cancel", sel)
+
+	// 一次失败就会熔断或者剔除节点
+	breaker := circuitbreaker.New(circuitbreaker.WithConsecutiveFailures(1), circuitbreaker.WithFailureRatio(0, 0, 0))
+	detector := outlier.New(outlier.WithInterval(10*time.Millisecond), outlier.WithMinRequests(1),
+		outlier.WithFailureRatio(0.5), outlier.WithMaxEjectionPercent(100))
+
+	p := NewHedgingPolicy(50*time.Millisecond, WithHedgingMethods("Slow"))
+	rsp := &testRsp{}
+	err := DefaultClient.Call(context.Background(), "/test.Service/Slow", &testReq{}, rsp,
+		WithNetwork("tcp"), WithSelectorName("test-hedge-cancel"), WithTimeout(2*time.Second), WithHedgingPolicy(p),
+		WithCircuitBreaker(breaker), WithOutlierDetector(detector))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Msg != "fast" {
+		t.Fatalf("rsp = %q, want the hedged response", rsp.Msg)
+	}
+
+	// 等待被取消的请求返回
+	deadline := time.Now().Add(time.Second)
+	for selector.DefaultInflight.Count(addrs[0]) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the canceled copy did not return")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	if s := breaker.State(addrs[0]); s != circuitbreaker.StateClosed {
+		t.Fatalf("breaker state of the canceled node = %v, want closed", s)
+	}
+	nodes := []*selector.Node{{Address: addrs[0]}, {Address: addrs[1]}}
+	if got := detector.Filter("test.Service", nodes); len(got) != len(nodes) {
+		t.Fatalf("Filter returned %d nodes, the canceled node was ejected", len(got))
+	}
+}
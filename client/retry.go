@@ -0,0 +1,223 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/codes"
+)
+
+/*
+重试：调用返回 codes.IsRetryable 的错误（建连失败、连接断开等网络错误），或者单次调用超时而整个调用还没有超时时，
+等待一段指数退避的时间后再次调用，每次调用都会通过 Selector 重新选择节点。业务错误不会重试。
+请求已经完整发给 server 之后的失败（比如等待回包时连接断开或者单次调用超时），server 可能已经执行了这个请求，
+只有通过 WithIdempotentMethods 标记为幂等的方法和开启了对冲的方法才会重试，其他方法只重试请求发出之前的失败。
+重试在拦截器之内进行，拦截器只会看到一次调用。
+*/
+
+const (
+	defaultMaxAttempts       = 3
+	defaultInitialBackoff    = 10 * time.Millisecond
+	defaultMaxBackoff        = time.Second
+	defaultBackoffMultiplier = 2.0
+	defaultJitter            = 0.2
+	defaultRetryBudgetTokens = 10
+	defaultRetryBudgetRatio  = 0.1
+)
+
+// RetryPolicy 是调用失败后的重试策略。重试预算在使用同一个 RetryPolicy 的所有调用之间共享，
+// 下游大面积故障时限制重试的比例，避免重试放大下游的流量
+type RetryPolicy struct {
+	maxAttempts       int             // max number of attempts, including the first one
+	initialBackoff    time.Duration   // backoff before the first retry
+	maxBackoff        time.Duration   // upper limit of the backoff
+	backoffMultiplier float64         // the backoff is multiplied by backoffMultiplier after each retry
+	jitter            float64         // the backoff randomly varies by ±jitter
+	perAttemptTimeout time.Duration   // timeout of each attempt, 0 means only the call timeout is used
+	budget            *retryBudget    // nil means no limit
+	idempotentMethods map[string]bool // methods retried even after the request is sent, e.g. : SayHello or /helloworld.Greeter/SayHello
+}
+
+type RetryOption func(*RetryPolicy)
+
+// NewRetryPolicy 创建一个重试策略，默认最多调用 3 次，退避时间从 10ms 开始翻倍，最大 1s
+func NewRetryPolicy(opts ...RetryOption) *RetryPolicy {
+	p := &RetryPolicy{
+		maxAttempts:       defaultMaxAttempts,
+		initialBackoff:    defaultInitialBackoff,
+		maxBackoff:        defaultMaxBackoff,
+		backoffMultiplier: defaultBackoffMultiplier,
+		jitter:            defaultJitter,
+		budget:            newRetryBudget(defaultRetryBudgetTokens, defaultRetryBudgetRatio),
+		idempotentMethods: make(map[string]bool),
+	}
+	for _, o := range opts {
+		o(p)
+	}
+	return p
+}
+
+// WithMaxAttempts 设置最大调用次数，包括第一次调用
+func WithMaxAttempts(maxAttempts int) RetryOption {
+	return func(p *RetryPolicy) {
+		p.maxAttempts = maxAttempts
+	}
+}
+
+// WithBackoff 设置指数退避的参数，第 n 次重试前等待 initial * multiplier^(n-1)，不超过 max
+func WithBackoff(initial, max time.Duration, multiplier float64) RetryOption {
+	return func(p *RetryPolicy) {
+		p.initialBackoff = initial
+		p.maxBackoff = max
+		p.backoffMultiplier = multiplier
+	}
+}
+
+// WithJitter 设置退避时间随机浮动的比例，比如 0.2 表示在 ±20% 之间浮动，避免大量 client 同时重试
+func WithJitter(jitter float64) RetryOption {
+	return func(p *RetryPolicy) {
+		p.jitter = jitter
+	}
+}
+
+// WithPerAttemptTimeout 设置单次调用的超时时间，单次调用超时后如果整个调用还没有超时，会继续重试。
+// 请求发出之后才超时的调用，server 可能还在执行这个请求，只有幂等的方法才会重试，见 WithIdempotentMethods
+func WithPerAttemptTimeout(timeout time.Duration) RetryOption {
+	return func(p *RetryPolicy) {
+		p.perAttemptTimeout = timeout
+	}
+}
+
+// WithIdempotentMethods 设置幂等的方法，方法可以是方法名，比如 SayHello，也可以是服务路径，比如 /helloworld.Greeter/SayHello。
+// 幂等的方法在请求发出之后失败或者超时也会重试，server 可能会多次执行这个请求
+func WithIdempotentMethods(methods ...string) RetryOption {
+	return func(p *RetryPolicy) {
+		for _, m := range methods {
+			p.idempotentMethods[m] = true
+		}
+	}
+}
+
+// WithRetryBudget 设置重试预算：每次可重试的失败消耗一个令牌，每次成功归还 tokenRatio 个令牌，
+// 令牌数不超过 maxTokens 的一半时不再重试。maxTokens <= 0 表示不限制重试
+func WithRetryBudget(maxTokens, tokenRatio float64) RetryOption {
+	return func(p *RetryPolicy) {
+		p.budget = newRetryBudget(maxTokens, tokenRatio)
+	}
+}
+
+// backoff 返回第 attempt 次调用失败后等待的时间
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.initialBackoff) * math.Pow(p.backoffMultiplier, float64(attempt-1))
+	if max := float64(p.maxBackoff); max > 0 && d > max {
+		d = max
+	}
+	if p.jitter > 0 {
+		d *= 1 + p.jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(d)
+}
+
+// idempotent 判断方法是否幂等，开启了对冲的方法也是幂等的
+func (p *RetryPolicy) idempotent(serviceName, method string, hedging *HedgingPolicy) bool {
+	if p.idempotentMethods[method] || p.idempotentMethods["/"+serviceName+"/"+method] {
+		return true
+	}
+	return hedging != nil && hedging.enabled(serviceName, method)
+}
+
+// invokeWithRetry 按重试策略调用 invoke，没有设置重试策略时只调用一次
+func (c *defaultClient) invokeWithRetry(ctx context.Context, req, rsp interface{}) error {
+	p := c.opts.retryPolicy
+	if p == nil {
+		return codes.StripNotSent(c.invoke(ctx, req, rsp))
+	}
+
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if p.perAttemptTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, p.perAttemptTimeout)
+		}
+		err := c.invoke(attemptCtx, req, rsp)
+		// 单次调用超时而整个调用还没有超时，transport 可能在 attemptCtx 的超时生效之前就返回了超时的错误
+		attemptTimeout := (attemptCtx.Err() != nil || errors.Is(err, context.DeadlineExceeded)) && ctx.Err() == nil
+		cancel()
+
+		if err == nil {
+			p.budget.onSuccess()
+			return nil
+		}
+		// transport 用 codes.NotSent 标记请求发出之前的错误，返回给调用方时去掉这个标记
+		sent := !codes.IsNotSent(err)
+		err = codes.StripNotSent(err)
+
+		if !codes.IsRetryable(err) && !attemptTimeout {
+			return err
+		}
+		// 请求发出之后的失败，server 可能已经执行了这个请求，不幂等的方法重试会被重复执行
+		if sent && !p.idempotent(c.opts.serviceName, c.opts.method, c.opts.hedgingPolicy) {
+			return err
+		}
+		if !p.budget.onFailure() || attempt >= p.maxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryBudget 是令牌桶形式的重试预算
+type retryBudget struct {
+	mu         sync.Mutex
+	tokens     float64
+	maxTokens  float64
+	tokenRatio float64
+}
+
+func newRetryBudget(maxTokens, tokenRatio float64) *retryBudget {
+	if maxTokens <= 0 {
+		return nil
+	}
+	return &retryBudget{
+		tokens:     maxTokens,
+		maxTokens:  maxTokens,
+		tokenRatio: tokenRatio,
+	}
+}
+
+func (b *retryBudget) onSuccess() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += b.tokenRatio
+	if b.tokens > b.maxTokens {
+		b.tokens = b.maxTokens
+	}
+}
+
+// onFailure 记录一次可重试的失败，返回是否还允许重试
+func (b *retryBudget) onFailure() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens > 0 {
+		b.tokens--
+	}
+	return b.tokens > b.maxTokens/2
+}
@@ -0,0 +1,317 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	github "github.com/junaozun/go-lrpxc"
+	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/interceptor"
+	"github.com/junaozun/go-lrpxc/selector"
+)
+
+type testReq struct{ Msg string }
+type testRsp struct{ Msg string }
+
+// testService 记录每个方法被调用的次数
+type testService struct {
+	ok, biz, slow int32
+}
+
+func (s *testService) Ok(ctx context.Context, req *testReq) (*testRsp, error) {
+	atomic.AddInt32(&s.ok, 1)
+	return &testRsp{Msg: "ok"}, nil
+}
+
+func (s *testService) Biz(ctx context.Context, req *testReq) (*testRsp, error) {
+	atomic.AddInt32(&s.biz, 1)
+	return nil, codes.New(1000, "biz")
+}
+
+// Slow 的第一次调用在 300ms 后才返回
+func (s *testService) Slow(ctx context.Context, req *testReq) (*testRsp, error) {
+	if atomic.AddInt32(&s.slow, 1) == 1 {
+		time.Sleep(300 * time.Millisecond)
+		return &testRsp{Msg: "slow"}, nil
+	}
+	return &testRsp{Msg: "fast"}, nil
+}
+
+type testHandler interface {
+	Ok(context.Context, *testReq) (*testRsp, error)
+	Biz(context.Context, *testReq) (*testRsp, error)
+	Slow(context.Context, *testReq) (*testRsp, error)
+}
+
+// testServiceDesc 通过 ServiceDesc 注册 testService，业务错误会返回给 client
+var testServiceDesc = &github.ServiceDesc{
+	ServiceName: "test.Service",
+	HandlerType: (*testHandler)(nil),
+	Methods: []*github.MethodDesc{
+		testMethod("Ok", (*testService).Ok),
+		testMethod("Biz", (*testService).Biz),
+		testMethod("Slow", (*testService).Slow),
+	},
+	Streams: []*github.StreamDesc{
+		{StreamName: "Stall", Handler: stallStream, ClientStreams: true},
+	},
+}
+
+func testMethod(name string, fn func(*testService, context.Context, *testReq) (*testRsp, error)) *github.MethodDesc {
+	return &github.MethodDesc{
+		MethodName: name,
+		Handler: func(ctx context.Context, svr interface{}, dec func(interface{}) error, ceps []interceptor.ServerInterceptor) (interface{}, error) {
+			req := &testReq{}
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			return fn(svr.(*testService), ctx, req)
+		},
+	}
+}
+
+var (
+	serverOnce sync.Once
+	serverAddr string
+	service    = &testService{}
+)
+
+// testServer 启动包内所有测试共用的 server，返回监听的地址。server transport 是单例，整个包只启动一个 server
+func testServer(t *testing.T) string {
+	serverOnce.Do(func() {
+		serverAddr = freeAddr(t)
+		s := github.NewServer(github.WithAddress(serverAddr), github.WithNetwork("tcp"), github.WithSerializationType("msgpack"))
+		s.Register(testServiceDesc, service)
+		go s.Serve()
+
+		for i := 0; i < 50; i++ {
+			if conn, err := net.Dial("tcp", serverAddr); err == nil {
+				conn.Close()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	return serverAddr
+}
+
+// freeAddr 返回一个当前没有被监听的地址，向它建连会被拒绝
+func freeAddr(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+// listSelector 依次返回 addrs 中没有被排除的第一个地址，并记录每次选择的结果
+type listSelector struct {
+	addrs []string
+
+	mu       sync.Mutex
+	selected []string
+}
+
+func (s *listSelector) Select(serviceName string, opts ...selector.Option) (string, error) {
+	o := &selector.Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 没有排除的节点时轮流返回，否则返回第一个没有被排除的节点
+	addr := s.addrs[len(s.selected)%len(s.addrs)]
+	if len(o.Exclude) > 0 {
+		for _, a := range s.addrs {
+			if !contains(o.Exclude, a) {
+				addr = a
+				break
+			}
+		}
+	}
+	s.selected = append(s.selected, addr)
+	return addr, nil
+}
+
+func contains(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *listSelector) attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.selected)
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := NewRetryPolicy(WithBackoff(10*time.Millisecond, 50*time.Millisecond, 2), WithJitter(0))
+
+	want := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond}
+	for i, w := range want {
+		if d := p.backoff(i + 1); d != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, d, w)
+		}
+	}
+
+	p = NewRetryPolicy(WithBackoff(100*time.Millisecond, time.Second, 2), WithJitter(0.2))
+	for i := 0; i < 100; i++ {
+		if d := p.backoff(1); d < 80*time.Millisecond || d > 120*time.Millisecond {
+			t.Fatalf("backoff with jitter 0.2 = %v, want within [80ms, 120ms]", d)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := newRetryBudget(4, 0.5)
+
+	// 令牌数不超过 maxTokens 的一半时不再重试
+	if !b.onFailure() {
+		t.Fatal("retry denied with 3 tokens left")
+	}
+	if b.onFailure() {
+		t.Fatal("retry allowed with 2 tokens left")
+	}
+
+	// 每次成功归还 0.5 个令牌
+	b.onSuccess()
+	b.onSuccess()
+	b.onSuccess()
+	if !b.onFailure() {
+		t.Fatal("retry denied after successes refilled the budget")
+	}
+
+	for i := 0; i < 100; i++ {
+		b.onSuccess()
+	}
+	if b.tokens != b.maxTokens {
+		t.Fatalf("tokens = %v, want capped at %v", b.tokens, b.maxTokens)
+	}
+
+	if newRetryBudget(0, 0) != nil || !(*retryBudget)(nil).onFailure() {
+		t.Fatal("a budget with no tokens should not limit retries")
+	}
+}
+
+func TestRetryNetworkError(t *testing.T) {
+	addr := testServer(t)
+	dead := freeAddr(t)
+
+	// 第一次选到没有监听的节点，建连失败后重试选到正常的节点
+	sel := &listSelector{addrs: []string{dead, addr}}
+	selector.RegisterSelector("test-retry-network", sel)
+
+	p := NewRetryPolicy(WithRetryBudget(0, 0), WithBackoff(time.Millisecond, time.Millisecond, 1))
+	rsp := &testRsp{}
+	err := DefaultClient.Call(context.Background(), "/test.Service/Ok", &testReq{}, rsp,
+		WithNetwork("tcp"), WithSelectorName("test-retry-network"), WithTimeout(2*time.Second), WithRetryPolicy(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Msg != "ok" {
+		t.Fatalf("rsp = %q, want ok", rsp.Msg)
+	}
+	if n := sel.attempts(); n != 2 {
+		t.Fatalf("%d attempts, want 2", n)
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	sel := &listSelector{addrs: []string{freeAddr(t)}}
+	selector.RegisterSelector("test-retry-dead", sel)
+
+	p := NewRetryPolicy(WithRetryBudget(0, 0), WithMaxAttempts(3), WithBackoff(time.Millisecond, time.Millisecond, 1))
+	err := DefaultClient.Call(context.Background(), "/test.Service/Ok", &testReq{}, &testRsp{},
+		WithNetwork("tcp"), WithSelectorName("test-retry-dead"), WithTimeout(2*time.Second), WithRetryPolicy(p))
+	if !codes.IsRetryable(err) || codes.IsNotSent(err) {
+		t.Fatalf("got %v, want a retryable error without the not sent mark", err)
+	}
+	if n := sel.attempts(); n != 3 {
+		t.Fatalf("%d attempts, want 3", n)
+	}
+}
+
+func TestRetrySkipsBusinessError(t *testing.T) {
+	addr := testServer(t)
+	before := atomic.LoadInt32(&service.biz)
+
+	p := NewRetryPolicy(WithRetryBudget(0, 0), WithBackoff(time.Millisecond, time.Millisecond, 1))
+	err := DefaultClient.Call(context.Background(), "/test.Service/Biz", &testReq{}, &testRsp{},
+		WithNetwork("tcp"), WithTarget(addr), WithTimeout(2*time.Second), WithRetryPolicy(p))
+	if err == nil || codes.IsRetryable(err) {
+		t.Fatalf("got %v, want a business error", err)
+	}
+	if n := atomic.LoadInt32(&service.biz) - before; n != 1 {
+		t.Fatalf("business error was called %d times, want 1", n)
+	}
+}
+
+func TestRetryPerAttemptTimeout(t *testing.T) {
+	addr := testServer(t)
+	atomic.StoreInt32(&service.slow, 0)
+
+	p := NewRetryPolicy(WithRetryBudget(0, 0), WithPerAttemptTimeout(100*time.Millisecond), WithBackoff(time.Millisecond, time.Millisecond, 1),
+		WithIdempotentMethods("Slow"))
+	rsp := &testRsp{}
+	start := time.Now()
+	err := DefaultClient.Call(context.Background(), "/test.Service/Slow", &testReq{}, rsp,
+		WithNetwork("tcp"), WithTarget(addr), WithTimeout(2*time.Second), WithRetryPolicy(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Msg != "fast" {
+		t.Fatalf("rsp = %q, want the retried attempt", rsp.Msg)
+	}
+	if d := time.Since(start); d > 250*time.Millisecond {
+		t.Fatalf("call took %v, the slow attempt was not abandoned", d)
+	}
+}
+
+func TestRetryPolicyIdempotent(t *testing.T) {
+	p := NewRetryPolicy(WithIdempotentMethods("Read", "/test.Service/Get"))
+	hedging := NewHedgingPolicy(time.Millisecond, WithHedgingMethods("Query"))
+
+	cases := []struct {
+		service, method string
+		want            bool
+	}{
+		{"test.Service", "Read", true},
+		{"test.Service", "Get", true},
+		{"other.Service", "Get", false},
+		{"test.Service", "Query", true},
+		{"test.Service", "Write", false},
+	}
+	for _, c := range cases {
+		if got := p.idempotent(c.service, c.method, hedging); got != c.want {
+			t.Errorf("idempotent(%q, %q) = %v, want %v", c.service, c.method, got, c.want)
+		}
+	}
+}
+
+func TestRetrySkipsSentNonIdempotent(t *testing.T) {
+	addr := testServer(t)
+	atomic.StoreInt32(&service.slow, 0)
+
+	// 请求已经发给 server 之后才超时，不幂等的方法不会重试
+	p := NewRetryPolicy(WithRetryBudget(0, 0), WithPerAttemptTimeout(100*time.Millisecond), WithBackoff(time.Millisecond, time.Millisecond, 1))
+	err := DefaultClient.Call(context.Background(), "/test.Service/Slow", &testReq{}, &testRsp{},
+		WithNetwork("tcp"), WithTarget(addr), WithTimeout(2*time.Second), WithRetryPolicy(p))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the per-attempt timeout", err)
+	}
+	if n := atomic.LoadInt32(&service.slow); n != 1 {
+		t.Fatalf("Slow was called %d times, want 1", n)
+	}
+}
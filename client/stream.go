@@ -0,0 +1,245 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/junaozun/go-lrpxc/codec"
+	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/metadata"
+	"github.com/junaozun/go-lrpxc/protocol"
+	"github.com/junaozun/go-lrpxc/serialization"
+	"github.com/junaozun/go-lrpxc/transport/client_transport"
+	"github.com/junaozun/go-lrpxc/utils"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// StreamDesc 描述一个流式方法，决定帧头中的请求类型
+type StreamDesc struct {
+	ServerStreams bool // the server sends a stream of messages
+	ClientStreams bool // the client sends a stream of messages
+}
+
+func (desc *StreamDesc) reqType() uint8 {
+	switch {
+	case desc.ClientStreams && desc.ServerStreams:
+		return codec.ReqTypeBidiStream
+	case desc.ClientStreams:
+		return codec.ReqTypeClientStream
+	default:
+		return codec.ReqTypeServerStream
+	}
+}
+
+// ClientStream 是流式调用在 client 端的流，流建立在多路复用连接上。
+// 流结束前（RecvMsg 返回 error）需要取消 ctx，否则流会一直占用流 ID
+type ClientStream interface {
+	Context() context.Context
+	// SendMsg 发送一条消息
+	SendMsg(m interface{}) error
+	// RecvMsg 接收一条消息，server 正常结束流时返回 io.EOF，否则返回 server 返回的错误
+	RecvMsg(m interface{}) error
+	// CloseSend 结束发送，server 端的 RecvMsg 会返回 io.EOF
+	CloseSend() error
+}
+
+// NewStream 在多路复用连接上建立一个流，并发送带有服务路径和元数据的第一帧。
+// ClientOption 中的超时时间作用于整个流，ctx 取消时会通知 server 取消流
+func (c *defaultClient) NewStream(ctx context.Context, desc *StreamDesc, path string, opts ...ClientOption) (ClientStream, error) {
+	c = c.clone()
+	for _, o := range opts {
+		o(c.opts)
+	}
+
+	serviceName, method, err := utils.ParseServicePath(path)
+	if err != nil {
+		return nil, err
+	}
+	WithMethod(method)(c.opts)
+	WithServiceName(serviceName)(c.opts)
+	if err := c.resolveTarget(); err != nil {
+		return nil, err
+	}
+
+	var cancel context.CancelFunc
+	if c.opts.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.opts.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	ts, err := c.NewClientTransport().NewStream(ctx, c.transportOptions()...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	cs := &clientStream{
+		ctx:           ctx,
+		cancel:        cancel,
+		opts:          c.opts,
+		reqType:       desc.reqType(),
+		stream:        ts,
+		codec:         codec.GetFrameCodec(c.opts.protocol),
+		serialization: serialization.GetSerialization(c.opts.serializationType),
+	}
+
+	request := &protocol.Request{
+		ServicePath: fmt.Sprintf("/%s/%s", serviceName, method),
+		Metadata:    metadata.ClientMetadata(ctx),
+	}
+	if err = cs.send(codec.StreamFrameInit, request); err != nil {
+		cancel()
+		ts.Close()
+		return nil, err
+	}
+
+	go cs.watch()
+
+	return cs, nil
+}
+
+type clientStream struct {
+	ctx           context.Context
+	cancel        context.CancelFunc
+	opts          *ClientOptions
+	reqType       uint8
+	stream        client_transport.Stream
+	codec         codec.FrameCodec
+	serialization serialization.Serialization
+
+	mu      sync.Mutex
+	recvErr error // the error that finishes the stream, io.EOF if the server finishes normally
+	ended   bool  // whether the server has sent the end frame
+}
+
+func (cs *clientStream) Context() context.Context {
+	return cs.ctx
+}
+
+func (cs *clientStream) SendMsg(m interface{}) error {
+	if cs.finished() {
+		return io.EOF
+	}
+	if err := cs.ctx.Err(); err != nil {
+		return err
+	}
+
+	payload, err := cs.serialization.Marshal(m)
+	if err != nil {
+		return codes.NewFrameworkError(codes.ClientMsgErrorCode, "request marshal failed ...")
+	}
+
+	return cs.send(codec.StreamFrameData, &protocol.Request{Payload: payload})
+}
+
+func (cs *clientStream) CloseSend() error {
+	if cs.finished() {
+		return nil
+	}
+	return cs.send(codec.StreamFrameEnd, nil)
+}
+
+func (cs *clientStream) RecvMsg(m interface{}) error {
+	cs.mu.Lock()
+	recvErr := cs.recvErr
+	cs.mu.Unlock()
+	if recvErr != nil {
+		return recvErr
+	}
+
+	frame, err := cs.stream.Recv(cs.ctx)
+	if err != nil {
+		cs.finish(err)
+		return err
+	}
+
+	header, rspbuf, err := cs.codec.DecodeFrame(frame)
+	if err != nil {
+		cs.finish(err)
+		return err
+	}
+
+	response := &protocol.Response{}
+	if err = proto.Unmarshal(rspbuf, response); err != nil {
+		cs.finish(err)
+		return err
+	}
+
+	// 结束帧中带有 server 处理流的返回码
+	if header.Reserved == codec.StreamFrameEnd {
+		err = io.EOF
+		if response.RetCode != 0 {
+			err = codes.New(response.RetCode, response.RetMsg)
+		}
+		cs.mu.Lock()
+		cs.ended = true
+		cs.mu.Unlock()
+		cs.finish(err)
+		return err
+	}
+
+	return cs.serialization.Unmarshal(response.Payload, m)
+}
+
+// send 编码并发送流上的一帧，request 为 nil 时发送没有包体的控制帧
+func (cs *clientStream) send(frameType uint32, request *protocol.Request) error {
+	var reqbuf []byte
+	if request != nil {
+		var err error
+		if reqbuf, err = proto.Marshal(request); err != nil {
+			return err
+		}
+	}
+
+	compressType, err := codec.GetCompressType(cs.opts.compressor, len(reqbuf), cs.opts.compressMinSize)
+	if err != nil {
+		return codes.NewFrameworkError(codes.ConfigErrorCode, err.Error())
+	}
+
+	header := &codec.FrameHeader{
+		ReqType:      cs.reqType,
+		Reserved:     frameType,
+		CompressType: compressType,
+	}
+	frame, err := cs.codec.EncodeFrame(header, reqbuf)
+	if err != nil {
+		return err
+	}
+
+	return cs.stream.Send(frame)
+}
+
+// finish 记录流结束的原因，之后的 RecvMsg 都返回这个错误
+func (cs *clientStream) finish(err error) {
+	cs.mu.Lock()
+	if cs.recvErr == nil {
+		cs.recvErr = err
+	}
+	cs.mu.Unlock()
+
+	cs.cancel()
+}
+
+func (cs *clientStream) finished() bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.recvErr != nil
+}
+
+// watch 在 ctx 结束后释放流，流还没有结束时通知 server 取消流
+func (cs *clientStream) watch() {
+	<-cs.ctx.Done()
+
+	cs.mu.Lock()
+	ended := cs.ended
+	cs.mu.Unlock()
+
+	if !ended {
+		cs.send(codec.StreamFrameCancel, nil)
+	}
+	cs.stream.Close()
+}
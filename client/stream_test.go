@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	github "github.com/junaozun/go-lrpxc"
+	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/selector"
+)
+
+// stallStream 从不读取 client 发送的消息，直到流被取消
+func stallStream(svr interface{}, stream github.ServerStream) error {
+	<-stream.Context().Done()
+	return stream.Context().Err()
+}
+
+func TestStalledStreamDoesNotBlockConnection(t *testing.T) {
+	addr := testServer(t)
+	selector.RegisterSelector("test-stream-stall", &listSelector{addrs: []string{addr}})
+	opts := []ClientOption{WithNetwork("tcp"), WithSelectorName("test-stream-stall"),
+		WithSerializationType("msgpack"), WithMultiplexed(true)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cs, err := DefaultClient.NewStream(ctx, &StreamDesc{ClientStreams: true}, "/test.Service/Stall", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 发送的消息超过 server 为一个流缓存的上限
+	for i := 0; i < 200; i++ {
+		if err := cs.SendMsg(&testReq{Msg: "stall"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 同一条多路复用连接上的普通请求不会被阻塞
+	start := time.Now()
+	rsp := &testRsp{}
+	err = DefaultClient.Call(context.Background(), "/test.Service/Ok", &testReq{}, rsp,
+		append(opts, WithTimeout(time.Second))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("unary call took %v behind the stalled stream", d)
+	}
+
+	// 只有发送过快的流被重置
+	var e *codes.Error
+	if err = cs.RecvMsg(&testRsp{}); !errors.As(err, &e) || e.Code != codes.ClientMsgErrorCode {
+		t.Fatalf("RecvMsg got %v, want the stream to be reset", err)
+	}
+}
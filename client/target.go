@@ -0,0 +1,56 @@
+package client
+
+import (
+	"github.com/junaozun/go-lrpxc/selector"
+
+	// 注册 static、dns、file 三种 target scheme，consul 需要业务引入 plugin/consul
+	_ "github.com/junaozun/go-lrpxc/selector/dns"
+	_ "github.com/junaozun/go-lrpxc/selector/file"
+	_ "github.com/junaozun/go-lrpxc/selector/static"
+)
+
+const (
+	schemeIP   = "ip"
+	schemeUnix = "unix"
+)
+
+// resolveTarget 解析带 scheme 的 target，设置调用使用的 Selector、地址和网络类型：
+// ip://127.0.0.1:8000 直接使用这个地址，unix:///tmp/x.sock 通过 unix socket 调用这个路径，
+// 其他 scheme（比如 static、dns、file、consul）通过 scheme 注册的 Builder 创建 Selector，query 中的 balancer 设置负载均衡方式。
+// 所有 scheme 都可以通过 query 中的 network 设置网络类型，比如 ?network=udp
+func (c *defaultClient) resolveTarget() error {
+	t, err := selector.ParseTarget(c.opts.target)
+	if err != nil {
+		return err
+	}
+	if t.Scheme == "" {
+		return nil
+	}
+
+	switch t.Scheme {
+	case schemeIP:
+		c.opts.target = t.Authority
+	case schemeUnix:
+		c.opts.target = t.Path()
+		c.opts.network = schemeUnix
+	default:
+		s, err := selector.BuildSelector(c.opts.target)
+		if err != nil {
+			return err
+		}
+		c.opts.targetSelector = s
+	}
+
+	if network := t.Query.Get("network"); network != "" {
+		c.opts.network = network
+	}
+	return nil
+}
+
+// selector 返回调用使用的 Selector，target 创建的 Selector 优先于 WithSelectorName 设置的 Selector
+func (c *defaultClient) selector() selector.Selector {
+	if c.opts.targetSelector != nil {
+		return c.opts.targetSelector
+	}
+	return selector.GetSelector(c.opts.selectorName)
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const (
+	contextPackage     = protogen.GoImportPath("context")
+	ioPackage          = protogen.GoImportPath("io")
+	lrpcxPackage       = protogen.GoImportPath("github.com/junaozun/go-lrpxc")
+	clientPackage      = protogen.GoImportPath("github.com/junaozun/go-lrpxc/client")
+	interceptorPackage = protogen.GoImportPath("github.com/junaozun/go-lrpxc/interceptor")
+)
+
+// generateFile 为一个 .proto 文件生成 xxx.lrpcx.go，文件中没有 service 定义时不生成
+func generateFile(gen *protogen.Plugin, file *protogen.File) (*protogen.GeneratedFile, error) {
+	if len(file.Services) == 0 {
+		return nil, nil
+	}
+
+	filename := file.GeneratedFilenamePrefix + ".lrpcx.go"
+	g := gen.NewGeneratedFile(filename, file.GoImportPath)
+
+	g.P("// Code generated by protoc-gen-lrpcx. DO NOT EDIT.")
+	g.P("// versions:")
+	g.P("// - protoc-gen-lrpcx ", version)
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+
+	for _, service := range file.Services {
+		generateService(g, service)
+	}
+
+	return g, nil
+}
+
+// generateService 生成服务端的 XxxService 接口、方法的 handler、ServiceDesc 描述表和 RegisterXxxService 注册函数，
+// 以及客户端的 XxxClientProxy 代理。流式方法额外生成服务端和客户端的流类型
+func generateService(g *protogen.GeneratedFile, service *protogen.Service) {
+	serviceName := string(service.Desc.FullName())
+	serverType := service.GoName + "Service"
+	descName := "_" + service.GoName + "_serviceDesc"
+
+	// server interface
+	g.P("// ", serverType, " is the server API for ", service.GoName, " service.")
+	g.P("type ", serverType, " interface {")
+	for _, method := range service.Methods {
+		g.P(serverSignature(g, service, method))
+	}
+	g.P("}")
+	g.P()
+
+	// method handlers
+	for _, method := range service.Methods {
+		if isStreaming(method) {
+			generateStreamHandler(g, service, serverType, method)
+			continue
+		}
+		generateHandler(g, serverType, method)
+	}
+
+	// service description
+	g.P("var ", descName, " = &", g.QualifiedGoIdent(lrpcxPackage.Ident("ServiceDesc")), "{")
+	g.P("ServiceName: ", fmt.Sprintf("%q", serviceName), ",")
+	g.P("HandlerType: (*", serverType, ")(nil),")
+	g.P("Methods: []*", g.QualifiedGoIdent(lrpcxPackage.Ident("MethodDesc")), "{")
+	for _, method := range service.Methods {
+		if isStreaming(method) {
+			continue
+		}
+		g.P("{")
+		g.P("MethodName: ", fmt.Sprintf("%q", method.Desc.Name()), ",")
+		g.P("Handler: ", handlerName(serverType, method), ",")
+		g.P("},")
+	}
+	g.P("},")
+	g.P("Streams: []*", g.QualifiedGoIdent(lrpcxPackage.Ident("StreamDesc")), "{")
+	for _, method := range service.Methods {
+		if !isStreaming(method) {
+			continue
+		}
+		g.P("{")
+		g.P("StreamName: ", fmt.Sprintf("%q", method.Desc.Name()), ",")
+		g.P("Handler: ", streamHandlerName(serverType, method), ",")
+		g.P("ServerStreams: ", method.Desc.IsStreamingServer(), ",")
+		g.P("ClientStreams: ", method.Desc.IsStreamingClient(), ",")
+		g.P("},")
+	}
+	g.P("},")
+	g.P("}")
+	g.P()
+
+	// register function
+	g.P("// Register", serverType, " registers svr to the server s as ", serviceName)
+	g.P("func Register", serverType, "(s *", g.QualifiedGoIdent(lrpcxPackage.Ident("Server")), ", svr ", serverType, ") {")
+	g.P("s.Register(", descName, ", svr)")
+	g.P("}")
+	g.P()
+
+	generateClientProxy(g, service)
+}
+
+func handlerName(serverType string, method *protogen.Method) string {
+	return serverType + "_" + method.GoName + "_Handler"
+}
+
+func streamHandlerName(serverType string, method *protogen.Method) string {
+	return serverType + "_" + method.GoName + "_StreamHandler"
+}
+
+func isStreaming(method *protogen.Method) bool {
+	return method.Desc.IsStreamingClient() || method.Desc.IsStreamingServer()
+}
+
+// streamTypeName 返回流式方法的流类型名，side 为 Server 或 Client，exported 为 false 时返回流类型实现的类型名
+func streamTypeName(service *protogen.Service, method *protogen.Method, side string, exported bool) string {
+	if exported {
+		return service.GoName + "_" + method.GoName + side
+	}
+	return strings.ToLower(service.GoName[:1]) + service.GoName[1:] + method.GoName + side
+}
+
+// serverSignature 返回服务端接口中方法的签名，服务端流式方法的请求作为参数传入，客户端流式和双向流式方法的请求从流中读取
+func serverSignature(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method) string {
+	if !isStreaming(method) {
+		return method.GoName + "(ctx " + g.QualifiedGoIdent(contextPackage.Ident("Context")) + ", req *" + g.QualifiedGoIdent(method.Input.GoIdent) + ") (*" + g.QualifiedGoIdent(method.Output.GoIdent) + ", error)"
+	}
+
+	streamType := streamTypeName(service, method, "Server", true)
+	if method.Desc.IsStreamingClient() {
+		return method.GoName + "(stream " + streamType + ") error"
+	}
+	return method.GoName + "(req *" + g.QualifiedGoIdent(method.Input.GoIdent) + ", stream " + streamType + ") error"
+}
+
+// generateHandler 生成一个方法的 handler，先通过 dec 反序列化出请求体，再经过拦截器链调用业务实现
+func generateHandler(g *protogen.GeneratedFile, serverType string, method *protogen.Method) {
+	ctxType := g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	reqType := g.QualifiedGoIdent(method.Input.GoIdent)
+
+	g.P("func ", handlerName(serverType, method), "(ctx ", ctxType, ", svr interface{}, dec func(interface{}) error, ceps []", g.QualifiedGoIdent(interceptorPackage.Ident("ServerInterceptor")), ") (interface{}, error) {")
+	g.P("req := new(", reqType, ")")
+	g.P("if err := dec(req); err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P()
+	g.P("if len(ceps) == 0 {")
+	g.P("return svr.(", serverType, ").", method.GoName, "(ctx, req)")
+	g.P("}")
+	g.P()
+	g.P("handler := func(ctx ", ctxType, ", reqbody interface{}) (interface{}, error) {")
+	g.P("return svr.(", serverType, ").", method.GoName, "(ctx, reqbody.(*", reqType, "))")
+	g.P("}")
+	g.P()
+	g.P("return ", g.QualifiedGoIdent(interceptorPackage.Ident("ServerIntercept")), "(ctx, req, ceps, handler)")
+	g.P("}")
+	g.P()
+}
+
+// generateStreamHandler 生成流式方法的服务端流类型和 stream handler
+func generateStreamHandler(g *protogen.GeneratedFile, service *protogen.Service, serverType string, method *protogen.Method) {
+	streamType := streamTypeName(service, method, "Server", true)
+	implType := streamTypeName(service, method, "Server", false)
+	reqType := g.QualifiedGoIdent(method.Input.GoIdent)
+	rspType := g.QualifiedGoIdent(method.Output.GoIdent)
+
+	g.P("type ", streamType, " interface {")
+	if method.Desc.IsStreamingServer() {
+		g.P("Send(*", rspType, ") error")
+	} else {
+		g.P("SendAndClose(*", rspType, ") error")
+	}
+	if method.Desc.IsStreamingClient() {
+		g.P("Recv() (*", reqType, ", error)")
+	}
+	g.P(g.QualifiedGoIdent(lrpcxPackage.Ident("ServerStream")))
+	g.P("}")
+	g.P()
+
+	g.P("type ", implType, " struct {")
+	g.P(g.QualifiedGoIdent(lrpcxPackage.Ident("ServerStream")))
+	g.P("}")
+	g.P()
+
+	if method.Desc.IsStreamingServer() {
+		g.P("func (x *", implType, ") Send(m *", rspType, ") error {")
+		g.P("return x.ServerStream.SendMsg(m)")
+		g.P("}")
+		g.P()
+	} else {
+		g.P("func (x *", implType, ") SendAndClose(m *", rspType, ") error {")
+		g.P("return x.ServerStream.SendMsg(m)")
+		g.P("}")
+		g.P()
+	}
+	if method.Desc.IsStreamingClient() {
+		g.P("func (x *", implType, ") Recv() (*", reqType, ", error) {")
+		g.P("m := new(", reqType, ")")
+		g.P("if err := x.ServerStream.RecvMsg(m); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return m, nil")
+		g.P("}")
+		g.P()
+	}
+
+	g.P("func ", streamHandlerName(serverType, method), "(svr interface{}, stream ", g.QualifiedGoIdent(lrpcxPackage.Ident("ServerStream")), ") error {")
+	if method.Desc.IsStreamingClient() {
+		g.P("return svr.(", serverType, ").", method.GoName, "(&", implType, "{stream})")
+	} else {
+		g.P("req := new(", reqType, ")")
+		g.P("if err := stream.RecvMsg(req); err != nil {")
+		g.P("return err")
+		g.P("}")
+		g.P("return svr.(", serverType, ").", method.GoName, "(req, &", implType, "{stream})")
+	}
+	g.P("}")
+	g.P()
+}
+
+// generateClientProxy 生成客户端代理，普通方法最终都通过 client.Client 的 Invoke 发起调用，流式方法通过 NewStream 建立流
+func generateClientProxy(g *protogen.GeneratedFile, service *protogen.Service) {
+	serviceName := string(service.Desc.FullName())
+	proxyType := service.GoName + "ClientProxy"
+	implType := proxyType + "Impl"
+	optionType := g.QualifiedGoIdent(clientPackage.Ident("ClientOption"))
+
+	g.P("// ", proxyType, " is the client API for ", service.GoName, " service.")
+	g.P("type ", proxyType, " interface {")
+	for _, method := range service.Methods {
+		g.P(clientSignature(g, service, method))
+	}
+	g.P("}")
+	g.P()
+
+	g.P("type ", implType, " struct {")
+	g.P("client ", g.QualifiedGoIdent(clientPackage.Ident("Client")))
+	g.P("opts []", optionType)
+	g.P("}")
+	g.P()
+
+	g.P("// New", proxyType, " creates a client proxy of ", service.GoName, " service, opts take effect on every call")
+	g.P("func New", proxyType, "(opts ...", optionType, ") ", proxyType, " {")
+	g.P("return &", implType, "{client: ", g.QualifiedGoIdent(clientPackage.Ident("DefaultClient")), ", opts: opts}")
+	g.P("}")
+	g.P()
+
+	for _, method := range service.Methods {
+		if isStreaming(method) {
+			generateClientStream(g, service, implType, method)
+			continue
+		}
+		rspType := g.QualifiedGoIdent(method.Output.GoIdent)
+		g.P("func (c *", implType, ") ", clientSignature(g, service, method), " {")
+		g.P("callopts := make([]", optionType, ", 0, len(c.opts)+len(opts))")
+		g.P("callopts = append(callopts, c.opts...)")
+		g.P("callopts = append(callopts, opts...)")
+		g.P("rsp := &", rspType, "{}")
+		g.P("err := c.client.Invoke(ctx, req, rsp, ", fmt.Sprintf("%q", "/"+serviceName+"/"+string(method.Desc.Name())), ", callopts...)")
+		g.P("if err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return rsp, nil")
+		g.P("}")
+		g.P()
+	}
+}
+
+// clientSignature 返回客户端代理中方法的签名，流式方法返回客户端流类型
+func clientSignature(g *protogen.GeneratedFile, service *protogen.Service, method *protogen.Method) string {
+	ctxType := g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	optionType := g.QualifiedGoIdent(clientPackage.Ident("ClientOption"))
+	reqType := g.QualifiedGoIdent(method.Input.GoIdent)
+
+	if !isStreaming(method) {
+		return method.GoName + "(ctx " + ctxType + ", req *" + reqType + ", opts ..." + optionType + ") (*" + g.QualifiedGoIdent(method.Output.GoIdent) + ", error)"
+	}
+
+	streamType := streamTypeName(service, method, "Client", true)
+	if method.Desc.IsStreamingClient() {
+		return method.GoName + "(ctx " + ctxType + ", opts ..." + optionType + ") (" + streamType + ", error)"
+	}
+	return method.GoName + "(ctx " + ctxType + ", req *" + reqType + ", opts ..." + optionType + ") (" + streamType + ", error)"
+}
+
+// generateClientStream 生成流式方法的客户端流类型和代理方法，服务端流式方法建立流后直接发送请求并结束发送
+func generateClientStream(g *protogen.GeneratedFile, service *protogen.Service, proxyImplType string, method *protogen.Method) {
+	serviceName := string(service.Desc.FullName())
+	streamType := streamTypeName(service, method, "Client", true)
+	implType := streamTypeName(service, method, "Client", false)
+	optionType := g.QualifiedGoIdent(clientPackage.Ident("ClientOption"))
+	reqType := g.QualifiedGoIdent(method.Input.GoIdent)
+	rspType := g.QualifiedGoIdent(method.Output.GoIdent)
+
+	g.P("type ", streamType, " interface {")
+	if method.Desc.IsStreamingClient() {
+		g.P("Send(*", reqType, ") error")
+	}
+	if method.Desc.IsStreamingServer() {
+		g.P("Recv() (*", rspType, ", error)")
+	} else {
+		g.P("CloseAndRecv() (*", rspType, ", error)")
+	}
+	g.P(g.QualifiedGoIdent(clientPackage.Ident("ClientStream")))
+	g.P("}")
+	g.P()
+
+	g.P("type ", implType, " struct {")
+	g.P(g.QualifiedGoIdent(clientPackage.Ident("ClientStream")))
+	g.P("}")
+	g.P()
+
+	if method.Desc.IsStreamingClient() {
+		g.P("func (x *", implType, ") Send(m *", reqType, ") error {")
+		g.P("return x.ClientStream.SendMsg(m)")
+		g.P("}")
+		g.P()
+	}
+	if method.Desc.IsStreamingServer() {
+		g.P("func (x *", implType, ") Recv() (*", rspType, ", error) {")
+		g.P("m := new(", rspType, ")")
+		g.P("if err := x.ClientStream.RecvMsg(m); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return m, nil")
+		g.P("}")
+		g.P()
+	} else {
+		g.P("func (x *", implType, ") CloseAndRecv() (*", rspType, ", error) {")
+		g.P("if err := x.ClientStream.CloseSend(); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("m := new(", rspType, ")")
+		g.P("if err := x.ClientStream.RecvMsg(m); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("// read the end frame to release the stream")
+		g.P("if err := x.ClientStream.RecvMsg(new(", rspType, ")); err != ", g.QualifiedGoIdent(ioPackage.Ident("EOF")), " {")
+		g.P("return m, err")
+		g.P("}")
+		g.P("return m, nil")
+		g.P("}")
+		g.P()
+	}
+
+	g.P("func (c *", proxyImplType, ") ", clientSignature(g, service, method), " {")
+	g.P("callopts := make([]", optionType, ", 0, len(c.opts)+len(opts))")
+	g.P("callopts = append(callopts, c.opts...)")
+	g.P("callopts = append(callopts, opts...)")
+	g.P("desc := &", g.QualifiedGoIdent(clientPackage.Ident("StreamDesc")), "{ServerStreams: ", method.Desc.IsStreamingServer(), ", ClientStreams: ", method.Desc.IsStreamingClient(), "}")
+	g.P("stream, err := c.client.NewStream(ctx, desc, ", fmt.Sprintf("%q", "/"+serviceName+"/"+string(method.Desc.Name())), ", callopts...)")
+	g.P("if err != nil {")
+	g.P("return nil, err")
+	g.P("}")
+	g.P("x := &", implType, "{stream}")
+	if !method.Desc.IsStreamingClient() {
+		g.P("if err := x.ClientStream.SendMsg(req); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("if err := x.ClientStream.CloseSend(); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+	}
+	g.P("return x, nil")
+	g.P("}")
+	g.P()
+}
@@ -0,0 +1,41 @@
+// protoc-gen-lrpcx 是 protoc 的插件，根据 .proto 文件中的 service 定义生成 go-lrpcx 的服务注册代码和客户端代理代码
+//
+// 使用方式：
+//
+//	go install github.com/junaozun/go-lrpxc/cmd/protoc-gen-lrpcx
+//	protoc --go_out=. --go_opt=paths=source_relative --lrpcx_out=. --lrpcx_opt=paths=source_relative hello.proto
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/protobuf/compiler/protogen"
+)
+
+const version = "v0.1.0"
+
+func main() {
+	if len(os.Args) == 2 && os.Args[1] == "--version" {
+		fmt.Fprintf(os.Stdout, "%v %v\n", filepath.Base(os.Args[0]), version)
+		os.Exit(0)
+	}
+
+	var flags flag.FlagSet
+
+	protogen.Options{
+		ParamFunc: flags.Set,
+	}.Run(func(gen *protogen.Plugin) error {
+		for _, f := range gen.Files {
+			if !f.Generate {
+				continue
+			}
+			if _, err := generateFile(gen, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -3,6 +3,7 @@ package codec
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"sync"
 
 	"github.com/golang/protobuf/proto"
@@ -21,19 +22,55 @@ type Codec interface {
 	Decode([]byte) ([]byte, error)
 }
 
+// FrameCodec 是可以指定帧头的 Codec，多路复用、流式请求、心跳和压缩都需要在帧头中写入对应的字段，
+// 框架内部通过 GetFrameCodec 获取。只实现了 Codec 的编解码器会被适配成 FrameCodec，见 GetFrameCodec
+type FrameCodec interface {
+	Codec
+	// EncodeFrame 按 header 中的 MsgType、ReqType、CompressType、StreamID、Reserved 编码帧头，header 为 nil 时使用默认值
+	EncodeFrame(*FrameHeader, []byte) ([]byte, error)
+	// DecodeFrame 返回帧头和解压后的包头+包体
+	DecodeFrame([]byte) (*FrameHeader, []byte, error)
+}
+
 const FrameHeadLen = 15
 const Magic = 0x11
 const Version = 0
 
+const (
+	MsgTypeNormal    = 0x0 // 普通消息
+	MsgTypeHeartbeat = 0x1 // 心跳消息
+)
+
+const (
+	ReqTypeSendAndRecv  = 0x0 // 一发一收
+	ReqTypeSendOnly     = 0x1 // 只发不收
+	ReqTypeClientStream = 0x2 // 客户端流式请求
+	ReqTypeServerStream = 0x3 // 服务端流式请求
+	ReqTypeBidiStream   = 0x4 // 双向流式请求
+)
+
+// 流式请求的帧头中，保留位用来标识帧在流上的作用
+const (
+	StreamFrameData   = 0x0 // 数据帧，包体是一条流消息
+	StreamFrameInit   = 0x1 // client 建立流的第一帧，包头中带有服务路径和元数据
+	StreamFrameEnd    = 0x2 // 发送方结束发送，client 的结束帧没有包体，server 的结束帧带有流的返回码
+	StreamFrameCancel = 0x3 // client 取消流，server 收到后取消流的 ctx
+)
+
+// IsStreamReqType 判断 reqType 是不是流式请求
+func IsStreamReqType(reqType uint8) bool {
+	return reqType == ReqTypeClientStream || reqType == ReqTypeServerStream || reqType == ReqTypeBidiStream
+}
+
 type FrameHeader struct {
 	Magic        uint8  //
 	Version      uint8  //
 	MsgType      uint8  // 要是用来区分普通消息和心跳消息。我们用 0x0 来表示普通消息，用 0x1 来表示心跳消息，客户端向服务端发送心跳包表示自己还是存活的
 	ReqType      uint8  // 用 0x0 来表示一发一收，0x1 来表示只发不收，0x2 表示客户端流式请求，0x3 表示服务端流式请求，0x4 表示双向流式请求。
-	CompressType uint8  // //client 和 server 会根据这个标志位决定对传输的数据是否进行压缩/解压处理。0x0 默认不压缩，0x1 压缩
+	CompressType uint8  // //client 和 server 会根据这个标志位决定对传输的数据是否进行压缩/解压处理。0x0 默认不压缩，其他值表示使用的压缩算法，见 compressor.go
 	StreamID     uint16 // stream ID //为了支持后续流式传输的能力
 	Length       uint32 // total packet length ,只是包头和包体，不包括帧头
-	Reserved     uint32 // 4 bytes reserved //保留位，方便后续协议进行扩展
+	Reserved     uint32 // 4 bytes reserved //保留位，方便后续协议进行扩展。流式请求中用来标识帧的作用，见 StreamFrameXxx
 }
 
 func GetCodec(name string) Codec {
@@ -43,6 +80,20 @@ func GetCodec(name string) Codec {
 	return DefaultCodec
 }
 
+// GetFrameCodec 返回 name 对应的 FrameCodec。注册的编解码器只实现了 Codec 时，返回一个适配器：
+// 编码时先按 CompressType 压缩，调用 Codec.Encode 后再把帧头中的其他字段写进编码好的帧；解码时解析帧头，
+// 调用 Codec.Decode 后再解压。适配的 Codec 需要使用框架的 15 字节帧头格式，否则 transport 无法分帧
+func GetFrameCodec(name string) FrameCodec {
+	return toFrameCodec(GetCodec(name))
+}
+
+func toFrameCodec(codec Codec) FrameCodec {
+	if fc, ok := codec.(FrameCodec); ok {
+		return fc
+	}
+	return &frameCodecAdapter{Codec: codec}
+}
+
 var codecMap = make(map[string]Codec)
 
 var DefaultCodec = NewCodec()
@@ -66,20 +117,42 @@ func RegisterCodec(name string, codec Codec) {
 
 type defaultCodec struct{}
 
+// Encode 将包头+包体拼上默认的帧头
 func (c *defaultCodec) Encode(data []byte) ([]byte, error) {
+	return c.EncodeFrame(nil, data)
+}
 
-	totalLen := FrameHeadLen + len(data)
-	buffer := bytes.NewBuffer(make([]byte, 0, totalLen))
+// Decode 返回解压后的包头+包体
+func (c *defaultCodec) Decode(frame []byte) ([]byte, error) {
+	_, data, err := c.DecodeFrame(frame)
+	return data, err
+}
+
+// EncodeFrame 将包头+包体拼上帧头，header 中的 MsgType、ReqType、CompressType、StreamID 由调用方指定，header 为 nil 时使用默认值。
+// CompressType 不为 0 时，包头+包体使用对应的压缩算法压缩
+func (c *defaultCodec) EncodeFrame(header *FrameHeader, data []byte) ([]byte, error) {
 
 	frame := FrameHeader{
-		Magic:        Magic,
-		Version:      Version,
-		MsgType:      0x0,
-		ReqType:      0x0,
-		CompressType: 0x0,
-		Length:       uint32(len(data)),
+		Magic:   Magic,
+		Version: Version,
+	}
+	if header != nil {
+		frame.MsgType = header.MsgType
+		frame.ReqType = header.ReqType
+		frame.CompressType = header.CompressType
+		frame.StreamID = header.StreamID
+		frame.Reserved = header.Reserved
 	}
 
+	data, err := compress(frame.CompressType, data)
+	if err != nil {
+		return nil, err
+	}
+	frame.Length = uint32(len(data))
+
+	totalLen := FrameHeadLen + len(data)
+	buffer := bytes.NewBuffer(make([]byte, 0, totalLen))
+
 	if err := binary.Write(buffer, binary.BigEndian, frame.Magic); err != nil {
 		return nil, err
 	}
@@ -119,8 +192,101 @@ func (c *defaultCodec) Encode(data []byte) ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
-func (c *defaultCodec) Decode(frame []byte) ([]byte, error) {
-	return frame[FrameHeadLen:], nil
+// DecodeFrame 解析出帧头，根据帧头中的 CompressType 解压，返回帧头和包头+包体
+func (c *defaultCodec) DecodeFrame(frame []byte) (*FrameHeader, []byte, error) {
+	header, err := ParseFrameHeader(frame)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := decompress(header.CompressType, frame[FrameHeadLen:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return header, data, nil
+}
+
+// frameCodecAdapter 将只实现了 Codec 的编解码器适配成 FrameCodec
+type frameCodecAdapter struct {
+	Codec
+}
+
+func (c *frameCodecAdapter) EncodeFrame(header *FrameHeader, data []byte) ([]byte, error) {
+	if header == nil {
+		return c.Encode(data)
+	}
+
+	data, err := compress(header.CompressType, data)
+	if err != nil {
+		return nil, err
+	}
+
+	frame, err := c.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) < FrameHeadLen {
+		return nil, errors.New("frame too short")
+	}
+
+	frame[2] = header.MsgType
+	frame[3] = header.ReqType
+	frame[4] = header.CompressType
+	SetStreamID(frame, header.StreamID)
+	binary.BigEndian.PutUint32(frame[11:15], header.Reserved)
+
+	return frame, nil
+}
+
+func (c *frameCodecAdapter) DecodeFrame(frame []byte) (*FrameHeader, []byte, error) {
+	header, err := ParseFrameHeader(frame)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := c.Decode(frame)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err = decompress(header.CompressType, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return header, data, nil
+}
+
+// ParseFrameHeader 从一个完整的帧中解析出帧头
+func ParseFrameHeader(frame []byte) (*FrameHeader, error) {
+	if len(frame) < FrameHeadLen {
+		return nil, errors.New("frame too short")
+	}
+	return &FrameHeader{
+		Magic:        frame[0],
+		Version:      frame[1],
+		MsgType:      frame[2],
+		ReqType:      frame[3],
+		CompressType: frame[4],
+		StreamID:     binary.BigEndian.Uint16(frame[5:7]),
+		Length:       binary.BigEndian.Uint32(frame[7:11]),
+		Reserved:     binary.BigEndian.Uint32(frame[11:15]),
+	}, nil
+}
+
+// NewHeartbeatFrame 创建一个心跳帧，心跳帧只有帧头，没有包头和包体
+func NewHeartbeatFrame(streamID uint16) []byte {
+	frame, _ := toFrameCodec(DefaultCodec).EncodeFrame(&FrameHeader{
+		MsgType:  MsgTypeHeartbeat,
+		StreamID: streamID,
+	}, nil)
+	return frame
+}
+
+// SetStreamID 修改一个已经编码好的帧的流 ID，多路复用的 client transport 在发送前为每个请求分配流 ID
+func SetStreamID(frame []byte, streamID uint16) {
+	binary.BigEndian.PutUint16(frame[5:7], streamID)
 }
 
 var bufferPool = &sync.Pool{
@@ -0,0 +1,135 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("lrpc payload "), 100)
+
+	for _, compressType := range []uint8{CompressTypeNone, CompressTypeGzip, CompressTypeZlib, CompressTypeSnappy, CompressTypeZstd} {
+		header := &FrameHeader{
+			MsgType:      MsgTypeNormal,
+			ReqType:      ReqTypeBidiStream,
+			CompressType: compressType,
+			StreamID:     42,
+			Reserved:     StreamFrameData,
+		}
+		frame, err := GetFrameCodec("proto").EncodeFrame(header, payload)
+		if err != nil {
+			t.Fatalf("compress type %d: Encode: %v", compressType, err)
+		}
+
+		got, data, err := GetFrameCodec("proto").DecodeFrame(frame)
+		if err != nil {
+			t.Fatalf("compress type %d: Decode: %v", compressType, err)
+		}
+		if !bytes.Equal(data, payload) {
+			t.Fatalf("compress type %d: payload mismatch", compressType)
+		}
+		if got.Magic != Magic || got.ReqType != header.ReqType || got.CompressType != compressType ||
+			got.StreamID != header.StreamID || got.Reserved != header.Reserved {
+			t.Fatalf("compress type %d: header mismatch, got %+v", compressType, got)
+		}
+		if int(got.Length) != len(frame)-FrameHeadLen {
+			t.Fatalf("compress type %d: Length %d, frame body %d", compressType, got.Length, len(frame)-FrameHeadLen)
+		}
+		if compressType != CompressTypeNone && len(frame)-FrameHeadLen >= len(payload) {
+			t.Fatalf("compress type %d: frame body %d not smaller than payload %d", compressType, len(frame)-FrameHeadLen, len(payload))
+		}
+	}
+}
+
+// legacyCodec 只实现了 Codec，编码的帧头除了长度都是默认值
+type legacyCodec struct{}
+
+func (legacyCodec) Encode(data []byte) ([]byte, error) {
+	frame := make([]byte, FrameHeadLen, FrameHeadLen+len(data))
+	frame[0] = Magic
+	frame[1] = Version
+	binary.BigEndian.PutUint32(frame[7:11], uint32(len(data)))
+	return append(frame, data...), nil
+}
+
+func (legacyCodec) Decode(frame []byte) ([]byte, error) {
+	return frame[FrameHeadLen:], nil
+}
+
+func TestFrameCodecAdapter(t *testing.T) {
+	RegisterCodec("legacy", legacyCodec{})
+	payload := bytes.Repeat([]byte("lrpc payload "), 100)
+
+	header := &FrameHeader{
+		MsgType:      MsgTypeNormal,
+		ReqType:      ReqTypeClientStream,
+		CompressType: CompressTypeGzip,
+		StreamID:     42,
+		Reserved:     StreamFrameEnd,
+	}
+	frame, err := GetFrameCodec("legacy").EncodeFrame(header, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the frame can be decoded by the default codec, and the other way around
+	got, data, err := GetFrameCodec("proto").DecodeFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, payload) {
+		t.Fatal("payload mismatch")
+	}
+	if got.ReqType != header.ReqType || got.CompressType != header.CompressType ||
+		got.StreamID != header.StreamID || got.Reserved != header.Reserved || int(got.Length) != len(frame)-FrameHeadLen {
+		t.Fatalf("header mismatch, got %+v", got)
+	}
+
+	frame, err = GetFrameCodec("proto").EncodeFrame(header, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, data, err = GetFrameCodec("legacy").DecodeFrame(frame); err != nil || !bytes.Equal(data, payload) || got.StreamID != header.StreamID {
+		t.Fatalf("DecodeFrame got %+v, %v", got, err)
+	}
+}
+
+func TestCodecEncodeDecode(t *testing.T) {
+	payload := []byte("lrpc payload")
+
+	frame, err := DefaultCodec.Encode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := DefaultCodec.Decode(frame)
+	if err != nil || !bytes.Equal(data, payload) {
+		t.Fatalf("Decode got %q, %v", data, err)
+	}
+}
+
+func TestHeartbeatFrame(t *testing.T) {
+	frame := NewHeartbeatFrame(7)
+	if len(frame) != FrameHeadLen {
+		t.Fatalf("heartbeat frame length %d, want %d", len(frame), FrameHeadLen)
+	}
+
+	header, err := ParseFrameHeader(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.MsgType != MsgTypeHeartbeat || header.StreamID != 7 || header.Length != 0 {
+		t.Fatalf("unexpected heartbeat header %+v", header)
+	}
+
+	SetStreamID(frame, 9)
+	if header, _ = ParseFrameHeader(frame); header.StreamID != 9 {
+		t.Fatalf("SetStreamID: got stream id %d, want 9", header.StreamID)
+	}
+}
+
+func TestParseFrameHeaderTooShort(t *testing.T) {
+	if _, err := ParseFrameHeader(make([]byte, FrameHeadLen-1)); err == nil {
+		t.Fatal("expected an error for a short frame")
+	}
+}
@@ -0,0 +1,233 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+压缩：帧头中的 CompressType 标识了包头+包体使用的压缩算法，0x0 表示不压缩。发送方根据配置的压缩算法和
+最小压缩长度决定是否压缩，接收方只根据帧头中的 CompressType 进行解压，所以压缩配置不同的 client 和 server 之间也可以互通。
+*/
+
+// Compressor 对包头+包体进行压缩和解压
+type Compressor interface {
+	Compress([]byte) ([]byte, error)
+	Decompress([]byte) ([]byte, error)
+}
+
+const (
+	CompressTypeNone   = 0x0
+	CompressTypeGzip   = 0x1
+	CompressTypeZlib   = 0x2
+	CompressTypeSnappy = 0x3
+	CompressTypeZstd   = 0x4
+)
+
+const (
+	Gzip   = "gzip"
+	Zlib   = "zlib"
+	Snappy = "snappy"
+	Zstd   = "zstd"
+)
+
+// MaxDecompressedLength 解压后的最大长度，避免恶意构造的压缩包耗尽内存
+const MaxDecompressedLength = 64 * 1024 * 1024
+
+var compressorMap = make(map[uint8]Compressor)
+
+var compressTypeMap = make(map[string]uint8)
+
+func init() {
+	RegisterCompressor(CompressTypeGzip, Gzip, &gzipCompressor{})
+	RegisterCompressor(CompressTypeZlib, Zlib, &zlibCompressor{})
+	RegisterCompressor(CompressTypeSnappy, Snappy, &snappyCompressor{})
+	RegisterCompressor(CompressTypeZstd, Zstd, &zstdCompressor{})
+}
+
+// RegisterCompressor registers a compressor, compressType is the value of FrameHeader.CompressType,
+// name is used by client and server options to choose the compressor
+func RegisterCompressor(compressType uint8, name string, compressor Compressor) {
+	if compressorMap == nil {
+		compressorMap = make(map[uint8]Compressor)
+	}
+	if compressTypeMap == nil {
+		compressTypeMap = make(map[string]uint8)
+	}
+	compressorMap[compressType] = compressor
+	compressTypeMap[name] = compressType
+}
+
+// GetCompressor get a Compressor by the compress type in frame header
+func GetCompressor(compressType uint8) Compressor {
+	return compressorMap[compressType]
+}
+
+// GetCompressType 根据压缩算法名和待压缩数据的长度决定帧头中的 CompressType，
+// name 为空或者数据长度小于 minSize 时不压缩，压缩算法未注册时返回错误
+func GetCompressType(name string, size int, minSize int) (uint8, error) {
+	if err := CheckCompressor(name); err != nil {
+		return CompressTypeNone, err
+	}
+	if name == "" || size < minSize {
+		return CompressTypeNone, nil
+	}
+	return compressTypeMap[name], nil
+}
+
+// CheckCompressor 检查压缩算法是否注册，name 为空表示不压缩。WithCompressor 写错压缩算法名时
+// server 启动失败，client 调用返回错误，避免压缩被悄悄关掉
+func CheckCompressor(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := compressTypeMap[name]; !ok {
+		return fmt.Errorf("compressor %s not registered", name)
+	}
+	return nil
+}
+
+func compress(compressType uint8, data []byte) ([]byte, error) {
+	if compressType == CompressTypeNone {
+		return data, nil
+	}
+	compressor := GetCompressor(compressType)
+	if compressor == nil {
+		return nil, fmt.Errorf("compress type %d not registered", compressType)
+	}
+	return compressor.Compress(data)
+}
+
+func decompress(compressType uint8, data []byte) ([]byte, error) {
+	if compressType == CompressTypeNone {
+		return data, nil
+	}
+	compressor := GetCompressor(compressType)
+	if compressor == nil {
+		return nil, fmt.Errorf("compress type %d not registered", compressType)
+	}
+	return compressor.Decompress(data)
+}
+
+// readAll reads r until EOF, returns an error if the data exceeds MaxDecompressedLength
+func readAll(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxDecompressedLength+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxDecompressedLength {
+		return nil, errors.New("decompressed data too large")
+	}
+	return data, nil
+}
+
+type gzipCompressor struct{}
+
+func (c *gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readAll(r)
+}
+
+type zlibCompressor struct{}
+
+func (c *zlibCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *zlibCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readAll(r)
+}
+
+// snappy 压缩率不如 gzip，但是压缩和解压速度快很多，适合对延迟敏感的场景
+type snappyCompressor struct{}
+
+func (c *snappyCompressor) Compress(data []byte) ([]byte, error) {
+	return snappy.Encode(nil, data), nil
+}
+
+func (c *snappyCompressor) Decompress(data []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, err
+	}
+	if n > MaxDecompressedLength {
+		return nil, errors.New("decompressed data too large")
+	}
+	return snappy.Decode(nil, data)
+}
+
+// zstd 压缩率接近 gzip，压缩和解压速度接近 snappy。encoder 和 decoder 创建开销较大，第一次使用时创建，之后所有请求共用
+type zstdCompressor struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}
+
+func (c *zstdCompressor) init() error {
+	c.once.Do(func() {
+		if c.encoder, c.err = zstd.NewWriter(nil); c.err != nil {
+			return
+		}
+		c.decoder, c.err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxDecompressedLength))
+	})
+	return c.err
+}
+
+func (c *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	data, err := c.decoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxDecompressedLength {
+		return nil, errors.New("decompressed data too large")
+	}
+	return data, nil
+}
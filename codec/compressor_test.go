@@ -0,0 +1,81 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressorsRoundTrip(t *testing.T) {
+	inputs := [][]byte{
+		{},
+		[]byte("a"),
+		bytes.Repeat([]byte("0123456789"), 10000),
+	}
+
+	for _, name := range []string{Gzip, Zlib, Snappy, Zstd} {
+		compressType, err := GetCompressType(name, 1, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		compressor := GetCompressor(compressType)
+		if compressor == nil {
+			t.Fatalf("%s: compressor not registered", name)
+		}
+
+		for _, in := range inputs {
+			compressed, err := compressor.Compress(in)
+			if err != nil {
+				t.Fatalf("%s: Compress: %v", name, err)
+			}
+			out, err := compressor.Decompress(compressed)
+			if err != nil {
+				t.Fatalf("%s: Decompress: %v", name, err)
+			}
+			if !bytes.Equal(out, in) {
+				t.Fatalf("%s: round trip mismatch for %d bytes", name, len(in))
+			}
+		}
+	}
+}
+
+func TestGetCompressType(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		minSize int
+		want    uint8
+		wantErr bool
+	}{
+		{name: "", size: 100, want: CompressTypeNone},
+		{name: Gzip, size: 100, want: CompressTypeGzip},
+		{name: Zstd, size: 100, want: CompressTypeZstd},
+		{name: Snappy, size: 10, minSize: 100, want: CompressTypeNone},
+		{name: "gzpi", size: 100, wantErr: true},
+		{name: "gzpi", size: 10, minSize: 100, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := GetCompressType(tt.name, tt.size, tt.minSize)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("GetCompressType(%q, %d, %d) error = %v, wantErr %v", tt.name, tt.size, tt.minSize, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Fatalf("GetCompressType(%q, %d, %d) = %d, want %d", tt.name, tt.size, tt.minSize, got, tt.want)
+		}
+	}
+}
+
+func TestDecompressUnregisteredType(t *testing.T) {
+	if _, err := decompress(0x7f, []byte("x")); err == nil {
+		t.Fatal("expected an error for an unregistered compress type")
+	}
+}
+
+func TestDecompressRejectsCorruptData(t *testing.T) {
+	for _, name := range []string{Gzip, Zlib, Snappy, Zstd} {
+		compressType, _ := GetCompressType(name, 1, 0)
+		if _, err := decompress(compressType, []byte("definitely not compressed")); err == nil {
+			t.Fatalf("%s: expected an error for corrupt data", name)
+		}
+	}
+}
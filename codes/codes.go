@@ -1,12 +1,20 @@
 package codes
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
 
 const (
 	OK                           = 0
 	ServerInternalErrorCode      = 100
 	ConfigErrorCode              = 101
 	NetworkNotSupportedErrorCode = 201
+	NetworkErrorCode             = 202 // dial failure, connection closed or reset
+	CircuitBreakerOpenErrorCode  = 203 // the node is open in the circuit breaker
 	ClientMsgErrorCode           = 301
 	ClientCertFail               = 401
 )
@@ -22,6 +30,8 @@ var (
 	ServerInternalError      = NewFrameworkError(ServerInternalErrorCode, "server internal codes")
 	ConfigError              = NewFrameworkError(ConfigErrorCode, "config codes")
 	NetworkNotSupportedError = NewFrameworkError(NetworkNotSupportedErrorCode, "network type not supported")
+	NetworkError             = NewFrameworkError(NetworkErrorCode, "network codes")
+	CircuitBreakerOpenError  = NewFrameworkError(CircuitBreakerOpenErrorCode, "circuit breaker is open")
 	ClientCertFailError      = NewFrameworkError(ClientCertFail, "client cert fail")
 )
 
@@ -63,3 +73,86 @@ func New(code uint32, msg string) *Error {
 		Message: msg,
 	}
 }
+
+// IsRetryable 判断一次调用的错误能否通过重试（重新选择节点）解决。
+// 框架的网络错误、节点被熔断、建连失败、连接被关闭或重置可以重试；业务错误、编解码错误、ctx 取消或超时不能重试
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Type == FrameworkError && (e.Code == NetworkErrorCode || e.Code == CircuitBreakerOpenErrorCode)
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// 读回包时对端关闭了连接
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+
+	// 读写超时说明请求可能已经在处理，不重试
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return !ne.Timeout()
+	}
+
+	return false
+}
+
+// IsNodeError 判断错误是否说明节点异常：网络错误和读写超时说明节点可能有问题，业务错误说明节点可以正常处理请求，
+// 调用方的 ctx 被取消或者超时不能说明节点的状态。熔断和异常节点检测用它统计节点的失败
+func IsNodeError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if IsRetryable(err) {
+		return true
+	}
+	// context.DeadlineExceeded 也实现了 net.Error，要先排除
+	if errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// notSentError 包装请求完整发出之前发生的错误
+type notSentError struct {
+	err error
+}
+
+func (e *notSentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *notSentError) Unwrap() error {
+	return e.err
+}
+
+// NotSent 标记 err 发生在请求完整写到连接上之前，比如选择节点、熔断、建连或者写请求帧失败，server 不会执行这个请求。
+// err 为 nil 时返回 nil
+func NotSent(err error) error {
+	if err == nil || IsNotSent(err) {
+		return err
+	}
+	return &notSentError{err: err}
+}
+
+// IsNotSent 判断 err 是否发生在请求完整发出之前，这样的请求重试不会让 server 重复执行
+func IsNotSent(err error) bool {
+	var e *notSentError
+	return errors.As(err, &e)
+}
+
+// StripNotSent 去掉 NotSent 的标记，返回原来的错误
+func StripNotSent(err error) error {
+	if e, ok := err.(*notSentError); ok {
+		return e.err
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package codes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+// timeoutError 是一个读写超时的 net.Error
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsRetryable(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"business error", New(1000, "biz"), false},
+		{"config error", ConfigError, false},
+		{"network error", NetworkError, true},
+		{"wrapped network error", fmt.Errorf("send: %w", NetworkError), true},
+		{"circuit breaker open", CircuitBreakerOpenError, true},
+		{"business error with network code", New(NetworkErrorCode, "biz"), false},
+		{"context canceled", context.Canceled, false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"eof", io.EOF, true},
+		{"unexpected eof", fmt.Errorf("read frame: %w", io.ErrUnexpectedEOF), true},
+		{"closed conn", net.ErrClosed, true},
+		{"dial refused", refused, true},
+		{"read timeout", &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, false},
+		{"plain error", errors.New("decode failed"), false},
+	}
+
+	for _, c := range cases {
+		if got := IsRetryable(c.err); got != c.want {
+			t.Errorf("%s: IsRetryable(%v) = %v, want %v", c.name, c.err, got, c.want)
+		}
+	}
+}
+
+func TestIsNodeError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"business error", New(1000, "biz"), false},
+		{"network error", NetworkError, true},
+		{"circuit breaker open", CircuitBreakerOpenError, true},
+		{"context canceled", context.Canceled, false},
+		{"deadline exceeded", context.DeadlineExceeded, false},
+		{"read timeout", &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, true},
+		{"eof", io.EOF, true},
+		{"plain error", errors.New("decode failed"), false},
+	}
+
+	for _, c := range cases {
+		if got := IsNodeError(c.err); got != c.want {
+			t.Errorf("%s: IsNodeError(%v) = %v, want %v", c.name, c.err, got, c.want)
+		}
+	}
+}
+
+func TestNotSent(t *testing.T) {
+	if NotSent(nil) != nil {
+		t.Fatal("NotSent(nil) is not nil")
+	}
+
+	err := NotSent(NetworkError)
+	if !IsNotSent(err) || !IsNotSent(fmt.Errorf("send: %w", err)) {
+		t.Fatalf("IsNotSent(%v) = false", err)
+	}
+	if IsNotSent(NetworkError) {
+		t.Fatal("IsNotSent of an unmarked error = true")
+	}
+
+	// the mark does not change how the error is classified
+	if !IsRetryable(err) || !IsNodeError(err) || !errors.Is(err, NetworkError) {
+		t.Fatalf("%v is not classified as %v", err, NetworkError)
+	}
+	if NotSent(err) != err {
+		t.Fatal("NotSent marks an error twice")
+	}
+	if StripNotSent(err) != NetworkError || StripNotSent(NetworkError) != NetworkError {
+		t.Fatal("StripNotSent does not return the original error")
+	}
+}
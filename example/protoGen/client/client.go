@@ -5,9 +5,8 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/lubanproj/gorpc/client"
-
-	pb "github.com/lubanproj/gorpc/examples/helloworld2/helloworld"
+	"github.com/junaozun/go-lrpxc/client"
+	pb "github.com/junaozun/go-lrpxc/example/protoGen/proto"
 )
 
 func main() {
@@ -20,6 +19,6 @@ func main() {
 	req := &pb.HelloRequest{
 		Msg: "hello",
 	}
-	rsp, err := proxy.SayHello(context.Background(), req, opts...)
+	rsp, err := proxy.SayHello(context.Background(), req)
 	fmt.Println(rsp, err)
 }
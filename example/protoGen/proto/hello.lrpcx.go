@@ -0,0 +1,79 @@
+// Code generated by protoc-gen-lrpcx. DO NOT EDIT.
+// versions:
+// - protoc-gen-lrpcx v0.1.0
+// source: hello.proto
+
+package helloworld
+
+import (
+	context "context"
+	go_lrpxc "github.com/junaozun/go-lrpxc"
+	client "github.com/junaozun/go-lrpxc/client"
+	interceptor "github.com/junaozun/go-lrpxc/interceptor"
+)
+
+// GreeterService is the server API for Greeter service.
+type GreeterService interface {
+	SayHello(ctx context.Context, req *HelloRequest) (*HelloReply, error)
+}
+
+func GreeterService_SayHello_Handler(ctx context.Context, svr interface{}, dec func(interface{}) error, ceps []interceptor.ServerInterceptor) (interface{}, error) {
+	req := new(HelloRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+
+	if len(ceps) == 0 {
+		return svr.(GreeterService).SayHello(ctx, req)
+	}
+
+	handler := func(ctx context.Context, reqbody interface{}) (interface{}, error) {
+		return svr.(GreeterService).SayHello(ctx, reqbody.(*HelloRequest))
+	}
+
+	return interceptor.ServerIntercept(ctx, req, ceps, handler)
+}
+
+var _Greeter_serviceDesc = &go_lrpxc.ServiceDesc{
+	ServiceName: "helloworld.Greeter",
+	HandlerType: (*GreeterService)(nil),
+	Methods: []*go_lrpxc.MethodDesc{
+		{
+			MethodName: "SayHello",
+			Handler:    GreeterService_SayHello_Handler,
+		},
+	},
+	Streams: []*go_lrpxc.StreamDesc{},
+}
+
+// RegisterGreeterService registers svr to the server s as helloworld.Greeter
+func RegisterGreeterService(s *go_lrpxc.Server, svr GreeterService) {
+	s.Register(_Greeter_serviceDesc, svr)
+}
+
+// GreeterClientProxy is the client API for Greeter service.
+type GreeterClientProxy interface {
+	SayHello(ctx context.Context, req *HelloRequest, opts ...client.ClientOption) (*HelloReply, error)
+}
+
+type GreeterClientProxyImpl struct {
+	client client.Client
+	opts   []client.ClientOption
+}
+
+// NewGreeterClientProxy creates a client proxy of Greeter service, opts take effect on every call
+func NewGreeterClientProxy(opts ...client.ClientOption) GreeterClientProxy {
+	return &GreeterClientProxyImpl{client: client.DefaultClient, opts: opts}
+}
+
+func (c *GreeterClientProxyImpl) SayHello(ctx context.Context, req *HelloRequest, opts ...client.ClientOption) (*HelloReply, error) {
+	callopts := make([]client.ClientOption, 0, len(c.opts)+len(opts))
+	callopts = append(callopts, c.opts...)
+	callopts = append(callopts, opts...)
+	rsp := &HelloReply{}
+	err := c.client.Invoke(ctx, req, rsp, "/helloworld.Greeter/SayHello", callopts...)
+	if err != nil {
+		return nil, err
+	}
+	return rsp, nil
+}
@@ -3,10 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
-	"github.com/junaozun/go-lrpxc"
 	"time"
 
-	"github.com/lubanproj/gorpc/examples/helloworld2/helloworld"
+	"github.com/junaozun/go-lrpxc"
+	helloworld "github.com/junaozun/go-lrpxc/example/protoGen/proto"
 )
 
 type greeterService struct{}
@@ -20,14 +20,13 @@ func (g *greeterService) SayHello(ctx context.Context, req *helloworld.HelloRequ
 }
 
 func main() {
-	opts := []github.com / junaozun /
-	go -lrpxc.ServerOption{
-		github.com / junaozun /go -lrpxc.WithAddress("127.0.0.1:8000"),
-		github.com/junaozun/go -lrpxc.WithNetwork("tcp"),
-		github.com/junaozun/go -lrpxc.WithProtocol("proto"),
-		github.com/junaozun/go -lrpxc.WithTimeout(time.Millisecond * 2000),
+	opts := []github.ServerOption{
+		github.WithAddress("127.0.0.1:8000"),
+		github.WithNetwork("tcp"),
+		github.WithProtocol("proto"),
+		github.WithTimeout(time.Millisecond * 2000),
 	}
-	s := github.com/junaozun/go-lrpxc.NewServer(opts...)
-	helloworld.RegisterService(s, &greeterService{})
+	s := github.NewServer(opts...)
+	helloworld.RegisterGreeterService(s, &greeterService{})
 	s.Serve()
 }
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/interceptor"
+	"github.com/junaozun/go-lrpxc/stream"
+)
+
+// Options 是监控拦截器的配置
+type Options struct {
+	sink Sink // sink of the metrics, default: the sink registered as "default"
+}
+
+// Option provides operations on Options
+type Option func(*Options)
+
+// WithSink 设置指标写入的 Sink，没有设置时每次调用都写进注册为 "default" 的 Sink
+func WithSink(sink Sink) Option {
+	return func(o *Options) {
+		o.sink = sink
+	}
+}
+
+func (o *Options) getSink() Sink {
+	if o.sink != nil {
+		return o.sink
+	}
+	return GetSink("default")
+}
+
+// ClientInterceptor 记录 client 调用的请求数、错误数、耗时和正在处理中的请求数，
+// e.g. client.WithInterceptor(metrics.ClientInterceptor())
+func ClientInterceptor(opts ...Option) interceptor.ClientInterceptor {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(ctx context.Context, req, rsp interface{}, ivk interceptor.Invoker) error {
+
+		var serviceName, method string
+		if cs, ok := ctx.Value(stream.ClientStreamKey).(*stream.ClientStream); ok {
+			serviceName, method = cs.ServiceName, cs.Method
+		}
+
+		sink := o.getSink()
+		done := begin(sink, ClientRequestsInFlight, serviceName, method)
+
+		err := ivk(ctx, req, rsp)
+		done(ClientRequestsTotal, ClientErrorsTotal, ClientRequestDuration, err)
+		return err
+	}
+}
+
+// ServerInterceptor 记录 server 处理请求的请求数、错误数、耗时和正在处理中的请求数，
+// e.g. server.WithInterceptor(metrics.ServerInterceptor())
+func ServerInterceptor(opts ...Option) interceptor.ServerInterceptor {
+	o := &Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return func(ctx context.Context, req interface{}, handler interceptor.Handler) (interface{}, error) {
+
+		ss := stream.GetServerStream2(ctx)
+
+		sink := o.getSink()
+		done := begin(sink, ServerRequestsInFlight, ss.ServiceName, ss.Method)
+
+		rsp, err := handler(ctx, req)
+		done(ServerRequestsTotal, ServerErrorsTotal, ServerRequestDuration, err)
+		return rsp, err
+	}
+}
+
+// begin 增加正在处理中的请求数，返回的函数在请求结束时调用，记录请求数、错误数和耗时，并减少正在处理中的请求数
+func begin(sink Sink, inFlight, serviceName, method string) func(requests, errs, duration string, err error) {
+	labels := Labels{
+		"service": serviceName,
+		"method":  method,
+	}
+	start := time.Now()
+	sink.AddGauge(inFlight, labels, 1)
+
+	return func(requests, errs, duration string, err error) {
+		sink.AddGauge(inFlight, labels, -1)
+		sink.IncCounter(requests, labels, 1)
+		sink.ObserveHistogram(duration, labels, time.Since(start).Seconds())
+
+		if err != nil {
+			sink.IncCounter(errs, Labels{
+				"service": serviceName,
+				"method":  method,
+				"code":    errorCode(err),
+			}, 1)
+		}
+	}
+}
+
+// errorCode 返回错误的错误码，不是框架定义的错误时返回 "unknown"
+func errorCode(err error) string {
+	var e *codes.Error
+	if errors.As(err, &e) {
+		return strconv.FormatUint(uint64(e.Code), 10)
+	}
+	return "unknown"
+}
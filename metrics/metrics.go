@@ -0,0 +1,66 @@
+package metrics
+
+/*
+监控指标：client 和 server 的拦截器按服务名和方法名记录请求数、按错误码记录错误数、记录请求耗时的直方图和正在处理中的请求数，
+连接池和传输层记录空闲连接数、活跃连接数等 gauge。指标统一写进 Sink，Sink 决定指标如何聚合和导出，
+默认的 Sink 是 PrometheusSink，它同时是一个 http.Handler，按 Prometheus 的文本格式导出所有指标。
+*/
+
+// Labels 是指标的标签，比如 {"service": "helloworld.Greeter", "method": "SayHello"}
+type Labels map[string]string
+
+// Sink 接收框架产生的指标，实现需要保证并发安全
+type Sink interface {
+	// IncCounter 给计数器加上 delta
+	IncCounter(name string, labels Labels, delta float64)
+	// AddGauge 给 gauge 加上 delta，delta 可以是负数
+	AddGauge(name string, labels Labels, delta float64)
+	// SetGauge 把 gauge 设为 value
+	SetGauge(name string, labels Labels, value float64)
+	// ObserveHistogram 在直方图中记录一个观测值
+	ObserveHistogram(name string, labels Labels, value float64)
+}
+
+// 框架产生的指标
+const (
+	ClientRequestsTotal    = "lrpc_client_requests_total"           // counter, labels: service, method
+	ClientErrorsTotal      = "lrpc_client_errors_total"             // counter, labels: service, method, code
+	ClientRequestDuration  = "lrpc_client_request_duration_seconds" // histogram, labels: service, method
+	ClientRequestsInFlight = "lrpc_client_requests_in_flight"       // gauge, labels: service, method
+
+	ServerRequestsTotal    = "lrpc_server_requests_total"           // counter, labels: service, method
+	ServerErrorsTotal      = "lrpc_server_errors_total"             // counter, labels: service, method, code
+	ServerRequestDuration  = "lrpc_server_request_duration_seconds" // histogram, labels: service, method
+	ServerRequestsInFlight = "lrpc_server_requests_in_flight"       // gauge, labels: service, method
+
+	PoolIdleConnections = "lrpc_pool_idle_connections" // gauge, labels: address
+
+	ServerConnections    = "lrpc_server_connections"     // gauge, labels: network
+	ClientMuxConnections = "lrpc_client_mux_connections" // gauge, labels: network
+)
+
+var sinkMap = make(map[string]Sink)
+
+func init() {
+	RegisterSink("default", DefaultSink)
+}
+
+// RegisterSink registers a Sink, registering "default" replaces the sink used by the framework,
+// e.g. RegisterSink("default", mySink)
+func RegisterSink(name string, sink Sink) {
+	if sinkMap == nil {
+		sinkMap = make(map[string]Sink)
+	}
+	sinkMap[name] = sink
+}
+
+// GetSink get a Sink by name
+func GetSink(name string) Sink {
+	if v, ok := sinkMap[name]; ok {
+		return v
+	}
+	return DefaultSink
+}
+
+// The default sink, exposes the metrics in the Prometheus text format
+var DefaultSink = NewPrometheusSink()
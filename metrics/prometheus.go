@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets 是默认的直方图分桶，单位是秒
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// PrometheusSink 在内存中聚合指标，并且实现了 http.Handler，按 Prometheus 的文本格式导出所有指标，
+// 比如 http.Handle("/metrics", metrics.Handler())
+type PrometheusSink struct {
+	buckets []float64
+
+	mu       sync.Mutex
+	families map[string]*family // metric name -> metric family
+}
+
+// family 是同名的一组指标，每组标签对应一个 series
+type family struct {
+	typ    string
+	series map[string]*series // rendered labels -> series
+	warned bool               // a write of another type has been reported
+}
+
+type series struct {
+	labels string   // rendered labels, e.g. method="SayHello",service="helloworld.Greeter"
+	value  float64  // value of counter and gauge, sum of histogram
+	count  uint64   // count of histogram
+	counts []uint64 // count of each bucket of histogram, not cumulative
+}
+
+// NewPrometheusSink 创建一个 PrometheusSink，buckets 为空时直方图使用 DefBuckets
+func NewPrometheusSink(buckets ...float64) *PrometheusSink {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &PrometheusSink{
+		buckets:  buckets,
+		families: make(map[string]*family),
+	}
+}
+
+func (p *PrometheusSink) IncCounter(name string, labels Labels, delta float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if s := p.getSeries(name, typeCounter, labels); s != nil {
+		s.value += delta
+	}
+}
+
+func (p *PrometheusSink) AddGauge(name string, labels Labels, delta float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if s := p.getSeries(name, typeGauge, labels); s != nil {
+		s.value += delta
+	}
+}
+
+func (p *PrometheusSink) SetGauge(name string, labels Labels, value float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if s := p.getSeries(name, typeGauge, labels); s != nil {
+		s.value = value
+	}
+}
+
+func (p *PrometheusSink) ObserveHistogram(name string, labels Labels, value float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.getSeries(name, typeHistogram, labels)
+	if s == nil {
+		return
+	}
+	if s.counts == nil {
+		s.counts = make([]uint64, len(p.buckets))
+	}
+	s.value += value
+	s.count++
+	if i := sort.SearchFloat64s(p.buckets, value); i < len(p.buckets) {
+		s.counts[i]++
+	}
+}
+
+// getSeries 返回指标对应的 series，不存在时创建。同名指标的类型以第一次写入的类型为准，
+// 类型不同的写入被忽略并返回 nil，第一次忽略时打印一条日志。调用方需要持有 p.mu
+func (p *PrometheusSink) getSeries(name, typ string, labels Labels) *series {
+	f, ok := p.families[name]
+	if !ok {
+		f = &family{
+			typ:    typ,
+			series: make(map[string]*series),
+		}
+		p.families[name] = f
+	}
+	if f.typ != typ {
+		if !f.warned {
+			f.warned = true
+			fmt.Printf("metrics %s is a %s, ignore the %s writes\n", name, f.typ, typ)
+		}
+		return nil
+	}
+
+	key := renderLabels(labels)
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{
+			labels: key,
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// ServeHTTP 按 Prometheus 的文本格式导出所有指标，指标和 series 按名字排序
+func (p *PrometheusSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	bw := bufio.NewWriter(w)
+	p.mu.Lock()
+	p.write(bw)
+	p.mu.Unlock()
+	bw.Flush()
+}
+
+func (p *PrometheusSink) write(w *bufio.Writer) {
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := p.families[name]
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, f.typ)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s := f.series[key]
+			if f.typ != typeHistogram {
+				fmt.Fprintf(w, "%s%s %s\n", name, wrapLabels(s.labels), formatFloat(s.value))
+				continue
+			}
+
+			var cumulative uint64
+			for i, bound := range p.buckets {
+				cumulative += s.counts[i]
+				fmt.Fprintf(w, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(s.labels, `le="`+formatFloat(bound)+`"`)), cumulative)
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(s.labels, `le="+Inf"`)), s.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", name, wrapLabels(s.labels), formatFloat(s.value))
+			fmt.Fprintf(w, "%s_count%s %d\n", name, wrapLabels(s.labels), s.count)
+		}
+	}
+}
+
+// Handler 返回导出默认 Sink 的 http.Handler，默认 Sink 被替换成没有实现 http.Handler 的 Sink 时导出 DefaultSink
+func Handler() http.Handler {
+	if h, ok := GetSink("default").(http.Handler); ok {
+		return h
+	}
+	return DefaultSink
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// renderLabels 把标签按名字排序，渲染成 name="value" 的形式
+func renderLabels(labels Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+`="`+labelValueEscaper.Replace(labels[name])+`"`)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func joinLabels(labels, label string) string {
+	if labels == "" {
+		return label
+	}
+	return labels + "," + label
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, p *PrometheusSink) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("Content-Type = %q, want the Prometheus text format", ct)
+	}
+	return w.Body.String()
+}
+
+func TestPrometheusOutput(t *testing.T) {
+	p := NewPrometheusSink(0.5, 0.1)
+
+	p.IncCounter("rpc_requests_total", Labels{"service": "helloworld.Greeter", "method": "SayHello"}, 1)
+	p.IncCounter("rpc_requests_total", Labels{"service": "helloworld.Greeter", "method": "SayHello"}, 2)
+	p.IncCounter("rpc_requests_total", Labels{"service": "helloworld.Greeter", "method": "Bye"}, 1)
+	p.AddGauge("rpc_in_flight", nil, 3)
+	p.AddGauge("rpc_in_flight", nil, -1)
+	p.SetGauge("queue_size", Labels{"name": "a\"b\\c\nd"}, 1.5)
+	p.ObserveHistogram("rpc_duration_seconds", Labels{"method": "SayHello"}, 0.05)
+	p.ObserveHistogram("rpc_duration_seconds", Labels{"method": "SayHello"}, 0.3)
+	p.ObserveHistogram("rpc_duration_seconds", Labels{"method": "SayHello"}, 2)
+
+	// 指标、series 和标签都按名字排序，分桶是累计值
+	want := `# TYPE queue_size gauge
+queue_size{name="a\"b\\c\nd"} 1.5
+# TYPE rpc_duration_seconds histogram
+rpc_duration_seconds_bucket{method="SayHello",le="0.1"} 1
+rpc_duration_seconds_bucket{method="SayHello",le="0.5"} 2
+rpc_duration_seconds_bucket{method="SayHello",le="+Inf"} 3
+rpc_duration_seconds_sum{method="SayHello"} 2.35
+rpc_duration_seconds_count{method="SayHello"} 3
+# TYPE rpc_in_flight gauge
+rpc_in_flight 2
+# TYPE rpc_requests_total counter
+rpc_requests_total{method="Bye",service="helloworld.Greeter"} 1
+rpc_requests_total{method="SayHello",service="helloworld.Greeter"} 3
+`
+	if got := scrape(t, p); got != want {
+		t.Fatalf("output =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPrometheusIgnoresMismatchedType(t *testing.T) {
+	p := NewPrometheusSink(1)
+
+	p.ObserveHistogram("rpc_duration_seconds", nil, 0.5)
+	// 类型和第一次写入不同的写入被忽略，导出时不会 panic
+	p.IncCounter("rpc_duration_seconds", Labels{"method": "SayHello"}, 1)
+	p.SetGauge("rpc_duration_seconds", nil, 7)
+	p.IncCounter("rpc_requests_total", nil, 1)
+	p.ObserveHistogram("rpc_requests_total", nil, 0.5)
+	p.AddGauge("rpc_requests_total", nil, 1)
+
+	want := `# TYPE rpc_duration_seconds histogram
+rpc_duration_seconds_bucket{le="1"} 1
+rpc_duration_seconds_bucket{le="+Inf"} 1
+rpc_duration_seconds_sum 0.5
+rpc_duration_seconds_count 1
+# TYPE rpc_requests_total counter
+rpc_requests_total 1
+`
+	if got := scrape(t, p); got != want {
+		t.Fatalf("output =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -1,10 +1,10 @@
 package consul
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"net/http"
-	"strings"
+	"sync"
 
 	"github.com/junaozun/go-lrpxc/plugin"
 	"github.com/junaozun/go-lrpxc/selector"
@@ -15,14 +15,14 @@ import (
 
 /*
  1. 基于consul实现服务注册与发现
-Service 应该去向 consul server 发起注册，consul 会将 Service 的服务名和服务地址
-保存在一个 k-v 存储中。与 consul 的通信有 DNS、HTTP、gRPC 三种协议，我们采取通用的 HTTP 协议实现。
+Service 应该去向 consul agent 发起注册，consul 会将 Service 的服务名、服务地址和健康检查
+保存在服务目录中。与 consul 的通信有 DNS、HTTP、gRPC 三种协议，我们采取通用的 HTTP 协议实现。
 */
 
 /*
 2、服务发现怎么实现？
 因为我们采用的是客户端服务发现的模式，所以由 client 去请求 consul server，
-根据某个 Service 的服务名，去 consul 的 k-v 存储中获取到服务的地址。通信的方式也是 HTTP 协议。
+根据某个 Service 的服务名，去 consul 的服务目录中获取到健康的服务地址。通信的方式也是 HTTP 协议。
 */
 
 /*
@@ -32,12 +32,20 @@ consul 的初始化，启动 consul 服务，这样才能保证 consul 能够接
 */
 
 type Consul struct {
-	opts         *plugin.Options
+	opts         *plugin.Options // opts.BalancerName is the load balancing mode, including random, polling, weighted polling, consistent hash, etc
 	client       *api.Client
 	config       *api.Config
-	balancerName string // load balancing mode, including random, polling, weighted polling, consistent hash, etc
 	writeOptions *api.WriteOptions
 	queryOptions *api.QueryOptions
+	service      string // service name to discover, set by the target, overrides the service name of calls
+
+	mu       sync.RWMutex
+	services map[string][]*selector.Node // serviceName -> cached nodes, updated by the watchers
+	ctx      context.Context             // cancelled by Close to stop the watchers
+	cancel   context.CancelFunc
+
+	tag           string             // only instances with the tag are discovered, set by the target
+	stopHeartbeat context.CancelFunc // stops reporting the ttl checks
 }
 
 const Name = "consul"
@@ -46,6 +54,31 @@ const Name = "consul"
 func init() {
 	plugin.Register(Name, ConsulSvr)
 	selector.RegisterSelector(Name, ConsulSvr)
+	selector.RegisterBuilder(Name, build)
+}
+
+// build 根据 consul://127.0.0.1:8500/helloworld.Greeter?balancer=roundRobin&tag=canary 创建一个 consul Selector，
+// 没有 consul 地址时使用 Init 设置的地址，没有服务名时使用调用的服务名，设置了 tag 时只发现带有这个标签的实例
+func build(target *selector.Target) (selector.Selector, error) {
+	c := &Consul{
+		opts: &plugin.Options{
+			SelectorSvrAddr: target.Authority,
+			BalancerName:    target.Query.Get("balancer"),
+		},
+		service: target.Endpoint,
+		tag:     target.Query.Get("tag"),
+	}
+	if c.opts.SelectorSvrAddr == "" {
+		c.opts.SelectorSvrAddr = ConsulSvr.opts.SelectorSvrAddr
+	}
+	if c.opts.BalancerName == "" {
+		c.opts.BalancerName = ConsulSvr.opts.BalancerName
+	}
+
+	if err := c.InitConfig(); err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
 var ConsulSvr = &Consul{
@@ -73,7 +106,11 @@ func (c *Consul) InitConfig() error {
 
 // 由于 consul 是服务发现组件，所以它需要实现服务发现的统一接口 Selector，也就是 Select 函数
 // select 就分为两步了，第一步 Resolve 方法其实就是服务发现的过程，就是将所有的服务找出，然后Balance 是负载均衡实现，通过loadbanance算法找出一个节点
-func (c *Consul) Select(serviceName string) (string, error) {
+// opts 透传给 Balancer，比如一致性哈希需要的 HashKey
+func (c *Consul) Select(serviceName string, opts ...selector.Option) (string, error) {
+	if c.service != "" {
+		serviceName = c.service
+	}
 
 	nodes, err := c.Resolve(serviceName)
 
@@ -81,50 +118,37 @@ func (c *Consul) Select(serviceName string) (string, error) {
 		return "", err
 	}
 
-	balancer := loadbalance.GetBalancer(c.balancerName)
-	node := balancer.Balance(serviceName, nodes)
-
-	if node == nil {
-		return "", fmt.Errorf("no services find in %s", serviceName)
-	}
-
-	return parseAddrFromNode(node)
+	return loadbalance.Pick(c.opts.BalancerName, serviceName, nodes, opts...)
 }
 
-// Resolve 这个方法是通过一个服务名去获取服务列表
+// Resolve 这个方法是通过一个服务名去获取服务列表，优先读本地缓存，第一次获取时从 consul 拉取并开始 watch
 func (c *Consul) Resolve(serviceName string) ([]*selector.Node, error) {
 
-	pairs, _, err := c.client.KV().List(serviceName, nil)
-	if err != nil {
-		return nil, err
+	nodes, ok := c.cachedNodes(serviceName)
+	if !ok {
+		var err error
+		if nodes, err = c.fetch(serviceName); err != nil {
+			return nil, err
+		}
 	}
 
-	if len(pairs) == 0 {
+	if len(nodes) == 0 {
 		return nil, fmt.Errorf("no services find in path : %s", serviceName)
 	}
-	var nodes []*selector.Node
-	for _, pair := range pairs {
-		nodes = append(nodes, &selector.Node{
-			Key:   pair.Key,
-			Value: pair.Value,
-		})
-	}
 	return nodes, nil
 }
 
-func parseAddrFromNode(node *selector.Node) (string, error) {
-	if node.Key == "" {
-		return "", errors.New("addr is empty")
+// Report 将调用结果反馈给 Balancer
+func (c *Consul) Report(serviceName string, info selector.DoneInfo) {
+	if c.service != "" {
+		serviceName = c.service
 	}
-
-	strs := strings.Split(node.Key, "/")
-
-	return strs[len(strs)-1], nil
+	loadbalance.Report(c.opts.BalancerName, serviceName, info)
 }
 
 // consul 需要实现plugin的接口
 // Server 启动的时候需要将 Service 去 consul 上进行注册，这个方法实现了服务注册的过程。
-// 想将服务名和服务地址包装秤 KVPair 的形式，然后通过调用 consul api 的 Put 方法进行的将 KVPair 同步到 consul 的 k-v 存储。
+// 每个服务通过 consul agent 的服务接口注册一个带健康检查的服务实例，使用 ttl 检查时启动后台的上报
 func (c *Consul) Init(opts ...plugin.Option) error {
 
 	for _, o := range opts {
@@ -140,16 +164,50 @@ func (c *Consul) Init(opts ...plugin.Option) error {
 		return err
 	}
 
+	var ids []string
 	for _, serviceName := range c.opts.Services {
-		nodeName := fmt.Sprintf("%s/%s", serviceName, c.opts.SvrAddr)
+		reg, err := c.registration(serviceName)
+		if err != nil {
+			return err
+		}
 
-		kvPair := &api.KVPair{
-			Key:   nodeName,
-			Value: []byte(c.opts.SvrAddr),
-			Flags: api.LockFlagValue,
+		if err := c.client.Agent().ServiceRegister(reg); err != nil {
+			return err
 		}
+		ids = append(ids, reg.ID)
+	}
+
+	if c.opts.HealthCheck == plugin.HealthCheckTTL {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.mu.Lock()
+		c.stopHeartbeat = cancel
+		c.mu.Unlock()
+		go c.heartbeat(ctx, ids)
+	}
+
+	return nil
+}
 
-		if _, err := c.client.KV().Put(kvPair, c.writeOptions); err != nil {
+// Deregister 实现 plugin.Deregisterer，在 server 退出时停止 ttl 上报，并注销 Init 中注册的服务实例，避免 client 继续发现已经下线的节点
+func (c *Consul) Deregister(opts ...plugin.Option) error {
+
+	for _, o := range opts {
+		o(c.opts)
+	}
+
+	c.mu.Lock()
+	if c.stopHeartbeat != nil {
+		c.stopHeartbeat()
+		c.stopHeartbeat = nil
+	}
+	c.mu.Unlock()
+
+	if c.client == nil {
+		return nil
+	}
+
+	for _, serviceName := range c.opts.Services {
+		if err := c.client.Agent().ServiceDeregister(c.serviceID(serviceName)); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,188 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/plugin"
+	"github.com/junaozun/go-lrpxc/selector"
+
+	"github.com/hashicorp/consul/api"
+)
+
+/*
+服务注册：server 通过 consul agent 的服务接口注册服务实例，每个服务名注册一个实例，实例 ID 是 服务名-地址。
+实例带有健康检查，tcp 检查由 consul 定时连接 server 地址，ttl 检查由 server 定时上报，
+server 崩溃后实例变为不健康，client 只会发现健康的实例，不健康超过 deregisterCriticalAfter 的实例被 consul 自动注销。
+实例的标签写在 Tags 中，权重写在 Meta 的 weight 中，Resolve 时映射为 selector.Node.Weight。
+实例的地址是 AdvertiseAddr，没有设置时使用 server 的监听地址，监听所有网卡时使用本机第一个可以路由的网卡地址。
+*/
+
+const (
+	defaultHealthCheckInterval = 10 * time.Second
+	deregisterCriticalAfter    = time.Minute // the minimum value accepted by consul
+	metaWeight                 = "weight"
+	defaultWeight              = 1
+)
+
+// serviceID 返回服务实例的 ID
+func (c *Consul) serviceID(serviceName string) string {
+	return fmt.Sprintf("%s-%s", serviceName, c.opts.SvrAddr)
+}
+
+// registration 返回服务实例的注册信息
+func (c *Consul) registration(serviceName string) (*api.AgentServiceRegistration, error) {
+	host, portStr, err := c.advertiseAddr()
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	weight := c.opts.Weight
+	if weight <= 0 {
+		weight = defaultWeight
+	}
+
+	return &api.AgentServiceRegistration{
+		ID:      c.serviceID(serviceName),
+		Name:    serviceName,
+		Address: host,
+		Port:    port,
+		Tags:    c.opts.Tags,
+		Meta: map[string]string{
+			metaWeight: strconv.Itoa(weight),
+		},
+		Check: c.healthCheck(host, portStr),
+	}, nil
+}
+
+// advertiseAddr 返回注册到 consul 的地址。0.0.0.0、:: 这样的地址其他节点无法连接，
+// server 监听所有网卡并且没有设置 AdvertiseAddr 时使用本机第一个可以路由的网卡地址
+func (c *Consul) advertiseAddr() (host, port string, err error) {
+	host, port, err = net.SplitHostPort(c.opts.SvrAddr)
+	if err != nil {
+		return "", "", err
+	}
+
+	if addr := c.opts.AdvertiseAddr; addr != "" {
+		if h, p, err := net.SplitHostPort(addr); err == nil {
+			return h, p, nil
+		}
+		return addr, port, nil
+	}
+
+	if host != "" && !net.ParseIP(host).IsUnspecified() {
+		return host, port, nil
+	}
+	ip, err := routableIP()
+	if err != nil {
+		return "", "", fmt.Errorf("server listens on %s, set the address registered to consul by plugin.WithAdvertiseAddr, %v", c.opts.SvrAddr, err)
+	}
+	return ip.String(), port, nil
+}
+
+// routableIP 返回本机第一个可以路由的网卡地址，优先使用 IPv4 地址
+func routableIP() (net.IP, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	var v6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			return ip4, nil
+		}
+		if v6 == nil {
+			v6 = ipNet.IP
+		}
+	}
+	if v6 == nil {
+		return nil, errors.New("no routable interface address")
+	}
+	return v6, nil
+}
+
+// healthCheck 返回服务实例的健康检查，tcp 检查连接注册的地址
+func (c *Consul) healthCheck(host, port string) *api.AgentServiceCheck {
+	interval := c.healthCheckInterval()
+	check := &api.AgentServiceCheck{
+		DeregisterCriticalServiceAfter: deregisterCriticalAfter.String(),
+	}
+
+	if c.opts.HealthCheck == plugin.HealthCheckTTL {
+		check.TTL = interval.String()
+		return check
+	}
+
+	check.TCP = net.JoinHostPort(host, port)
+	check.Interval = interval.String()
+	check.Timeout = (interval / 2).String()
+	return check
+}
+
+func (c *Consul) healthCheckInterval() time.Duration {
+	if c.opts.HealthCheckInterval > 0 {
+		return c.opts.HealthCheckInterval
+	}
+	return defaultHealthCheckInterval
+}
+
+// heartbeat 每隔 TTL/2 上报一次 ttl 检查，直到 ctx 结束。服务实例的检查 ID 是 service:实例 ID
+func (c *Consul) heartbeat(ctx context.Context, serviceIDs []string) {
+	ticker := time.NewTicker(c.healthCheckInterval() / 2)
+	defer ticker.Stop()
+
+	for {
+		for _, id := range serviceIDs {
+			if err := c.client.Agent().UpdateTTL("service:"+id, "", api.HealthPassing); err != nil {
+				fmt.Printf("consul update ttl of %s error, %v\n", id, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// toNodes 将健康的服务实例转换成节点，节点的 Key 是 服务名/地址，权重取自 Meta 中的 weight
+func toNodes(serviceName string, entries []*api.ServiceEntry) []*selector.Node {
+	nodes := make([]*selector.Node, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Service == nil {
+			continue
+		}
+		host := entry.Service.Address
+		if host == "" && entry.Node != nil {
+			host = entry.Node.Address
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(entry.Service.Port))
+
+		weight, err := strconv.Atoi(entry.Service.Meta[metaWeight])
+		if err != nil || weight <= 0 {
+			weight = defaultWeight
+		}
+
+		nodes = append(nodes, &selector.Node{
+			Key:     serviceName + "/" + addr,
+			Value:   []byte(addr),
+			Weight:  weight,
+			Address: addr,
+		})
+	}
+	return nodes
+}
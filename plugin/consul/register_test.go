@@ -0,0 +1,73 @@
+package consul
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/junaozun/go-lrpxc/plugin"
+)
+
+func TestRegistrationAddress(t *testing.T) {
+	cases := []struct {
+		svrAddr, advertiseAddr string
+		host, port             string
+	}{
+		{"10.0.0.1:8000", "", "10.0.0.1", "8000"},
+		{"0.0.0.0:8000", "10.0.0.2", "10.0.0.2", "8000"},
+		{"[::]:8000", "10.0.0.2:9000", "10.0.0.2", "9000"},
+		{":8000", "[fd00::2]:9000", "fd00::2", "9000"},
+	}
+
+	for _, tc := range cases {
+		c := &Consul{opts: &plugin.Options{SvrAddr: tc.svrAddr, AdvertiseAddr: tc.advertiseAddr}}
+		reg, err := c.registration("helloworld.Greeter")
+		if err != nil {
+			t.Errorf("%s advertise %q: %v", tc.svrAddr, tc.advertiseAddr, err)
+			continue
+		}
+		addr := net.JoinHostPort(reg.Address, strconv.Itoa(reg.Port))
+		if want := net.JoinHostPort(tc.host, tc.port); addr != want || reg.Check.TCP != want {
+			t.Errorf("%s advertise %q: registered %s, tcp check %s, want %s", tc.svrAddr, tc.advertiseAddr, addr, reg.Check.TCP, want)
+		}
+	}
+}
+
+func TestRegistrationUnspecifiedHost(t *testing.T) {
+	for _, svrAddr := range []string{"0.0.0.0:8000", "[::]:8000", ":8000"} {
+		c := &Consul{opts: &plugin.Options{SvrAddr: svrAddr}}
+		reg, err := c.registration("helloworld.Greeter")
+		if _, e := routableIP(); e != nil {
+			// 本机没有可以路由的网卡地址时需要设置 AdvertiseAddr
+			if err == nil {
+				t.Errorf("%s: registration succeeded without a routable address", svrAddr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip := net.ParseIP(reg.Address); ip == nil || ip.IsUnspecified() || ip.IsLoopback() {
+			t.Errorf("%s: registered %q, want a routable address", svrAddr, reg.Address)
+		}
+	}
+}
+
+func TestInitRegistersAdvertiseAddr(t *testing.T) {
+	stub, addr := newConsulStub(t)
+
+	c := &Consul{opts: &plugin.Options{}}
+	err := c.Init(plugin.WithSelectorSvrAddr(addr), plugin.WithSvrAddr("0.0.0.0:8000"),
+		plugin.WithServices([]string{"helloworld.Greeter"}), plugin.WithAdvertiseAddr("10.0.0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	regs := stub.registrations()
+	if len(regs) != 1 {
+		t.Fatalf("%d instances registered, want 1", len(regs))
+	}
+	if regs[0].Name != "helloworld.Greeter" || regs[0].Address != "10.0.0.2" || regs[0].Port != 8000 {
+		t.Fatalf("registered %s at %s:%d, want helloworld.Greeter at 10.0.0.2:8000", regs[0].Name, regs[0].Address, regs[0].Port)
+	}
+}
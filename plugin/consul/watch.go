@@ -0,0 +1,160 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/selector"
+
+	"github.com/hashicorp/consul/api"
+)
+
+/*
+服务节点缓存：第一次 Resolve 一个服务时从 consul 拉取健康的节点列表放进本地缓存，并启动一个后台 watcher，
+watcher 通过 consul 的阻塞查询（WaitIndex）等待服务节点变化，变化后更新缓存，Select 只读本地缓存，
+consul 不再处于每次调用的链路上。consul 不可用时 watcher 按退避时间重试，期间继续使用最后一次拉取到的节点列表。
+*/
+
+const (
+	defaultWaitTime  = 5 * time.Minute // max blocking time of a watch query
+	minRetryInterval = time.Second     // retry interval after the first watch failure
+	maxRetryInterval = 30 * time.Second
+)
+
+// cachedNodes 返回服务的缓存节点，没有缓存时 ok 为 false
+func (c *Consul) cachedNodes(serviceName string) (nodes []*selector.Node, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	nodes, ok = c.services[serviceName]
+	return nodes, ok
+}
+
+// fetch 从 consul 拉取服务节点并缓存，同时启动服务的 watcher
+func (c *Consul) fetch(serviceName string) ([]*selector.Node, error) {
+	entries, meta, err := c.client.Health().Service(serviceName, c.tag, true, c.queryOptions)
+	if err != nil {
+		return nil, err
+	}
+	nodes := toNodes(serviceName, entries)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 并发的第一次 Resolve 只保留一份缓存和一个 watcher
+	if cached, ok := c.services[serviceName]; ok {
+		return cached, nil
+	}
+	if c.services == nil {
+		c.services = make(map[string][]*selector.Node)
+	}
+	if c.ctx == nil {
+		c.ctx, c.cancel = context.WithCancel(context.Background())
+	}
+	c.services[serviceName] = nodes
+	go c.watch(c.ctx, serviceName, meta.LastIndex)
+
+	return nodes, nil
+}
+
+// watch 通过阻塞查询等待服务节点变化并更新缓存，直到 Close
+func (c *Consul) watch(ctx context.Context, serviceName string, index uint64) {
+	retry := minRetryInterval
+	for {
+		q := &api.QueryOptions{
+			WaitIndex: index,
+			WaitTime:  defaultWaitTime,
+		}
+		entries, meta, err := c.client.Health().Service(serviceName, c.tag, true, q.WithContext(ctx))
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fmt.Printf("consul watch %s error, keep the last nodes, %v\n", serviceName, err)
+			if !sleep(ctx, retry) {
+				return
+			}
+			if retry *= 2; retry > maxRetryInterval {
+				retry = maxRetryInterval
+			}
+			continue
+		}
+		retry = minRetryInterval
+
+		// 阻塞查询超时，节点没有变化
+		if meta.LastIndex == index {
+			continue
+		}
+		// consul 的索引可能被重置，索引变小时重新开始
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+
+		if !c.update(ctx, serviceName, entries) {
+			return
+		}
+
+		// 索引为 0 时查询不会阻塞，避免空转
+		if index == 0 && !sleep(ctx, minRetryInterval) {
+			return
+		}
+	}
+}
+
+// update 用 watcher 查询到的实例更新缓存，watcher 已经被 Close 停止时返回 false。
+// Close 在持有 c.mu 时取消 ctx 并清空缓存，所以持有锁之后再检查一次 ctx，避免写入已经清空的缓存
+func (c *Consul) update(ctx context.Context, serviceName string, entries []*api.ServiceEntry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return false
+	}
+	c.services[serviceName] = toNodes(serviceName, entries)
+	return true
+}
+
+// Start 实现 plugin.Lifecycle，服务注册在 Init 中完成，watcher 在第一次 Resolve 时启动
+func (c *Consul) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop 实现 plugin.Lifecycle，停止 ttl 上报和所有服务的 watcher。服务实例的注销由 Deregister 完成
+func (c *Consul) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	if c.stopHeartbeat != nil {
+		c.stopHeartbeat()
+		c.stopHeartbeat = nil
+	}
+	c.mu.Unlock()
+
+	return c.Close()
+}
+
+// Close 停止所有服务的 watcher
+func (c *Consul) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.ctx, c.cancel = nil, nil
+	c.services = nil
+	return nil
+}
+
+// sleep 等待 d，ctx 结束时返回 false
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,226 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/plugin"
+	"github.com/junaozun/go-lrpxc/selector"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// consulStub 是本地的 consul HTTP 接口桩，实现了服务注册和支持阻塞查询的健康实例查询
+type consulStub struct {
+	mu         sync.Mutex
+	index      uint64
+	entries    map[string][]*api.ServiceEntry // serviceName -> healthy instances
+	changed    chan struct{}                  // closed and replaced on every update
+	registered []*api.AgentServiceRegistration
+}
+
+func newConsulStub(t *testing.T) (*consulStub, string) {
+	s := &consulStub{
+		index:   1,
+		entries: make(map[string][]*api.ServiceEntry),
+		changed: make(chan struct{}),
+	}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	return s, strings.TrimPrefix(srv.URL, "http://")
+}
+
+// setNodes 更新服务的健康实例，索引加一并唤醒阻塞查询
+func (s *consulStub) setNodes(serviceName string, addrs ...string) {
+	var entries []*api.ServiceEntry
+	for _, addr := range addrs {
+		host, port, _ := strings.Cut(addr, ":")
+		p, _ := strconv.Atoi(port)
+		entries = append(entries, &api.ServiceEntry{
+			Node:    &api.Node{Address: host},
+			Service: &api.AgentService{Service: serviceName, Address: host, Port: p},
+		})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[serviceName] = entries
+	s.index++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// registrations 返回注册过的服务实例
+func (s *consulStub) registrations() []*api.AgentServiceRegistration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*api.AgentServiceRegistration(nil), s.registered...)
+}
+
+func (s *consulStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/agent/service/register" {
+		reg := &api.AgentServiceRegistration{}
+		if err := json.NewDecoder(r.Body).Decode(reg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.registered = append(s.registered, reg)
+		s.mu.Unlock()
+		return
+	}
+
+	serviceName := strings.TrimPrefix(r.URL.Path, "/v1/health/service/")
+	if serviceName == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+
+	// 阻塞查询：索引没有变化时等待更新或者请求结束
+	wait, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	s.mu.Lock()
+	for wait != 0 && s.index <= wait {
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+		s.mu.Lock()
+	}
+	index, entries := s.index, s.entries[serviceName]
+	s.mu.Unlock()
+
+	if entries == nil {
+		entries = []*api.ServiceEntry{}
+	}
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func newTestConsul(t *testing.T, addr string) *Consul {
+	c := &Consul{
+		opts: &plugin.Options{SelectorSvrAddr: addr},
+	}
+	if err := c.InitConfig(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func resolvedAddrs(c *Consul, serviceName string) []string {
+	nodes, err := c.Resolve(serviceName)
+	if err != nil {
+		return nil
+	}
+	var addrs []string
+	for _, n := range nodes {
+		addrs = append(addrs, n.Addr())
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func waitAddrs(t *testing.T, c *Consul, serviceName string, want ...string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got := resolvedAddrs(c, serviceName)
+		if strings.Join(got, ",") == strings.Join(want, ",") {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("nodes = %v, want %v", got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatchUpdatesCache(t *testing.T) {
+	stub, addr := newConsulStub(t)
+	stub.setNodes("helloworld.Greeter", "10.0.0.1:8000")
+
+	c := newTestConsul(t, addr)
+	defer c.Close()
+
+	waitAddrs(t, c, "helloworld.Greeter", "10.0.0.1:8000")
+
+	// 服务节点变化后 watcher 更新缓存
+	stub.setNodes("helloworld.Greeter", "10.0.0.1:8000", "10.0.0.2:8000")
+	waitAddrs(t, c, "helloworld.Greeter", "10.0.0.1:8000", "10.0.0.2:8000")
+
+	// 没有健康实例时 Resolve 返回错误
+	stub.setNodes("helloworld.Greeter")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := c.Resolve("helloworld.Greeter"); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Resolve succeeded after all instances were removed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestUpdateAfterClose(t *testing.T) {
+	c := newTestConsul(t, "127.0.0.1:8500")
+
+	c.mu.Lock()
+	c.services = make(map[string][]*selector.Node)
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	ctx := c.ctx
+	c.mu.Unlock()
+
+	entries := []*api.ServiceEntry{{Service: &api.AgentService{Address: "10.0.0.1", Port: 8000}}}
+	if !c.update(ctx, "helloworld.Greeter", entries) {
+		t.Fatal("update failed before Close")
+	}
+
+	// watcher 在 Close 之前拿到了回包，Close 之后才更新缓存
+	c.Close()
+	if c.update(ctx, "helloworld.Greeter", entries) {
+		t.Fatal("update succeeded after Close")
+	}
+	if _, ok := c.cachedNodes("helloworld.Greeter"); ok {
+		t.Fatal("nodes were cached after Close")
+	}
+}
+
+func TestCloseWhileWatching(t *testing.T) {
+	stub, addr := newConsulStub(t)
+	stub.setNodes("helloworld.Greeter", "10.0.0.1:8000")
+
+	c := newTestConsul(t, addr)
+
+	// watcher 拿到回包和 Close 清空缓存并发发生时不能写入已经清空的缓存
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			stub.setNodes("helloworld.Greeter", "10.0.0.1:8000", "10.0.0."+strconv.Itoa(i%250+2)+":8000")
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if _, err := c.Resolve("helloworld.Greeter"); err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+	}
+	<-done
+
+	// Close 之后再次 Resolve 会重新拉取并开始 watch
+	stub.setNodes("helloworld.Greeter", "10.0.0.9:8000")
+	waitAddrs(t, c, "helloworld.Greeter", "10.0.0.9:8000")
+	c.Close()
+}
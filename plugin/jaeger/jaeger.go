@@ -4,10 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"sync"
 
+	"github.com/junaozun/go-lrpxc/codes"
 	"github.com/junaozun/go-lrpxc/interceptor"
+	"github.com/junaozun/go-lrpxc/metadata"
 	"github.com/junaozun/go-lrpxc/plugin"
+	"github.com/junaozun/go-lrpxc/stream"
 	"github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
 	"github.com/opentracing/opentracing-go/log"
@@ -16,6 +21,9 @@ import (
 
 type Jaeger struct {
 	opts *plugin.Options
+
+	mu      sync.Mutex
+	closers []io.Closer // closers of the tracers created by Init, flush the buffered spans when closed
 }
 
 const Name = "jaeger"
@@ -40,7 +48,9 @@ func (m jaegerCarrier) Set(key, val string) {
 
 func (m jaegerCarrier) ForeachKey(handler func(key, val string) error) error {
 	for k, v := range m {
-		handler(k, string(v))
+		if err := handler(k, string(v)); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -59,6 +69,36 @@ func (j *Jaeger) Init(opts ...plugin.Option) (opentracing.Tracer, error) {
 
 }
 
+// Start 实现 plugin.Lifecycle，tracer 在 Init 中已经创建好了
+func (j *Jaeger) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop 实现 plugin.Lifecycle，关闭 Init 创建的所有 tracer，上报还在缓冲中的 span
+func (j *Jaeger) Stop(ctx context.Context) error {
+	j.mu.Lock()
+	closers := j.closers
+	j.closers = nil
+	j.mu.Unlock()
+
+	var errs plugin.Errors
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (j *Jaeger) addCloser(c io.Closer) {
+	j.mu.Lock()
+	j.closers = append(j.closers, c)
+	j.mu.Unlock()
+}
+
 // 第一是初始化 jaeger 的配置，并且通过这些初始化配置来创建一个 tracer 实例，第二是将这个 tracer 实例作为
 // opentracing 规范的实现。之前我们说到了 opentracing 只是一套规范，并没有进行链路追踪的具体实现，
 // 所以无论你是使用 jaeger 还是 zipkin 等其他链路追踪系统，你都需要进行 opentracing.SetGlobalTracer(tracer) ，
@@ -76,70 +116,126 @@ func initJaeger(tracingSvrAddr string, jaegerServiceName string, opts ...plugin.
 		ServiceName: jaegerServiceName,
 	}
 
-	tracer, _, err := cfg.NewTracer()
+	tracer, closer, err := cfg.NewTracer()
 	if err != nil {
 		return nil, err
 	}
+	// server 和 client 的 tracer 都在 JaegerSvr.Stop 时关闭
+	JaegerSvr.addCloser(closer)
 
 	opentracing.SetGlobalTracer(tracer)
 
 	return tracer, err
 }
 
-// 客户端jaeger的初始化
+// 客户端jaeger的初始化，client 退出前需要调用 client.Close(ctx, JaegerSvr) 关闭 tracer，否则缓冲中的 span 会丢失
 func Init(tracingSvrAddr string, opts ...plugin.Option) (opentracing.Tracer, error) {
 	return initJaeger(tracingSvrAddr, JaegerClientName, opts...)
 }
 
-// client 端上报 span，主要的步骤是，先通过 opentracing.SpanFromContext 获取上游带下来的 span 上下文信息，接着调用 tracer.StartSpan 创建一个 client span，
-// 通过调用 tracer.Inject，将所需要透传给下游的一些信息塞到 Span 里面。jaegerCarrier 是一种 map[string] []byte 结构，用来作为传输一些 key-value 数据的载体
+// client 端上报 span，主要的步骤是，先通过 opentracing.SpanFromContext 获取上游带下来的 span，创建它的子 span 作为 client span，
+// 接着调用 tracer.Inject，将 span 上下文写进 client 透传给 server 的元数据，随请求包头的 Metadata 传给下游。
+// jaegerCarrier 是一种 map[string] []byte 结构，和元数据的结构相同。spanName 为空时使用服务路径作为 span 名
 func OpenTracingClientInterceptor(tracer opentracing.Tracer, spanName string) interceptor.ClientInterceptor {
 
 	return func(ctx context.Context, req, rsp interface{}, ivk interceptor.Invoker) error {
 
-		// var parentCtx opentracing.SpanContext
-		//
-		// if parent := opentracing.SpanFromContext(ctx); parent != nil {
-		//	parentCtx = parent.Context()
-		// }
+		var serviceName, method string
+		if cs, ok := ctx.Value(stream.ClientStreamKey).(*stream.ClientStream); ok {
+			serviceName, method = cs.ServiceName, cs.Method
+		}
 
-		// clientSpan := tracer.StartSpan(spanName, ext.SpanKindRPCClient, opentracing.ChildOf(parentCtx))
-		clientSpan := tracer.StartSpan(spanName, ext.SpanKindRPCClient)
+		opts := []opentracing.StartSpanOption{ext.SpanKindRPCClient}
+		if parent := opentracing.SpanFromContext(ctx); parent != nil {
+			opts = append(opts, opentracing.ChildOf(parent.Context()))
+		}
+		clientSpan := tracer.StartSpan(operationName(spanName, serviceName, method), opts...)
 		defer clientSpan.Finish()
 
-		mdCarrier := &jaegerCarrier{}
+		setRPCTags(clientSpan, serviceName, method)
+		ext.PeerService.Set(clientSpan, serviceName)
 
-		if err := tracer.Inject(clientSpan.Context(), opentracing.HTTPHeaders, mdCarrier); err != nil {
+		// 拷贝一份元数据再写入，避免修改调用方共享的元数据
+		md := make(map[string][]byte)
+		for k, v := range metadata.ClientMetadata(ctx) {
+			md[k] = v
+		}
+		if err := tracer.Inject(clientSpan.Context(), opentracing.HTTPHeaders, jaegerCarrier(md)); err != nil {
 			clientSpan.LogFields(log.String("event", "Tracer.Inject() failed"), log.Error(err))
 		}
+		ctx = metadata.WithClientMetadata(ctx, md)
+		ctx = opentracing.ContextWithSpan(ctx, clientSpan)
 
-		clientSpan.LogFields(log.String("spanName", spanName))
-
-		return ivk(ctx, req, rsp)
+		err := ivk(ctx, req, rsp)
+		setErrorTags(clientSpan, err)
+		return err
 
 	}
 }
 
-// server 端上报 span，主要的步骤是，先调用 tracer.Extract 解析 Span 的上下文信息，获得一个 SpanContext，接着调用 tracer.StartSpan
-// 进行创建一个 server span，并且把 server span 放到上下文 context 中进行透传，jeager 会自动对 span 进行上报
+// server 端上报 span，主要的步骤是，先调用 tracer.Extract 从 client 透传的元数据中解析出上游 span 的上下文信息，获得一个 SpanContext，
+// 接着调用 tracer.StartSpan 创建它的子 span 作为 server span，并且把 server span 放到上下文 context 中进行透传，
+// handler 中继续发起的调用会成为 server span 的子 span，jeager 会自动对 span 进行上报
 func OpenTracingServerInterceptor(tracer opentracing.Tracer, spanName string) interceptor.ServerInterceptor {
 
 	return func(ctx context.Context, req interface{}, handler interceptor.Handler) (interface{}, error) {
 
-		mdCarrier := &jaegerCarrier{}
+		ss := stream.GetServerStream2(ctx)
 
-		spanContext, err := tracer.Extract(opentracing.HTTPHeaders, mdCarrier)
+		spanContext, err := tracer.Extract(opentracing.HTTPHeaders, jaegerCarrier(metadata.ServerMetadata(ctx)))
 		if err != nil && err != opentracing.ErrSpanContextNotFound {
 			return nil, errors.New(fmt.Sprintf("tracer extract codes : %v", err))
 		}
-		serverSpan := tracer.StartSpan(spanName, ext.RPCServerOption(spanContext), ext.SpanKindRPCServer)
+		serverSpan := tracer.StartSpan(operationName(spanName, ss.ServiceName, ss.Method),
+			ext.RPCServerOption(spanContext), ext.SpanKindRPCServer)
 		defer serverSpan.Finish()
 
+		setRPCTags(serverSpan, ss.ServiceName, ss.Method)
+		if ss.RemoteAddr != "" {
+			serverSpan.SetTag(tagPeerAddress, ss.RemoteAddr)
+		}
+
 		ctx = opentracing.ContextWithSpan(ctx, serverSpan)
 
-		serverSpan.LogFields(log.String("spanName", spanName))
+		rsp, err := handler(ctx, req)
+		setErrorTags(serverSpan, err)
+		return rsp, err
+	}
+
+}
+
+const (
+	tagRPCService  = "rpc.service"
+	tagRPCMethod   = "rpc.method"
+	tagRPCCode     = "rpc.code"
+	tagPeerAddress = "peer.address"
+)
 
-		return handler(ctx, req)
+// operationName 返回 span 名，没有指定时使用服务路径，比如 /helloworld.Greeter/SayHello
+func operationName(spanName, serviceName, method string) string {
+	if spanName != "" {
+		return spanName
 	}
+	return "/" + serviceName + "/" + method
+}
+
+func setRPCTags(span opentracing.Span, serviceName, method string) {
+	ext.Component.Set(span, "lrpc")
+	span.SetTag(tagRPCService, serviceName)
+	span.SetTag(tagRPCMethod, method)
+}
 
+// setErrorTags 调用失败时标记 span 出错，记录错误码和错误信息
+func setErrorTags(span opentracing.Span, err error) {
+	if err == nil {
+		span.SetTag(tagRPCCode, 0)
+		return
+	}
+
+	var e *codes.Error
+	if errors.As(err, &e) {
+		span.SetTag(tagRPCCode, e.Code)
+	}
+	ext.Error.Set(span, true)
+	span.LogFields(log.Error(err))
 }
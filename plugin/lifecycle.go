@@ -0,0 +1,60 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+)
+
+// Lifecycle 是插件可选实现的生命周期接口。Start 在插件 Init 之后、server 提供服务之前调用，
+// Stop 在 server 退出或者 client 关闭时调用，用来释放插件持有的资源，比如上报还在缓冲中的 span。
+// Stop 可能被调用多次，实现需要保证重复调用是安全的
+type Lifecycle interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// Errors 汇总多个插件返回的错误
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Start 按顺序启动实现了 Lifecycle 的插件，某个插件启动失败时按相反的顺序停止已经启动的插件，返回启动失败的错误
+func Start(ctx context.Context, plugins ...Plugin) error {
+	for i, p := range plugins {
+		l, ok := p.(Lifecycle)
+		if !ok {
+			continue
+		}
+		if err := l.Start(ctx); err != nil {
+			if e := Stop(ctx, plugins[:i]...); e != nil {
+				return Errors{err, e}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop 按相反的顺序停止实现了 Lifecycle 的插件，某个插件停止失败不影响其他插件，返回所有插件的错误
+func Stop(ctx context.Context, plugins ...Plugin) error {
+	var errs Errors
+	for i := len(plugins) - 1; i >= 0; i-- {
+		l, ok := plugins[i].(Lifecycle)
+		if !ok {
+			continue
+		}
+		if err := l.Stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
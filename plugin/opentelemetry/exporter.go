@@ -0,0 +1,147 @@
+package opentelemetry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Span 是导出的 span，FileExporter 把每个 span 作为一行 json 写进文件，MemoryExporter 把 span 保存在内存中
+type Span struct {
+	Service       string                 `json:"service,omitempty"`
+	Name          string                 `json:"name"`
+	Kind          string                 `json:"kind"`
+	TraceID       string                 `json:"trace_id"`
+	SpanID        string                 `json:"span_id"`
+	ParentSpanID  string                 `json:"parent_span_id,omitempty"`
+	StartTime     time.Time              `json:"start_time"`
+	EndTime       time.Time              `json:"end_time"`
+	Attributes    map[string]interface{} `json:"attributes,omitempty"`
+	StatusCode    string                 `json:"status_code"`
+	StatusMessage string                 `json:"status_message,omitempty"`
+}
+
+func toSpan(s sdktrace.ReadOnlySpan) Span {
+	span := Span{
+		Name:          s.Name(),
+		Kind:          s.SpanKind().String(),
+		TraceID:       s.SpanContext().TraceID().String(),
+		SpanID:        s.SpanContext().SpanID().String(),
+		StartTime:     s.StartTime(),
+		EndTime:       s.EndTime(),
+		StatusCode:    s.Status().Code.String(),
+		StatusMessage: s.Status().Description,
+	}
+	if s.Parent().IsValid() {
+		span.ParentSpanID = s.Parent().SpanID().String()
+	}
+	if res := s.Resource(); res != nil {
+		if v, ok := res.Set().Value(serviceNameKey); ok {
+			span.Service = v.AsString()
+		}
+	}
+	if attrs := s.Attributes(); len(attrs) > 0 {
+		span.Attributes = make(map[string]interface{}, len(attrs))
+		for _, kv := range attrs {
+			span.Attributes[string(kv.Key)] = kv.Value.AsInterface()
+		}
+	}
+	return span
+}
+
+var errExporterShutdown = errors.New("opentelemetry exporter is shut down")
+
+// FileExporter 把 span 以 json lines 的格式追加写入本地文件
+type FileExporter struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// NewFileExporter 打开 path 指定的文件，文件不存在时创建
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{
+		f:   f,
+		enc: json.NewEncoder(f),
+	}, nil
+}
+
+// ExportSpans 实现 sdktrace.SpanExporter
+func (e *FileExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.f == nil {
+		return errExporterShutdown
+	}
+	for _, s := range spans {
+		if err := e.enc.Encode(toSpan(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown 实现 sdktrace.SpanExporter，关闭文件，重复调用是安全的
+func (e *FileExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.f == nil {
+		return nil
+	}
+	err := e.f.Close()
+	e.f = nil
+	return err
+}
+
+// MemoryExporter 把 span 保存在内存中，用于测试。和 tracetest.InMemoryExporter 不同，Shutdown 之后
+// 已经导出的 span 仍然可以通过 Spans 读取，方便在 server 或者 client 退出之后检查上报的 span
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []Span
+}
+
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+// ExportSpans 实现 sdktrace.SpanExporter
+func (e *MemoryExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, s := range spans {
+		e.spans = append(e.spans, toSpan(s))
+	}
+	return nil
+}
+
+// Shutdown 实现 sdktrace.SpanExporter
+func (e *MemoryExporter) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// Spans 按结束的顺序返回已经导出的 span
+func (e *MemoryExporter) Spans() []Span {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return append([]Span(nil), e.spans...)
+}
+
+// Reset 清空已经导出的 span
+func (e *MemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
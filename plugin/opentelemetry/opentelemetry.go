@@ -0,0 +1,291 @@
+package opentelemetry
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/interceptor"
+	"github.com/junaozun/go-lrpxc/metadata"
+	"github.com/junaozun/go-lrpxc/plugin"
+	"github.com/junaozun/go-lrpxc/stream"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenTelemetry 是基于 OpenTelemetry 的链路追踪插件，实现了 plugin.Tracer。span 上下文按 W3C Trace Context
+// 规范写进请求的元数据（traceparent、tracestate），span 由 exporter 导出到本地文件或者内存中
+type OpenTelemetry struct {
+	opts *plugin.Options
+
+	mu        sync.Mutex
+	exporter  sdktrace.SpanExporter      // exporter set by SetExporter, takes precedence over TracingSvrAddr
+	tracer    trace.Tracer               // tracer created by Init
+	providers []*sdktrace.TracerProvider // providers created by Init, flush the buffered spans when shut down
+}
+
+const Name = "opentelemetry"
+const OtelClientName = "gorpc-client-otel"
+const OtelServerName = "gorpc-server-otel"
+
+const instrumentationName = "github.com/junaozun/go-lrpxc"
+
+const serviceNameKey = attribute.Key("service.name")
+
+func init() {
+	plugin.Register(Name, OtelSvr)
+}
+
+// global opentelemetry objects for framework
+var OtelSvr = &OpenTelemetry{
+	opts: &plugin.Options{},
+}
+
+// propagator 按 W3C Trace Context 规范读写 traceparent 和 tracestate
+var propagator = propagation.TraceContext{}
+
+// otelCarrier 是元数据的 propagation.TextMapCarrier 实现
+type otelCarrier map[string][]byte
+
+func (m otelCarrier) Get(key string) string {
+	return string(m[strings.ToLower(key)])
+}
+
+func (m otelCarrier) Set(key, val string) {
+	m[strings.ToLower(key)] = []byte(val)
+}
+
+func (m otelCarrier) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// SetExporter 设置 server 端 span 的 exporter，需要在 Init 之前调用。没有设置时 Init 把 span 写进
+// TracingSvrAddr 指定的本地文件，测试时可以设置一个 MemoryExporter
+func (o *OpenTelemetry) SetExporter(exporter sdktrace.SpanExporter) {
+	o.mu.Lock()
+	o.exporter = exporter
+	o.mu.Unlock()
+}
+
+func (o *OpenTelemetry) Init(opts ...plugin.Option) error {
+
+	for _, opt := range opts {
+		opt(o.opts)
+	}
+
+	o.mu.Lock()
+	exporter := o.exporter
+	o.mu.Unlock()
+
+	if exporter == nil {
+		if o.opts.TracingSvrAddr == "" {
+			return errors.New("opentelemetry init codes, traingSvrAddr is empty")
+		}
+		fileExporter, err := NewFileExporter(strings.TrimPrefix(o.opts.TracingSvrAddr, "file://"))
+		if err != nil {
+			return err
+		}
+		exporter = fileExporter
+	}
+
+	tracer := initOtel(exporter, OtelServerName)
+
+	o.mu.Lock()
+	o.tracer = tracer
+	o.mu.Unlock()
+
+	return nil
+}
+
+// ServerInterceptor 实现 plugin.Tracer，需要在 Init 之后调用
+func (o *OpenTelemetry) ServerInterceptor(spanName string) interceptor.ServerInterceptor {
+	return OtelServerInterceptor(o.getTracer(), spanName)
+}
+
+// ClientInterceptor 实现 plugin.Tracer，需要在 Init 之后调用
+func (o *OpenTelemetry) ClientInterceptor(spanName string) interceptor.ClientInterceptor {
+	return OtelClientInterceptor(o.getTracer(), spanName)
+}
+
+func (o *OpenTelemetry) getTracer() trace.Tracer {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.tracer == nil {
+		return trace.NewNoopTracerProvider().Tracer(instrumentationName)
+	}
+	return o.tracer
+}
+
+// Start 实现 plugin.Lifecycle，tracer 在 Init 中已经创建好了
+func (o *OpenTelemetry) Start(ctx context.Context) error {
+	return nil
+}
+
+// Stop 实现 plugin.Lifecycle，关闭 Init 创建的所有 TracerProvider，导出还在缓冲中的 span 并关闭 exporter
+func (o *OpenTelemetry) Stop(ctx context.Context) error {
+	o.mu.Lock()
+	providers := o.providers
+	o.providers = nil
+	o.mu.Unlock()
+
+	var errs plugin.Errors
+	for _, tp := range providers {
+		if err := tp.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (o *OpenTelemetry) addProvider(tp *sdktrace.TracerProvider) {
+	o.mu.Lock()
+	o.providers = append(o.providers, tp)
+	o.mu.Unlock()
+}
+
+// initOtel 创建一个全量采样的 TracerProvider，并设为 OpenTelemetry 的全局实现。MemoryExporter 同步导出 span，
+// 调用结束后马上可以读到，其他 exporter 批量导出
+func initOtel(exporter sdktrace.SpanExporter, serviceName string) trace.Tracer {
+	exportOpt := sdktrace.WithBatcher(exporter)
+	if _, ok := exporter.(*MemoryExporter); ok {
+		exportOpt = sdktrace.WithSyncer(exporter)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+		sdktrace.WithResource(resource.NewSchemaless(serviceNameKey.String(serviceName))),
+		exportOpt,
+	)
+	// server 和 client 的 TracerProvider 都在 OtelSvr.Stop 时关闭
+	OtelSvr.addProvider(tp)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+
+	return tp.Tracer(instrumentationName)
+}
+
+// 客户端 opentelemetry 的初始化，span 写进 tracingSvrAddr 指定的本地文件。client 退出前需要调用 client.Close(ctx, OtelSvr)
+// 关闭 TracerProvider，否则缓冲中的 span 会丢失
+func Init(tracingSvrAddr string, opts ...plugin.Option) (trace.Tracer, error) {
+	exporter, err := NewFileExporter(strings.TrimPrefix(tracingSvrAddr, "file://"))
+	if err != nil {
+		return nil, err
+	}
+	return initOtel(exporter, OtelClientName), nil
+}
+
+// InitWithExporter 和 Init 相同，span 由指定的 exporter 导出，比如测试时使用 MemoryExporter
+func InitWithExporter(exporter sdktrace.SpanExporter) trace.Tracer {
+	return initOtel(exporter, OtelClientName)
+}
+
+// client 端上报 span，先从上下文中取出上游的 span 作为父 span 创建 client span，接着通过 propagator 把 span 上下文
+// 按 traceparent、tracestate 写进 client 透传给 server 的元数据。spanName 为空时使用服务路径作为 span 名
+func OtelClientInterceptor(tracer trace.Tracer, spanName string) interceptor.ClientInterceptor {
+
+	return func(ctx context.Context, req, rsp interface{}, ivk interceptor.Invoker) error {
+
+		var serviceName, method string
+		if cs, ok := ctx.Value(stream.ClientStreamKey).(*stream.ClientStream); ok {
+			serviceName, method = cs.ServiceName, cs.Method
+		}
+
+		ctx, span := tracer.Start(ctx, operationName(spanName, serviceName, method),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(rpcAttributes(serviceName, method)...),
+			trace.WithAttributes(attrPeerService.String(serviceName)))
+		defer span.End()
+
+		// 拷贝一份元数据再写入，避免修改调用方共享的元数据
+		md := make(map[string][]byte)
+		for k, v := range metadata.ClientMetadata(ctx) {
+			md[k] = v
+		}
+		propagator.Inject(ctx, otelCarrier(md))
+		ctx = metadata.WithClientMetadata(ctx, md)
+
+		err := ivk(ctx, req, rsp)
+		setStatus(span, err)
+		return err
+	}
+}
+
+// server 端上报 span，先通过 propagator 从 client 透传的元数据中解析出上游 span 的上下文，创建它的子 span 作为 server span，
+// server span 随上下文传给 handler，handler 中继续发起的调用会成为 server span 的子 span
+func OtelServerInterceptor(tracer trace.Tracer, spanName string) interceptor.ServerInterceptor {
+
+	return func(ctx context.Context, req interface{}, handler interceptor.Handler) (interface{}, error) {
+
+		ss := stream.GetServerStream2(ctx)
+
+		ctx = propagator.Extract(ctx, otelCarrier(metadata.ServerMetadata(ctx)))
+
+		attrs := rpcAttributes(ss.ServiceName, ss.Method)
+		if ss.RemoteAddr != "" {
+			attrs = append(attrs, attrPeerAddress.String(ss.RemoteAddr))
+		}
+		ctx, span := tracer.Start(ctx, operationName(spanName, ss.ServiceName, ss.Method),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attrs...))
+		defer span.End()
+
+		rsp, err := handler(ctx, req)
+		setStatus(span, err)
+		return rsp, err
+	}
+}
+
+const (
+	attrRPCSystem   = attribute.Key("rpc.system")
+	attrRPCService  = attribute.Key("rpc.service")
+	attrRPCMethod   = attribute.Key("rpc.method")
+	attrRPCCode     = attribute.Key("rpc.code")
+	attrPeerService = attribute.Key("peer.service")
+	attrPeerAddress = attribute.Key("peer.address")
+)
+
+// operationName 返回 span 名，没有指定时使用服务路径，比如 /helloworld.Greeter/SayHello
+func operationName(spanName, serviceName, method string) string {
+	if spanName != "" {
+		return spanName
+	}
+	return "/" + serviceName + "/" + method
+}
+
+func rpcAttributes(serviceName, method string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attrRPCSystem.String("lrpc"),
+		attrRPCService.String(serviceName),
+		attrRPCMethod.String(method),
+	}
+}
+
+// setStatus 调用失败时标记 span 出错，记录错误码和错误信息
+func setStatus(span trace.Span, err error) {
+	if err == nil {
+		span.SetAttributes(attrRPCCode.Int(0))
+		return
+	}
+
+	var e *codes.Error
+	if errors.As(err, &e) {
+		span.SetAttributes(attrRPCCode.Int(int(e.Code)))
+	}
+	span.SetStatus(otelcodes.Error, err.Error())
+}
@@ -0,0 +1,139 @@
+package opentelemetry
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/metadata"
+	"github.com/junaozun/go-lrpxc/plugin"
+	"github.com/junaozun/go-lrpxc/stream"
+)
+
+// call 经过 client 和 server 拦截器发起一次调用，server 拦截器从 client 拦截器写入的元数据中解析 span 上下文
+func call(t *testing.T, o *OpenTelemetry, handlerErr error) error {
+	t.Helper()
+
+	ctx, cs := stream.NewClientStream(context.Background())
+	cs.WithServiceName("helloworld.Greeter")
+	cs.WithMethod("SayHello")
+
+	client := o.ClientInterceptor("")
+	server := o.ServerInterceptor("")
+
+	return client(ctx, nil, nil, func(ctx context.Context, req, rsp interface{}) error {
+		sctx := metadata.WithServerMetadata(context.Background(), metadata.ClientMetadata(ctx))
+		sctx = stream.WithServerStream(sctx, &stream.ServerStream{
+			ServiceName: "helloworld.Greeter",
+			Method:      "SayHello",
+			RemoteAddr:  "127.0.0.1:50000",
+		})
+		_, err := server(sctx, req, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, handlerErr
+		})
+		return err
+	})
+}
+
+func newTestTracer(t *testing.T, exporter *MemoryExporter) *OpenTelemetry {
+	o := &OpenTelemetry{opts: &plugin.Options{}}
+	o.SetExporter(exporter)
+	if err := o.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { OtelSvr.Stop(context.Background()) })
+	return o
+}
+
+func TestSpansArePropagated(t *testing.T) {
+	exporter := NewMemoryExporter()
+	o := newTestTracer(t, exporter)
+
+	if err := call(t, o, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// server span 先结束
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	server, client := spans[0], spans[1]
+
+	if client.Kind != "client" || server.Kind != "server" {
+		t.Fatalf("span kinds = %s, %s, want client, server", client.Kind, server.Kind)
+	}
+	if client.Name != "/helloworld.Greeter/SayHello" || server.Name != client.Name {
+		t.Fatalf("span names = %q, %q, want the service path", client.Name, server.Name)
+	}
+	if server.TraceID != client.TraceID || server.ParentSpanID != client.SpanID {
+		t.Fatalf("server span %s/%s is not a child of client span %s/%s", server.TraceID, server.ParentSpanID, client.TraceID, client.SpanID)
+	}
+	if server.Attributes["peer.address"] != "127.0.0.1:50000" || client.Attributes["peer.service"] != "helloworld.Greeter" {
+		t.Fatalf("peer attributes = %v, %v", server.Attributes["peer.address"], client.Attributes["peer.service"])
+	}
+	if server.StatusCode != "Unset" || server.Attributes["rpc.code"] != int64(0) {
+		t.Fatalf("server span status = %s, rpc.code = %v, want Unset and 0", server.StatusCode, server.Attributes["rpc.code"])
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	exporter := NewMemoryExporter()
+	o := newTestTracer(t, exporter)
+
+	if err := call(t, o, codes.New(7, "biz")); err == nil {
+		t.Fatal("call succeeded, want the business error")
+	}
+
+	for _, span := range exporter.Spans() {
+		if span.StatusCode != "Error" || span.Attributes["rpc.code"] != int64(7) || !strings.Contains(span.StatusMessage, "biz") {
+			t.Errorf("%s span status = %s %q, rpc.code = %v, want Error and 7", span.Kind, span.StatusCode, span.StatusMessage, span.Attributes["rpc.code"])
+		}
+	}
+}
+
+func TestMemoryExporterKeepsSpansAfterShutdown(t *testing.T) {
+	exporter := NewMemoryExporter()
+	o := newTestTracer(t, exporter)
+
+	if err := call(t, o, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := OtelSvr.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(exporter.Spans()); n != 2 {
+		t.Fatalf("got %d spans after Stop, want 2", n)
+	}
+
+	exporter.Reset()
+	if n := len(exporter.Spans()); n != 0 {
+		t.Fatalf("got %d spans after Reset, want 0", n)
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	tracer, err := Init("file://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, span := tracer.Start(context.Background(), "filespan")
+	span.End()
+
+	// Stop 导出还在缓冲中的 span 并关闭文件
+	if err := OtelSvr.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(data), "\n") != 1 || !strings.Contains(string(data), `"name":"filespan"`) {
+		t.Fatalf("file content = %s, want one filespan line", data)
+	}
+}
@@ -1,6 +1,10 @@
 package plugin
 
-import "github.com/opentracing/opentracing-go"
+import (
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
 
 //这里由于服务发现插件和 tracing 的插件都有其个性化配置，导致其初始化方法的结构不一样，所以细化了
 //一下 Plugin 接口，分为 ResolverPlugin 和 TracingPlugin 两类
@@ -10,27 +14,55 @@ type ResolverPlugin interface {
 	Init(...Option) error
 }
 
+// Deregisterer 是服务发现插件可选实现的接口，Deregister 将 Init 时注册的服务从服务发现中移除，server 优雅退出时调用
+type Deregisterer interface {
+	Deregister(...Option) error
+}
+
 type TracingPlugin interface {
 	Init(...Option) (opentracing.Tracer, error)
 }
 
 var PluginMap = make(map[string]Plugin)
 
+// pluginNames 是插件的注册顺序
+var pluginNames []string
+
 // 开放注册入口给插件调用进行注册
 func Register(name string, plugin Plugin) {
 	if PluginMap == nil {
 		PluginMap = make(map[string]Plugin)
 	}
+	if _, ok := PluginMap[name]; !ok {
+		pluginNames = append(pluginNames, name)
+	}
 	PluginMap[name] = plugin
 }
 
+// Names 按注册顺序返回所有插件的名字
+func Names() []string {
+	return append([]string(nil), pluginNames...)
+}
+
 type Options struct {
 	SvrAddr         string   // server address
 	Services        []string // service arrays
 	SelectorSvrAddr string   // server discovery address ，e.g. consul server address
 	TracingSvrAddr  string   // tracing server address，e.g. jaeger server address
+	BalancerName    string   // load balancing mode of the selector, e.g. random、roundRobin、consistentHash
+
+	Tags                []string      // tags of the registered service instances
+	Weight              int           // weight of the registered service instances, 0 means the default weight
+	HealthCheck         string        // health check of the registered service instances, e.g. tcp、ttl, default: tcp
+	HealthCheckInterval time.Duration // interval of the tcp check, or TTL of the ttl check
+	AdvertiseAddr       string        // address registered to the registry, e.g. 10.0.0.1 or 10.0.0.1:8000, default: the host of SvrAddr
 }
 
+const (
+	HealthCheckTCP = "tcp" // the registry dials the server address periodically
+	HealthCheckTTL = "ttl" // the server reports to the registry periodically
+)
+
 // Option provides operations on Options
 type Option func(*Options)
 
@@ -61,3 +93,41 @@ func WithTracingSvrAddr(addr string) Option {
 		o.TracingSvrAddr = addr
 	}
 }
+
+// WithBalancerName allows you to set BalancerName of Options
+func WithBalancerName(name string) Option {
+	return func(o *Options) {
+		o.BalancerName = name
+	}
+}
+
+// WithTags 设置注册的服务实例的标签
+func WithTags(tags ...string) Option {
+	return func(o *Options) {
+		o.Tags = tags
+	}
+}
+
+// WithWeight 设置注册的服务实例的权重，client 使用加权负载均衡时按权重分配请求
+func WithWeight(weight int) Option {
+	return func(o *Options) {
+		o.Weight = weight
+	}
+}
+
+// WithAdvertiseAddr 设置注册到注册中心的地址，可以只有 host，端口使用 server 监听的端口。
+// server 监听 0.0.0.0、:: 或者没有指定 host 时，没有设置这个地址会使用本机第一个可以路由的网卡地址
+func WithAdvertiseAddr(addr string) Option {
+	return func(o *Options) {
+		o.AdvertiseAddr = addr
+	}
+}
+
+// WithHealthCheck 设置注册的服务实例的健康检查，kind 为 HealthCheckTCP 时注册中心每隔 interval 连接一次 server 地址，
+// 为 HealthCheckTTL 时 server 每隔 interval/2 向注册中心上报一次，超过 interval 没有上报的实例被认为不健康
+func WithHealthCheck(kind string, interval time.Duration) Option {
+	return func(o *Options) {
+		o.HealthCheck = kind
+		o.HealthCheckInterval = interval
+	}
+}
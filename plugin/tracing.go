@@ -0,0 +1,12 @@
+package plugin
+
+import "github.com/junaozun/go-lrpxc/interceptor"
+
+// Tracer 是和具体实现无关的链路追踪插件。TracingPlugin 的 Init 返回 opentracing.Tracer，只能配合框架内置的
+// opentracing 拦截器使用；Tracer 由插件自己提供 server 和 client 的拦截器，span 的创建、透传和上报都由插件完成，
+// 比如 OpenTelemetry 插件。spanName 为空时插件使用服务路径作为 span 名
+type Tracer interface {
+	Init(...Option) error
+	ServerInterceptor(spanName string) interceptor.ServerInterceptor
+	ClientInterceptor(spanName string) interceptor.ClientInterceptor
+}
@@ -2,11 +2,15 @@ package connpool
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"io"
 	"net"
 	"sync"
 	"time"
+
+	"github.com/junaozun/go-lrpxc/codec"
+	"github.com/junaozun/go-lrpxc/metrics"
 )
 
 /*
@@ -22,13 +26,14 @@ type Pool interface {
 }
 
 var poolMap = make(map[string]Pool)
-var oneByte = make([]byte, 1)
 
 func init() {
-	registorPool("default", DefaultPool)
+	RegisterPool("default", DefaultPool)
 }
 
-func registorPool(poolName string, pool Pool) {
+// RegisterPool registers a Pool, registering "default" replaces the pool used by the client transport,
+// e.g. RegisterPool("default", NewConnPool(WithHeartbeatInterval(10 * time.Second)))
+func RegisterPool(poolName string, pool Pool) {
 	poolMap[poolName] = pool
 }
 
@@ -52,9 +57,12 @@ var DefaultPool = NewConnPool()
 func NewConnPool(opt ...Option) *poolManager {
 	// default options
 	opts := &Options{
-		maxCap:      1000,
-		idleTimeout: 1 * time.Minute,
-		dialTimeout: 200 * time.Millisecond,
+		maxCap:              1000,
+		idleTimeout:         1 * time.Minute,
+		dialTimeout:         200 * time.Millisecond,
+		heartbeatInterval:   3 * time.Second,
+		heartbeatTimeout:    1 * time.Second,
+		maxMissedHeartbeats: 3,
 	}
 	m := &sync.Map{}
 
@@ -90,6 +98,7 @@ func (p *poolManager) Get(ctx context.Context, network string, address string) (
 
 func (p *poolManager) NewSonConnPool(ctx context.Context, network string, address string) (*sonConnPool, error) {
 	c := &sonConnPool{
+		address:    address,
 		initialCap: p.opts.initialCap,
 		maxCap:     p.opts.maxCap,
 		Dial: func(ctx context.Context) (net.Conn, error) {
@@ -106,17 +115,20 @@ func (p *poolManager) NewSonConnPool(ctx context.Context, network string, addres
 
 			return net.DialTimeout(network, address, timeout)
 		},
-		conns:       make(chan *PoolConn, p.opts.maxCap),
-		idleTimeout: p.opts.idleTimeout,
-		dialTimeout: p.opts.dialTimeout,
+		conns:               make(chan *PoolConn, p.opts.maxCap),
+		idleTimeout:         p.opts.idleTimeout,
+		dialTimeout:         p.opts.dialTimeout,
+		heartbeatTimeout:    p.opts.heartbeatTimeout,
+		maxMissedHeartbeats: p.opts.maxMissedHeartbeats,
 	}
 
-	if p.opts.initialCap == 0 {
-		// default initialCap is 1
-		p.opts.initialCap = 1
+	// default initialCap is 1, p.opts is shared by concurrent calls and is not written here
+	initialCap := p.opts.initialCap
+	if initialCap == 0 {
+		initialCap = 1
 	}
 
-	for i := 0; i < p.opts.initialCap; i++ {
+	for i := 0; i < initialCap; i++ {
 		conn, err := c.Dial(ctx)
 		if err != nil {
 			return nil, err
@@ -124,21 +136,24 @@ func (p *poolManager) NewSonConnPool(ctx context.Context, network string, addres
 		c.Put(c.wrapConn(conn))
 	}
 
-	c.RegisterChecker(3*time.Second, c.Checker)
+	c.RegisterChecker(p.opts.heartbeatInterval, c.Checker)
 	return c, nil
 }
 
 // 子链接池
 type sonConnPool struct {
-	net.Conn                  // 这个有用吗？
-	initialCap  int           // initial capacity 连接池中链接的数量
-	maxCap      int           // max capacity
-	maxIdle     int           // max idle conn number
-	idleTimeout time.Duration // idle timeout
-	dialTimeout time.Duration // dial timeout
-	Dial        func(context.Context) (net.Conn, error)
-	conns       chan *PoolConn
-	mu          sync.RWMutex
+	net.Conn                          // 这个有用吗？
+	address             string        // address of the conns
+	initialCap          int           // initial capacity 连接池中链接的数量
+	maxCap              int           // max capacity
+	maxIdle             int           // max idle conn number
+	idleTimeout         time.Duration // idle timeout
+	dialTimeout         time.Duration // dial timeout
+	heartbeatTimeout    time.Duration // heartbeat response timeout
+	maxMissedHeartbeats int           // close the conn after missing maxMissedHeartbeats heartbeats
+	Dial                func(context.Context) (net.Conn, error)
+	conns               chan *PoolConn
+	mu                  sync.RWMutex
 }
 
 func (c *sonConnPool) Get(ctx context.Context) (net.Conn, error) {
@@ -150,6 +165,7 @@ func (c *sonConnPool) Get(ctx context.Context) (net.Conn, error) {
 		if pc == nil {
 			return nil, ErrConnClosed
 		}
+		c.reportIdle(c.conns)
 
 		if pc.unusable {
 			return nil, ErrConnClosed
@@ -180,6 +196,7 @@ func (c *sonConnPool) Close() {
 		conn.MarkUnusable()
 		conn.Close()
 	}
+	c.reportIdle(nil)
 }
 
 func (c *sonConnPool) Put(conn *PoolConn) error {
@@ -195,6 +212,7 @@ func (c *sonConnPool) Put(conn *PoolConn) error {
 
 	select {
 	case c.conns <- conn:
+		c.reportIdle(c.conns)
 		return nil
 	default:
 		// 连接池满
@@ -202,6 +220,11 @@ func (c *sonConnPool) Put(conn *PoolConn) error {
 	}
 }
 
+// reportIdle 上报连接池中的空闲连接数
+func (c *sonConnPool) reportIdle(conns chan *PoolConn) {
+	metrics.GetSink("default").SetGauge(metrics.PoolIdleConnections, metrics.Labels{"address": c.address}, float64(len(conns)))
+}
+
 func (c *sonConnPool) RegisterChecker(internal time.Duration, checker func(conn *PoolConn) bool) {
 
 	if internal <= 0 || checker == nil {
@@ -246,20 +269,47 @@ func (c *sonConnPool) Checker(pc *PoolConn) bool {
 	}
 
 	// check conn is alive or not
-	if !isConnAlive(pc.Conn) {
+	missed, err := c.heartbeat(pc.Conn)
+	if err != nil {
 		return false
 	}
+	if !missed {
+		pc.missedHeartbeats = 0
+		return true
+	}
 
-	return true
+	pc.missedHeartbeats++
+	return pc.missedHeartbeats < c.maxMissedHeartbeats
 }
 
-func isConnAlive(conn net.Conn) bool {
-	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+// heartbeat 在空闲连接上发送一个心跳帧并等待 server 的心跳回包。在 heartbeatTimeout 内没有收到回包时返回 missed，
+// 迟到的心跳回包会在下一次心跳或者下一次请求读回包时被跳过。连接已经断开或者帧不完整时返回 error，连接不能再使用
+func (c *sonConnPool) heartbeat(conn net.Conn) (missed bool, err error) {
+	defer conn.SetDeadline(time.Time{})
+	conn.SetDeadline(time.Now().Add(c.heartbeatTimeout))
 
-	if n, err := conn.Read(oneByte); n > 0 || err == io.EOF {
-		return false
+	if _, err = conn.Write(codec.NewHeartbeatFrame(0)); err != nil {
+		return false, err
 	}
 
-	conn.SetReadDeadline(time.Time{})
-	return true
+	header := make([]byte, codec.FrameHeadLen)
+	for {
+		n, err := io.ReadFull(conn, header)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && n == 0 {
+				return true, nil
+			}
+			return false, err
+		}
+
+		// skip the body of the frame, a pooled idle conn only receives heartbeat responses
+		length := binary.BigEndian.Uint32(header[7:11])
+		if _, err = io.CopyN(io.Discard, conn, int64(length)); err != nil {
+			return false, err
+		}
+
+		if header[2] == codec.MsgTypeHeartbeat {
+			return false, nil
+		}
+	}
 }
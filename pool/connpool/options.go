@@ -8,6 +8,10 @@ type Options struct {
 	idleTimeout time.Duration
 	maxIdle     int           // max idle connections
 	dialTimeout time.Duration // dial timeout
+
+	heartbeatInterval   time.Duration // interval of sending heartbeats on idle conns
+	heartbeatTimeout    time.Duration // heartbeat response timeout
+	maxMissedHeartbeats int           // close the conn after missing maxMissedHeartbeats heartbeats
 }
 
 type Option func(*Options)
@@ -41,3 +45,21 @@ func WithDialTimeout(dialTimeout time.Duration) Option {
 		o.dialTimeout = dialTimeout
 	}
 }
+
+func WithHeartbeatInterval(heartbeatInterval time.Duration) Option {
+	return func(o *Options) {
+		o.heartbeatInterval = heartbeatInterval
+	}
+}
+
+func WithHeartbeatTimeout(heartbeatTimeout time.Duration) Option {
+	return func(o *Options) {
+		o.heartbeatTimeout = heartbeatTimeout
+	}
+}
+
+func WithMaxMissedHeartbeats(maxMissedHeartbeats int) Option {
+	return func(o *Options) {
+		o.maxMissedHeartbeats = maxMissedHeartbeats
+	}
+}
@@ -1,14 +1,15 @@
 package connpool
 
 import (
-	"errors"
 	"net"
 	"sync"
 	"time"
+
+	"github.com/junaozun/go-lrpxc/codes"
 )
 
 var (
-	ErrConnClosed = errors.New("connection closed ...")
+	ErrConnClosed = codes.NewFrameworkError(codes.NetworkErrorCode, "connection closed ...")
 )
 
 type PoolConn struct {
@@ -18,6 +19,8 @@ type PoolConn struct {
 	mu          sync.RWMutex
 	t           time.Time     // connection idle time
 	dialTimeout time.Duration // connection timeout duration
+
+	missedHeartbeats int // number of consecutive missed heartbeats
 }
 
 // overwrite conn Close for connection reuse
@@ -34,6 +37,9 @@ func (p *PoolConn) Close() error {
 	// reset connection deadline
 	p.Conn.SetDeadline(time.Time{})
 
+	// the connection becomes idle from now on
+	p.t = time.Now()
+
 	return p.c.Put(p)
 }
 
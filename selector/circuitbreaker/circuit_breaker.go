@@ -0,0 +1,289 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/codes"
+)
+
+/*
+熔断器：按节点地址统计调用结果，节点连续失败 ConsecutiveFailures 次，或者统计窗口内失败率达到 FailureRatio 时熔断（open），
+熔断期间发往这个节点的请求直接失败，Selector 也会跳过这个节点。经过 CoolDown 后进入半开状态（half-open），
+放行 HalfOpenRequests 个探测请求，探测请求都成功后恢复（closed），有一个失败则重新熔断。
+只有网络错误和读写超时算作失败，业务错误说明节点可以正常处理请求，算作成功。
+调用方的 ctx 被取消或者超时（比如对冲请求中较慢的请求被取消）不能说明节点的状态，不计入统计。
+*/
+
+// State 是节点的熔断状态
+type State int
+
+const (
+	StateClosed State = iota
+	StateOpen
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Options 熔断器的参数
+type Options struct {
+	ConsecutiveFailures int           // open after ConsecutiveFailures consecutive failures, 0 means disabled
+	FailureRatio        float64       // open when the failure ratio in the window reaches FailureRatio, 0 means disabled
+	MinRequests         int           // the failure ratio is only checked after MinRequests requests in the window
+	Window              time.Duration // the statistics of the failure ratio are reset every Window
+	CoolDown            time.Duration // how long a node stays open before half-open
+	HalfOpenRequests    int           // number of probe requests in half-open state
+	// OnStateChange 节点熔断状态变化时的回调，可以用来打日志或者上报监控，回调中不能再调用熔断器
+	OnStateChange func(addr string, from, to State)
+}
+
+type Option func(*Options)
+
+// WithConsecutiveFailures 设置连续失败多少次后熔断
+func WithConsecutiveFailures(n int) Option {
+	return func(o *Options) {
+		o.ConsecutiveFailures = n
+	}
+}
+
+// WithFailureRatio 设置统计窗口内的请求数达到 minRequests 后，失败率达到 ratio 时熔断
+func WithFailureRatio(ratio float64, minRequests int, window time.Duration) Option {
+	return func(o *Options) {
+		o.FailureRatio = ratio
+		o.MinRequests = minRequests
+		o.Window = window
+	}
+}
+
+// WithCoolDown 设置熔断后多久进入半开状态
+func WithCoolDown(coolDown time.Duration) Option {
+	return func(o *Options) {
+		o.CoolDown = coolDown
+	}
+}
+
+// WithHalfOpenRequests 设置半开状态下放行的探测请求数
+func WithHalfOpenRequests(n int) Option {
+	return func(o *Options) {
+		o.HalfOpenRequests = n
+	}
+}
+
+// WithOnStateChange 设置熔断状态变化的回调
+func WithOnStateChange(f func(addr string, from, to State)) Option {
+	return func(o *Options) {
+		o.OnStateChange = f
+	}
+}
+
+// Breaker 是按节点地址熔断的熔断器，可以被多个 client 共享
+type Breaker struct {
+	opts *Options
+
+	mu    sync.Mutex
+	nodes map[string]*node // address -> node
+}
+
+// node 是一个节点的熔断状态和统计数据
+type node struct {
+	state       State
+	openedAt    time.Time // when the node became open
+	windowStart time.Time
+	requests    int // requests in the window
+	failures    int // failures in the window
+	consecutive int // consecutive failures
+	probes      int // probe requests in flight in half-open state
+	successes   int // successful probe requests in half-open state
+}
+
+// New 创建一个熔断器，默认连续失败 5 次，或者 10s 内至少 20 个请求且失败率达到 50% 时熔断，熔断 5s 后放行 1 个探测请求
+func New(opts ...Option) *Breaker {
+	o := &Options{
+		ConsecutiveFailures: 5,
+		FailureRatio:        0.5,
+		MinRequests:         20,
+		Window:              10 * time.Second,
+		CoolDown:            5 * time.Second,
+		HalfOpenRequests:    1,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.HalfOpenRequests <= 0 {
+		o.HalfOpenRequests = 1
+	}
+
+	return &Breaker{
+		opts:  o,
+		nodes: make(map[string]*node),
+	}
+}
+
+// Allow 判断节点当前是否可以被选中，Selector 用它跳过熔断中的节点。Allow 不会占用半开状态的探测名额
+func (b *Breaker) Allow(addr string) bool {
+	b.mu.Lock()
+	n, ok := b.nodes[addr]
+	if !ok {
+		b.mu.Unlock()
+		return true
+	}
+	from, to := b.refresh(n)
+	allow := n.state == StateClosed || (n.state == StateHalfOpen && n.probes < b.opts.HalfOpenRequests)
+	b.mu.Unlock()
+
+	b.notify(addr, from, to)
+	return allow
+}
+
+// State 返回节点当前的熔断状态
+func (b *Breaker) State(addr string) State {
+	b.mu.Lock()
+	n, ok := b.nodes[addr]
+	if !ok {
+		b.mu.Unlock()
+		return StateClosed
+	}
+	from, to := b.refresh(n)
+	state := n.state
+	b.mu.Unlock()
+
+	b.notify(addr, from, to)
+	return state
+}
+
+// Acquire 在向节点发送请求前调用，节点熔断中或者半开状态的探测名额用完时返回 codes.CircuitBreakerOpenError。
+// 请求结束后需要调用返回的 done 记录请求的结果
+func (b *Breaker) Acquire(addr string) (done func(err error), err error) {
+	b.mu.Lock()
+	n, ok := b.nodes[addr]
+	if !ok {
+		n = &node{windowStart: time.Now()}
+		b.nodes[addr] = n
+	}
+	from, to := b.refresh(n)
+
+	probe := false
+	switch n.state {
+	case StateOpen:
+		err = codes.CircuitBreakerOpenError
+	case StateHalfOpen:
+		if n.probes >= b.opts.HalfOpenRequests {
+			err = codes.CircuitBreakerOpenError
+		} else {
+			n.probes++
+			probe = true
+		}
+	}
+	b.mu.Unlock()
+
+	b.notify(addr, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(err error) {
+		b.report(addr, probe, err)
+	}, nil
+}
+
+// report 记录一次请求的结果
+func (b *Breaker) report(addr string, probe bool, err error) {
+	b.mu.Lock()
+	n := b.nodes[addr]
+	from := n.state
+	if probe {
+		n.probes--
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		// 调用方的 ctx 结束时 transport 返回 ctx 的错误，节点自身的读写超时是 net.Error，仍然算作失败
+
+	case codes.IsNodeError(err):
+		if probe || n.state == StateHalfOpen {
+			b.open(n)
+			break
+		}
+		n.requests++
+		n.failures++
+		n.consecutive++
+		if b.shouldOpen(n) {
+			b.open(n)
+		}
+
+	default:
+		if probe {
+			n.successes++
+			if n.successes >= b.opts.HalfOpenRequests {
+				b.close(n)
+			}
+			break
+		}
+		n.requests++
+		n.consecutive = 0
+	}
+	to := n.state
+	b.mu.Unlock()
+
+	b.notify(addr, from, to)
+}
+
+// refresh 处理时间带来的状态变化：统计窗口过期时重置统计数据，熔断超过 CoolDown 后进入半开状态
+func (b *Breaker) refresh(n *node) (from, to State) {
+	from = n.state
+	now := time.Now()
+
+	if n.state == StateClosed && b.opts.Window > 0 && now.Sub(n.windowStart) >= b.opts.Window {
+		n.windowStart = now
+		n.requests = 0
+		n.failures = 0
+	}
+
+	if n.state == StateOpen && now.Sub(n.openedAt) >= b.opts.CoolDown {
+		n.state = StateHalfOpen
+		n.probes = 0
+		n.successes = 0
+	}
+
+	return from, n.state
+}
+
+func (b *Breaker) shouldOpen(n *node) bool {
+	if b.opts.ConsecutiveFailures > 0 && n.consecutive >= b.opts.ConsecutiveFailures {
+		return true
+	}
+	return b.opts.FailureRatio > 0 && n.requests >= b.opts.MinRequests &&
+		float64(n.failures)/float64(n.requests) >= b.opts.FailureRatio
+}
+
+func (b *Breaker) open(n *node) {
+	n.state = StateOpen
+	n.openedAt = time.Now()
+}
+
+func (b *Breaker) close(n *node) {
+	n.state = StateClosed
+	n.windowStart = time.Now()
+	n.requests = 0
+	n.failures = 0
+	n.consecutive = 0
+}
+
+func (b *Breaker) notify(addr string, from, to State) {
+	if from != to && b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(addr, from, to)
+	}
+}
@@ -0,0 +1,197 @@
+package circuitbreaker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/codes"
+)
+
+const addr = "127.0.0.1:8000"
+
+// timeoutError 是节点读写超时的 net.Error
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var readTimeout = &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}
+
+// transitions 记录 OnStateChange 回调收到的状态变化
+type transitions struct {
+	mu  sync.Mutex
+	got []State
+}
+
+func (tr *transitions) record(_ string, from, to State) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.got = append(tr.got, to)
+}
+
+func (tr *transitions) states() []State {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return append([]State(nil), tr.got...)
+}
+
+func call(t *testing.T, b *Breaker, err error) {
+	t.Helper()
+	done, e := b.Acquire(addr)
+	if e != nil {
+		t.Fatalf("Acquire got %v, want the request to be allowed", e)
+	}
+	done(err)
+}
+
+func TestBreakerStateMachine(t *testing.T) {
+	tr := &transitions{}
+	b := New(WithConsecutiveFailures(3), WithFailureRatio(0, 0, 0), WithCoolDown(50*time.Millisecond),
+		WithHalfOpenRequests(2), WithOnStateChange(tr.record))
+
+	// closed: 成功和业务错误会重置连续失败次数，调用方取消的请求不计入统计
+	call(t, b, codes.NetworkError)
+	call(t, b, codes.NetworkError)
+	call(t, b, nil)
+	call(t, b, codes.NetworkError)
+	call(t, b, codes.NetworkError)
+	call(t, b, codes.New(1000, "biz"))
+	call(t, b, codes.NetworkError)
+	call(t, b, context.Canceled)
+	call(t, b, codes.NetworkError)
+	if s := b.State(addr); s != StateClosed {
+		t.Fatalf("state = %v, want closed", s)
+	}
+
+	// closed -> open
+	call(t, b, codes.NetworkError)
+	if s := b.State(addr); s != StateOpen {
+		t.Fatalf("state = %v after 3 consecutive failures, want open", s)
+	}
+	if b.Allow(addr) {
+		t.Fatal("Allow = true for an open node")
+	}
+	if _, err := b.Acquire(addr); err != codes.CircuitBreakerOpenError {
+		t.Fatalf("Acquire got %v, want %v", err, codes.CircuitBreakerOpenError)
+	}
+
+	// open -> half-open
+	time.Sleep(60 * time.Millisecond)
+	if s := b.State(addr); s != StateHalfOpen {
+		t.Fatalf("state = %v after the cool down, want half-open", s)
+	}
+
+	// 半开状态只放行 HalfOpenRequests 个探测请求
+	done1, err := b.Acquire(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done2, err := b.Acquire(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Allow(addr) {
+		t.Fatal("Allow = true with all probes in flight")
+	}
+	if _, err := b.Acquire(addr); err != codes.CircuitBreakerOpenError {
+		t.Fatalf("Acquire got %v with all probes in flight, want %v", err, codes.CircuitBreakerOpenError)
+	}
+
+	// half-open -> closed
+	done1(nil)
+	if s := b.State(addr); s != StateHalfOpen {
+		t.Fatalf("state = %v after 1 of 2 probes succeeded, want half-open", s)
+	}
+	done2(nil)
+	if s := b.State(addr); s != StateClosed {
+		t.Fatalf("state = %v after all probes succeeded, want closed", s)
+	}
+
+	want := []State{StateOpen, StateHalfOpen, StateClosed}
+	if got := tr.states(); !equal(got, want) {
+		t.Fatalf("transitions = %v, want %v", got, want)
+	}
+}
+
+func TestBreakerProbeFailureReopens(t *testing.T) {
+	b := New(WithConsecutiveFailures(1), WithFailureRatio(0, 0, 0), WithCoolDown(20*time.Millisecond))
+
+	call(t, b, codes.NetworkError)
+	time.Sleep(30 * time.Millisecond)
+	if s := b.State(addr); s != StateHalfOpen {
+		t.Fatalf("state = %v, want half-open", s)
+	}
+
+	// half-open -> open，重新计算 CoolDown
+	call(t, b, readTimeout)
+	if s := b.State(addr); s != StateOpen {
+		t.Fatalf("state = %v after a failed probe, want open", s)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if s := b.State(addr); s != StateHalfOpen {
+		t.Fatalf("state = %v, want half-open again", s)
+	}
+}
+
+func TestBreakerFailureRatio(t *testing.T) {
+	b := New(WithConsecutiveFailures(0), WithFailureRatio(0.5, 4, time.Minute), WithCoolDown(time.Minute))
+
+	// 请求数没有达到 MinRequests 时不检查失败率
+	call(t, b, codes.NetworkError)
+	call(t, b, nil)
+	call(t, b, codes.NetworkError)
+	if s := b.State(addr); s != StateClosed {
+		t.Fatalf("state = %v before MinRequests, want closed", s)
+	}
+
+	call(t, b, codes.NetworkError)
+	if s := b.State(addr); s != StateOpen {
+		t.Fatalf("state = %v with 3 of 4 requests failed, want open", s)
+	}
+}
+
+func TestBreakerIgnoresCallerAbort(t *testing.T) {
+	b := New(WithConsecutiveFailures(1), WithFailureRatio(0, 0, 0), WithCoolDown(20*time.Millisecond), WithHalfOpenRequests(1))
+
+	// 调用方的 ctx 被取消或者超时不计入统计
+	call(t, b, context.Canceled)
+	call(t, b, context.DeadlineExceeded)
+	call(t, b, fmt.Errorf("call: %w", context.DeadlineExceeded))
+	if s := b.State(addr); s != StateClosed {
+		t.Fatalf("state = %v after caller aborts, want closed", s)
+	}
+
+	// 节点的读写超时算作失败
+	call(t, b, readTimeout)
+	if s := b.State(addr); s != StateOpen {
+		t.Fatalf("state = %v after a read timeout, want open", s)
+	}
+
+	// 被中断的探测请求释放探测名额，节点仍然是半开状态
+	time.Sleep(30 * time.Millisecond)
+	call(t, b, context.DeadlineExceeded)
+	if s := b.State(addr); s != StateHalfOpen {
+		t.Fatalf("state = %v after an aborted probe, want half-open", s)
+	}
+	call(t, b, nil)
+	if s := b.State(addr); s != StateClosed {
+		t.Fatalf("state = %v after the next probe succeeded, want closed", s)
+	}
+}
+
+func equal(a, b []State) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
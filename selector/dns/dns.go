@@ -0,0 +1,337 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/selector"
+	"github.com/junaozun/go-lrpxc/selector/loadbalance"
+)
+
+/*
+DNS Selector：通过 DNS 解析服务节点，target 的格式是 dns://host:port 或者 dns://name。
+带端口时查询 host 的 A/AAAA 记录，每个 IP 加上端口是一个节点；不带端口时查询 name 的 SRV 记录，
+比如 dns://_lrpc._tcp.example.com，只使用优先级（priority）最高（数值最小）的一组记录，记录的 weight 作为节点的权重。
+解析结果在后台按记录的 TTL 刷新，RefreshInterval 是刷新间隔的上限，刷新失败时继续使用上一次成功的结果。
+标准库的解析器拿不到记录的 TTL，Resolver 没有实现 TTLResolver 时每隔 RefreshInterval 刷新一次。
+*/
+
+// Scheme 是 DNS 地址的前缀
+const Scheme = "dns"
+
+const (
+	defaultRefreshInterval = 30 * time.Second
+	defaultTimeout         = 5 * time.Second
+	defaultWeight          = 1
+)
+
+// Resolver 是 DNS 解析器，*net.Resolver 实现了这个接口，测试时可以替换成本地的桩解析器
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// TTLResolver 是能查询记录 TTL 的 Resolver，DNS Selector 按 TTL 刷新解析结果
+type TTLResolver interface {
+	Resolver
+	// LookupTTL 返回 name 的记录（A/AAAA 或者 SRV）中最小的 TTL，TTL 未知时返回 0
+	LookupTTL(ctx context.Context, name string) (time.Duration, error)
+}
+
+// Options DNS Selector 的参数
+type Options struct {
+	BalancerName    string        // load balance mode, random, roundRobin, weightedRoundRobin, consistentHash, p2c, leastConn
+	RefreshInterval time.Duration // upper limit of the interval between resolutions, 0 means never
+	Timeout         time.Duration // timeout of a resolution
+	Resolver        Resolver      // DNS resolver, net.DefaultResolver by default
+}
+
+type Option func(*Options)
+
+func init() {
+	selector.RegisterBuilder(Scheme, build)
+}
+
+// build 根据 target 创建 DNS Selector，dns://8.8.8.8/svc.local:8000 中的 8.8.8.8 是 DNS 服务器的地址，
+// dns:///svc.local:8000 和 dns://svc.local:8000 使用系统的 DNS 服务器
+func build(target *selector.Target) (selector.Selector, error) {
+	var opts []Option
+	if balancerName := target.Query.Get("balancer"); balancerName != "" {
+		opts = append(opts, WithBalancerName(balancerName))
+	}
+
+	name := target.Endpoint
+	if name == "" {
+		name = target.Authority
+	} else if target.Authority != "" {
+		ns := target.Authority
+		if _, _, err := net.SplitHostPort(ns); err != nil {
+			ns = net.JoinHostPort(ns, "53")
+		}
+		opts = append(opts, WithNameServer(ns))
+	}
+	return New(name, opts...)
+}
+
+// WithBalancerName 设置负载均衡方式，默认随机
+func WithBalancerName(balancerName string) Option {
+	return func(o *Options) {
+		o.BalancerName = balancerName
+	}
+}
+
+// WithRefreshInterval 设置重新解析的最大间隔，记录的 TTL 更短时按 TTL 刷新，0 表示不刷新
+func WithRefreshInterval(interval time.Duration) Option {
+	return func(o *Options) {
+		o.RefreshInterval = interval
+	}
+}
+
+// WithTimeout 设置一次解析的超时时间
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *Options) {
+		o.Timeout = timeout
+	}
+}
+
+// WithResolver 设置 DNS 解析器
+func WithResolver(r Resolver) Option {
+	return func(o *Options) {
+		o.Resolver = r
+	}
+}
+
+// WithNameServer 使用 addr（比如 127.0.0.1:53）上的 DNS 服务器解析，可以指向本地的桩 DNS 服务器
+func WithNameServer(addr string) Option {
+	return func(o *Options) {
+		o.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		}
+	}
+}
+
+// Selector 从 DNS 解析出的节点中选择节点
+type Selector struct {
+	opts *Options
+	host string // host of A/AAAA records or name of SRV records
+	port string // empty means SRV records
+
+	mu    sync.RWMutex
+	nodes []*selector.Node
+
+	closeOnce sync.Once
+	closing   chan struct{}
+}
+
+// New 解析 target 创建一个 DNS Selector，第一次解析失败时返回错误，默认每 30s 刷新一次
+func New(target string, opts ...Option) (*Selector, error) {
+	o := &Options{
+		BalancerName:    loadbalance.Random,
+		RefreshInterval: defaultRefreshInterval,
+		Timeout:         defaultTimeout,
+		Resolver:        net.DefaultResolver,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	host, port, err := parseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Selector{
+		opts:    o,
+		host:    host,
+		port:    port,
+		closing: make(chan struct{}),
+	}
+
+	nodes, ttl, err := s.resolve()
+	if err != nil {
+		return nil, err
+	}
+	s.nodes = nodes
+
+	if o.RefreshInterval > 0 {
+		go s.watch(s.refreshInterval(ttl))
+	}
+
+	return s, nil
+}
+
+// parseTarget 解析 dns://host:port 或者 dns://name，也支持 dns:///host:port 的写法
+func parseTarget(target string) (host, port string, err error) {
+	addr := strings.TrimPrefix(target, Scheme+"://")
+	addr = strings.TrimPrefix(addr, "/")
+	if addr == "" {
+		return "", "", fmt.Errorf("invalid dns target %s", target)
+	}
+
+	if host, port, err = net.SplitHostPort(addr); err == nil {
+		if host == "" || port == "" {
+			return "", "", fmt.Errorf("invalid dns target %s", target)
+		}
+		return host, port, nil
+	}
+
+	// 没有端口，按 SRV 记录解析
+	return addr, "", nil
+}
+
+// Select 通过负载均衡从解析出的节点中选出一个节点
+func (s *Selector) Select(serviceName string, opts ...selector.Option) (string, error) {
+	return loadbalance.Pick(s.opts.BalancerName, serviceName, s.Nodes(), opts...)
+}
+
+// Report 将调用结果反馈给 Balancer
+func (s *Selector) Report(serviceName string, info selector.DoneInfo) {
+	loadbalance.Report(s.opts.BalancerName, serviceName, info)
+}
+
+// Nodes 返回最近一次解析成功的节点
+func (s *Selector) Nodes() []*selector.Node {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.nodes
+}
+
+// Close 停止后台刷新
+func (s *Selector) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+	return nil
+}
+
+// watch 按 TTL 重新解析，失败时保留上一次成功的结果，等待 RefreshInterval 后再次解析
+func (s *Selector) watch(interval time.Duration) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-timer.C:
+		}
+
+		nodes, ttl, err := s.resolve()
+		if err != nil {
+			fmt.Printf("dns selector resolve %s error, keep the old nodes, %v\n", s.host, err)
+			timer.Reset(s.opts.RefreshInterval)
+			continue
+		}
+
+		s.mu.Lock()
+		s.nodes = nodes
+		s.mu.Unlock()
+
+		timer.Reset(s.refreshInterval(ttl))
+	}
+}
+
+// refreshInterval 返回下一次解析前等待的时间，TTL 未知或者超过 RefreshInterval 时使用 RefreshInterval
+func (s *Selector) refreshInterval(ttl time.Duration) time.Duration {
+	if ttl > 0 && ttl < s.opts.RefreshInterval {
+		return ttl
+	}
+	return s.opts.RefreshInterval
+}
+
+// resolve 解析一次节点，同时返回记录的 TTL，没有解析出节点时返回错误
+func (s *Selector) resolve() ([]*selector.Node, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+	defer cancel()
+
+	var nodes []*selector.Node
+	var err error
+	if s.port != "" {
+		nodes, err = s.lookupHost(ctx)
+	} else {
+		nodes, err = s.lookupSRV(ctx)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(nodes) == 0 {
+		return nil, 0, errors.New("no records found for " + s.host)
+	}
+	return nodes, s.lookupTTL(ctx), nil
+}
+
+// lookupTTL 查询记录的 TTL，Resolver 不支持或者查询失败时返回 0
+func (s *Selector) lookupTTL(ctx context.Context) time.Duration {
+	r, ok := s.opts.Resolver.(TTLResolver)
+	if !ok {
+		return 0
+	}
+	ttl, err := r.LookupTTL(ctx, s.host)
+	if err != nil {
+		fmt.Printf("dns selector lookup ttl of %s error, %v\n", s.host, err)
+		return 0
+	}
+	return ttl
+}
+
+// lookupHost 查询 A/AAAA 记录
+func (s *Selector) lookupHost(ctx context.Context) ([]*selector.Node, error) {
+	ips, err := s.opts.Resolver.LookupHost(ctx, s.host)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]*selector.Node, 0, len(ips))
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip, s.port)
+		nodes = append(nodes, &selector.Node{
+			Key:     addr,
+			Weight:  defaultWeight,
+			Address: addr,
+		})
+	}
+	return nodes, nil
+}
+
+// lookupSRV 查询 SRV 记录，只保留优先级最高的一组，weight 为 0 的记录权重按 1 算
+func (s *Selector) lookupSRV(ctx context.Context) ([]*selector.Node, error) {
+	_, records, err := s.opts.Resolver.LookupSRV(ctx, "", "", s.host)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Priority < records[j].Priority
+	})
+
+	var nodes []*selector.Node
+	for _, r := range records {
+		if r.Priority != records[0].Priority {
+			break
+		}
+		weight := int(r.Weight)
+		if weight <= 0 {
+			weight = defaultWeight
+		}
+		addr := net.JoinHostPort(strings.TrimSuffix(r.Target, "."), fmt.Sprint(r.Port))
+		nodes = append(nodes, &selector.Node{
+			Key:     addr,
+			Weight:  weight,
+			Address: addr,
+		})
+	}
+	return nodes, nil
+}
@@ -0,0 +1,181 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubResolver 是本地的桩解析器，返回设置好的记录
+type stubResolver struct {
+	mu    sync.Mutex
+	hosts map[string][]string
+	srvs  map[string][]*net.SRV
+	err   error
+}
+
+func (r *stubResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.hosts[host], nil
+}
+
+func (r *stubResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	return name, r.srvs[name], nil
+}
+
+func (r *stubResolver) setHosts(host string, ips ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts[host] = ips
+}
+
+func (r *stubResolver) setErr(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+// ttlResolver 在 stubResolver 的基础上返回记录的 TTL
+type ttlResolver struct {
+	*stubResolver
+	ttl time.Duration
+}
+
+func (r *ttlResolver) LookupTTL(ctx context.Context, name string) (time.Duration, error) {
+	return r.ttl, nil
+}
+
+func newStubResolver() *stubResolver {
+	return &stubResolver{
+		hosts: map[string][]string{"svc.local": {"10.0.0.1", "10.0.0.2"}},
+		srvs: map[string][]*net.SRV{"_lrpc._tcp.svc.local": {
+			{Target: "b.svc.local.", Port: 8001, Priority: 10, Weight: 0},
+			{Target: "a.svc.local.", Port: 8000, Priority: 10, Weight: 3},
+			{Target: "backup.svc.local.", Port: 8000, Priority: 20, Weight: 1},
+		}},
+	}
+}
+
+func addrs(s *Selector) []string {
+	var list []string
+	for _, n := range s.Nodes() {
+		list = append(list, n.Addr())
+	}
+	sort.Strings(list)
+	return list
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// waitNodes 等待解析出的节点变成 want
+func waitNodes(t *testing.T, s *Selector, want []string, timeout time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !equal(addrs(s), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("nodes = %v, want %v within %v", addrs(s), want, timeout)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLookupHost(t *testing.T) {
+	s, err := New("dns://svc.local:8000", WithResolver(newStubResolver()), WithRefreshInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"10.0.0.1:8000", "10.0.0.2:8000"}
+	if got := addrs(s); !equal(got, want) {
+		t.Fatalf("nodes = %v, want %v", got, want)
+	}
+}
+
+func TestLookupSRV(t *testing.T) {
+	s, err := New("dns:///_lrpc._tcp.svc.local", WithResolver(newStubResolver()), WithRefreshInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 只使用优先级最高的一组记录，weight 为 0 的记录权重按 1 算
+	weights := make(map[string]int)
+	for _, n := range s.Nodes() {
+		weights[n.Addr()] = n.Weight
+	}
+	if len(weights) != 2 || weights["a.svc.local:8000"] != 3 || weights["b.svc.local:8001"] != 1 {
+		t.Fatalf("nodes = %v, want a.svc.local:8000 weight 3 and b.svc.local:8001 weight 1", weights)
+	}
+}
+
+func TestNewFailsWithoutRecords(t *testing.T) {
+	if _, err := New("dns://unknown.local:8000", WithResolver(newStubResolver())); err == nil {
+		t.Fatal("New succeeded without records")
+	}
+}
+
+func TestRefreshByTTL(t *testing.T) {
+	r := &ttlResolver{stubResolver: newStubResolver(), ttl: 20 * time.Millisecond}
+	s, err := New("dns://svc.local:8000", WithResolver(r), WithRefreshInterval(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// TTL 比 RefreshInterval 短，按 TTL 刷新
+	r.setHosts("svc.local", "10.0.0.3")
+	waitNodes(t, s, []string{"10.0.0.3:8000"}, time.Second)
+}
+
+func TestRefreshIntervalCapsTTL(t *testing.T) {
+	r := &ttlResolver{stubResolver: newStubResolver(), ttl: time.Hour}
+	s, err := New("dns://svc.local:8000", WithResolver(r), WithRefreshInterval(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	r.setHosts("svc.local", "10.0.0.3")
+	waitNodes(t, s, []string{"10.0.0.3:8000"}, time.Second)
+}
+
+func TestRefreshKeepsNodesOnError(t *testing.T) {
+	r := newStubResolver()
+	s, err := New("dns://svc.local:8000", WithResolver(r), WithRefreshInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	r.setErr(errors.New("server misbehaving"))
+	time.Sleep(50 * time.Millisecond)
+	if got, want := addrs(s), []string{"10.0.0.1:8000", "10.0.0.2:8000"}; !equal(got, want) {
+		t.Fatalf("nodes after failed refreshes = %v, want the old %v", got, want)
+	}
+
+	r.setErr(nil)
+	r.setHosts("svc.local", "10.0.0.3")
+	waitNodes(t, s, []string{"10.0.0.3:8000"}, time.Second)
+}
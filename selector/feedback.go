@@ -0,0 +1,64 @@
+package selector
+
+import (
+	"strings"
+	"time"
+)
+
+// DoneInfo 是一次调用的结果，client transport 在调用结束后反馈给选择层
+type DoneInfo struct {
+	Addr    string        // address of the node
+	Err     error         // error of the call, nil means success
+	Latency time.Duration // time from sending the request to receiving the response
+}
+
+// Reporter 接收调用结果的反馈。Selector 实现了 Reporter 时，client transport 会把每次调用的结果反馈给它，
+// Selector 可以再转给实现了 Reporter 的 Balancer，用于被动健康检查、按延迟选择节点等
+type Reporter interface {
+	Report(serviceName string, info DoneInfo)
+}
+
+// NodeFilter 在负载均衡之前过滤服务节点，比如剔除异常的节点
+type NodeFilter interface {
+	Filter(serviceName string, nodes []*Node) []*Node
+}
+
+// WithFilters 设置负载均衡之前过滤服务节点的 NodeFilter
+func WithFilters(filters ...NodeFilter) Option {
+	return func(o *Options) {
+		o.Filters = append(o.Filters, filters...)
+	}
+}
+
+// FilterNodes 去掉被 NodeFilter 过滤掉、被排除和被熔断的节点，没有可用节点时返回原来的节点。
+// NodeFilter 先于排除和熔断执行，看到的是服务当前的所有节点
+func (o *Options) FilterNodes(serviceName string, nodes []*Node) []*Node {
+	available := nodes
+	for _, f := range o.Filters {
+		available = f.Filter(serviceName, available)
+	}
+
+	if len(o.Exclude) > 0 || o.Breaker != nil {
+		filtered := make([]*Node, 0, len(available))
+		for _, node := range available {
+			if o.Available(node.Addr()) {
+				filtered = append(filtered, node)
+			}
+		}
+		available = filtered
+	}
+
+	if len(available) == 0 {
+		return nodes
+	}
+	return available
+}
+
+// Addr 返回节点的地址。没有设置 Address 时从 Key 中解析，Key 是地址或者以地址结尾的路径，比如 helloworld.Greeter/127.0.0.1:8000，
+// 地址中带 / 的节点（比如 unix socket 的路径）需要设置 Address
+func (n *Node) Addr() string {
+	if n.Address != "" {
+		return n.Address
+	}
+	return n.Key[strings.LastIndex(n.Key, "/")+1:]
+}
@@ -0,0 +1,236 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/selector"
+	"github.com/junaozun/go-lrpxc/selector/loadbalance"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+文件 Selector：服务节点从一个 JSON 或者 YAML 文件中读取，文件的格式是服务名到节点列表的映射，比如
+
+	helloworld.Greeter:
+	  - addr: 127.0.0.1:8000
+	    weight: 2
+	  - addr: 127.0.0.1:8001
+
+后缀是 .json 的文件按 JSON 解析，其他按 YAML 解析。Selector 每隔 WatchInterval 检查一次文件的修改时间和大小，
+文件变化后重新加载节点，加载失败时继续使用原来的节点。
+*/
+
+// Scheme 是节点文件地址的前缀
+const Scheme = "file"
+
+const (
+	defaultWatchInterval = time.Second
+	defaultWeight        = 1
+)
+
+// Options 文件 Selector 的参数
+type Options struct {
+	BalancerName  string        // load balance mode, random, roundRobin, weightedRoundRobin, consistentHash, p2c, leastConn
+	WatchInterval time.Duration // how often the file is checked for changes, 0 means never
+}
+
+type Option func(*Options)
+
+func init() {
+	selector.RegisterBuilder(Scheme, build)
+}
+
+// build 根据 file:///etc/nodes.yaml?balancer=roundRobin 创建文件 Selector
+func build(target *selector.Target) (selector.Selector, error) {
+	var opts []Option
+	if balancerName := target.Query.Get("balancer"); balancerName != "" {
+		opts = append(opts, WithBalancerName(balancerName))
+	}
+	return New(target.Path(), opts...)
+}
+
+// WithBalancerName 设置负载均衡方式，默认随机
+func WithBalancerName(balancerName string) Option {
+	return func(o *Options) {
+		o.BalancerName = balancerName
+	}
+}
+
+// WithWatchInterval 设置检查文件变化的间隔，0 表示不检查
+func WithWatchInterval(interval time.Duration) Option {
+	return func(o *Options) {
+		o.WatchInterval = interval
+	}
+}
+
+// nodeConfig 是文件中的一个节点
+type nodeConfig struct {
+	Addr   string `json:"addr" yaml:"addr"`
+	Weight int    `json:"weight" yaml:"weight"`
+}
+
+// Selector 从文件中读取服务节点
+type Selector struct {
+	path string
+	opts *Options
+
+	mu      sync.RWMutex
+	nodes   map[string][]*selector.Node // serviceName -> nodes
+	modTime time.Time
+	size    int64
+
+	closeOnce sync.Once
+	closing   chan struct{}
+}
+
+// New 读取 path 中的服务节点，创建一个文件 Selector，默认每秒检查一次文件变化
+func New(path string, opts ...Option) (*Selector, error) {
+	o := &Options{
+		BalancerName:  loadbalance.Random,
+		WatchInterval: defaultWatchInterval,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	s := &Selector{
+		path:    path,
+		opts:    o,
+		closing: make(chan struct{}),
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.load(info); err != nil {
+		return nil, err
+	}
+
+	if o.WatchInterval > 0 {
+		go s.watch()
+	}
+
+	return s, nil
+}
+
+// Select 通过负载均衡从文件中配置的服务节点中选出一个节点
+func (s *Selector) Select(serviceName string, opts ...selector.Option) (string, error) {
+	nodes, err := s.Resolve(serviceName)
+	if err != nil {
+		return "", err
+	}
+	return loadbalance.Pick(s.opts.BalancerName, serviceName, nodes, opts...)
+}
+
+// Resolve 返回服务的节点列表
+func (s *Selector) Resolve(serviceName string) ([]*selector.Node, error) {
+	s.mu.RLock()
+	nodes := s.nodes[serviceName]
+	s.mu.RUnlock()
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no services find in %s : %s", s.path, serviceName)
+	}
+	return nodes, nil
+}
+
+// Report 将调用结果反馈给 Balancer
+func (s *Selector) Report(serviceName string, info selector.DoneInfo) {
+	loadbalance.Report(s.opts.BalancerName, serviceName, info)
+}
+
+// Close 停止检查文件变化
+func (s *Selector) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+	})
+	return nil
+}
+
+// watch 定时检查文件的修改时间和大小，变化后重新加载
+func (s *Selector) watch() {
+	ticker := time.NewTicker(s.opts.WatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closing:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(s.path)
+		if err != nil {
+			fmt.Printf("file selector stat %s error, %v\n", s.path, err)
+			continue
+		}
+
+		s.mu.RLock()
+		changed := !info.ModTime().Equal(s.modTime) || info.Size() != s.size
+		s.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := s.load(info); err != nil {
+			fmt.Printf("file selector reload %s error, keep the old nodes, %v\n", s.path, err)
+			// 文件再次变化之前不再重复加载
+			s.mu.Lock()
+			s.modTime = info.ModTime()
+			s.size = info.Size()
+			s.mu.Unlock()
+		}
+	}
+}
+
+// load 读取并解析文件，成功后替换原来的节点
+func (s *Selector) load(info os.FileInfo) error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	config := make(map[string][]nodeConfig)
+	if strings.EqualFold(filepath.Ext(s.path), ".json") {
+		err = json.Unmarshal(data, &config)
+	} else {
+		err = yaml.Unmarshal(data, &config)
+	}
+	if err != nil {
+		return err
+	}
+
+	nodes := make(map[string][]*selector.Node, len(config))
+	for serviceName, list := range config {
+		for _, n := range list {
+			if n.Addr == "" {
+				return fmt.Errorf("service %s has a node without addr", serviceName)
+			}
+			weight := n.Weight
+			if weight <= 0 {
+				weight = defaultWeight
+			}
+			nodes[serviceName] = append(nodes[serviceName], &selector.Node{
+				Key:     serviceName + "/" + n.Addr,
+				Weight:  weight,
+				Address: n.Addr,
+			})
+		}
+	}
+
+	s.mu.Lock()
+	s.nodes = nodes
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	s.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const yamlNodes = `helloworld.Greeter:
+  - addr: 127.0.0.1:8000
+    weight: 2
+  - addr: /tmp/lrpc.sock
+`
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.yaml")
+	writeFile(t, path, yamlNodes)
+
+	s, err := New(path, WithWatchInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := s.Resolve("helloworld.Greeter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Fatalf("got %d nodes, want 2", len(nodes))
+	}
+	if nodes[0].Addr() != "127.0.0.1:8000" || nodes[0].Weight != 2 {
+		t.Errorf("node 0 = %s;weight=%d, want 127.0.0.1:8000;weight=2", nodes[0].Addr(), nodes[0].Weight)
+	}
+	// unix socket 的路径中带 /，地址不能从 Key 中解析
+	if nodes[1].Addr() != "/tmp/lrpc.sock" || nodes[1].Weight != 1 {
+		t.Errorf("node 1 = %s;weight=%d, want /tmp/lrpc.sock;weight=1", nodes[1].Addr(), nodes[1].Weight)
+	}
+
+	if _, err := s.Resolve("unknown.Service"); err == nil {
+		t.Fatal("Resolve succeeded for an unknown service")
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.json")
+	writeFile(t, path, `{"helloworld.Greeter": [{"addr": "127.0.0.1:8000"}]}`)
+
+	s, err := New(path, WithWatchInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := s.Select("helloworld.Greeter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != "127.0.0.1:8000" {
+		t.Fatalf("selected %s, want 127.0.0.1:8000", addr)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.yaml")
+	writeFile(t, path, "helloworld.Greeter:\n  - weight: 2\n")
+
+	if _, err := New(path, WithWatchInterval(0)); err == nil {
+		t.Fatal("New succeeded with a node without addr")
+	}
+}
+
+func TestWatchReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nodes.yaml")
+	writeFile(t, path, yamlNodes)
+
+	s, err := New(path, WithWatchInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	// 加载失败时继续使用原来的节点
+	writeFile(t, path, "helloworld.Greeter: [")
+	time.Sleep(50 * time.Millisecond)
+	if nodes, err := s.Resolve("helloworld.Greeter"); err != nil || len(nodes) != 2 {
+		t.Fatalf("Resolve after a broken file got %d nodes, %v, want the old 2 nodes", len(nodes), err)
+	}
+
+	writeFile(t, path, "helloworld.Greeter:\n  - addr: 127.0.0.1:9000\n")
+	deadline := time.Now().Add(time.Second)
+	for {
+		nodes, err := s.Resolve("helloworld.Greeter")
+		if err == nil && len(nodes) == 1 && nodes[0].Addr() == "127.0.0.1:9000" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("nodes were not reloaded, got %d nodes, %v", len(nodes), err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,33 @@
+package selector
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Inflight 记录每个地址正在处理的请求数，client transport 在请求发出前加一、结束后减一，
+// 连接池和多路复用连接上的请求都会被统计，负载均衡算法可以根据它选择节点
+type Inflight struct {
+	counts sync.Map // address -> *int64
+}
+
+// DefaultInflight 是 client transport 默认更新的请求数统计
+var DefaultInflight = &Inflight{}
+
+// Add 将地址为 addr 的节点正在处理的请求数加上 delta
+func (f *Inflight) Add(addr string, delta int64) {
+	v, ok := f.counts.Load(addr)
+	if !ok {
+		v, _ = f.counts.LoadOrStore(addr, new(int64))
+	}
+	atomic.AddInt64(v.(*int64), delta)
+}
+
+// Count 返回地址为 addr 的节点正在处理的请求数
+func (f *Inflight) Count(addr string) int64 {
+	v, ok := f.counts.Load(addr)
+	if !ok {
+		return 0
+	}
+	return atomic.LoadInt64(v.(*int64))
+}
@@ -2,10 +2,30 @@ package loadbalance
 
 import "github.com/junaozun/go-lrpxc/selector"
 
+// Balancer 从服务节点中选出一个节点，opts 中带有调用的 ctx、方法名、元数据等信息
 type Balancer interface {
+	Balance(string, []*selector.Node, ...selector.Option) *selector.Node
+}
+
+// LegacyBalancer 是只根据服务名选择节点的旧版 Balancer
+type LegacyBalancer interface {
 	Balance(string, []*selector.Node) *selector.Node
 }
 
+// AdaptLegacyBalancer 将旧版 Balancer 包装成 Balancer，调用参数会被忽略，
+// 比如 RegisterBalancer("xxx", AdaptLegacyBalancer(b))
+func AdaptLegacyBalancer(b LegacyBalancer) Balancer {
+	return &legacyBalancer{b}
+}
+
+type legacyBalancer struct {
+	b LegacyBalancer
+}
+
+func (l *legacyBalancer) Balance(serviceName string, nodes []*selector.Node, opts ...selector.Option) *selector.Node {
+	return l.b.Balance(serviceName, nodes)
+}
+
 var balancerMap = make(map[string]Balancer, 0)
 
 const (
@@ -13,6 +33,8 @@ const (
 	RoundRobin         = "roundRobin"
 	WeightedRoundRobin = "weightedRoundRobin"
 	ConsistentHash     = "consistentHash"
+	P2C                = "p2c"
+	LeastConn          = "leastConn"
 )
 
 func init() {
@@ -20,6 +42,8 @@ func init() {
 	RegisterBalancer(RoundRobin, RRBalancer)
 	RegisterBalancer(WeightedRoundRobin, WRRBalancer)
 	RegisterBalancer(ConsistentHash, ConsistHashBalancer)
+	RegisterBalancer(P2C, P2CBalancer)
+	RegisterBalancer(LeastConn, LeastConnBalancer)
 }
 
 // 请求随机分配到各个服务器。
@@ -36,6 +60,13 @@ var WRRBalancer = newWeightedRoundRobinBalancer()
 // 缺点：实现较为复杂，请求量比较小的场景下，可能会出现某个服务器节点完全空闲的情况
 var ConsistHashBalancer = newConsistentHashBalancer()
 
+// 随机选出两个节点，将请求分发到负载（延迟和正在处理的请求数）较小的节点上。
+// 需要调用结果的反馈，适合节点性能不一致或者会变化的场景
+var P2CBalancer = newP2CBalancer()
+
+// 将请求分发到正在处理的请求数最少的节点上，适合请求处理时长差别较大的场景。
+var LeastConnBalancer = newLeastConnBalancer(selector.DefaultInflight)
+
 func RegisterBalancer(name string, balancer Balancer) {
 	if balancerMap == nil {
 		balancerMap = make(map[string]Balancer)
@@ -1,18 +1,166 @@
 package loadbalance
 
 import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
 	"github.com/junaozun/go-lrpxc/selector"
 )
 
+// 一致性哈希算法
+// 每个服务节点在哈希环上放置 replicas 个虚拟节点，请求按 HashKey 的哈希值顺时针找到第一个可用的虚拟节点，
+// 对应的服务节点就是请求的目标节点。节点增减时只有相邻区间的请求会被重新分配。
+// 哈希环用过滤之前的全部节点创建，被熔断、被排除或者被剔除的节点只是在查找时被跳过，它们的请求顺时针落到下一个节点，
+// 其他节点的请求不受影响，节点恢复后请求回到原来的节点。每个服务的哈希环会被缓存，只有服务节点发生变化时才会重建
 type consistHashBalancer struct {
+	rings    *sync.Map // serviceName -> *hashRing
+	replicas int       // number of virtual nodes per node
+	hash     HashFunc
 }
 
-// 一致性哈希算法
-func newConsistentHashBalancer() *consistHashBalancer {
-	return &consistHashBalancer{}
+// HashFunc 将 key 映射到哈希环上
+type HashFunc func(data []byte) uint32
+
+const defaultReplicas = 100
+
+// ConsistentHashOption 一致性哈希的参数选项
+type ConsistentHashOption func(*consistHashBalancer)
+
+// WithReplicas 设置每个服务节点的虚拟节点数，虚拟节点越多，请求分布越均匀
+func WithReplicas(replicas int) ConsistentHashOption {
+	return func(b *consistHashBalancer) {
+		b.replicas = replicas
+	}
+}
+
+// WithHashFunc 设置哈希函数，默认使用 crc32
+func WithHashFunc(hash HashFunc) ConsistentHashOption {
+	return func(b *consistHashBalancer) {
+		b.hash = hash
+	}
 }
 
-// todo 未实现
-func (r *consistHashBalancer) Balance(serviceName string, nodes []*selector.Node) *selector.Node {
+func newConsistentHashBalancer(opts ...ConsistentHashOption) *consistHashBalancer {
+	b := &consistHashBalancer{
+		rings:    new(sync.Map),
+		replicas: defaultReplicas,
+		hash:     crc32.ChecksumIEEE,
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	if b.replicas <= 0 {
+		b.replicas = defaultReplicas
+	}
+	return b
+}
+
+// NewConsistentHashBalancer 创建一个自定义参数的一致性哈希 Balancer，
+// 可以通过 RegisterBalancer(ConsistentHash, NewConsistentHashBalancer(...)) 替换默认的实现
+func NewConsistentHashBalancer(opts ...ConsistentHashOption) Balancer {
+	return newConsistentHashBalancer(opts...)
+}
+
+// Balance 根据调用的 HashKey 选择节点，HashKey 可以通过 WithHashKey、ctx 或者元数据设置，见 selector.Options.GetHashKey。
+// 没有 key 的请求随机选择节点
+func (r *consistHashBalancer) Balance(serviceName string, nodes []*selector.Node, opts ...selector.Option) *selector.Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	o := &selector.Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	hashKey := o.GetHashKey()
+	if hashKey == "" {
+		return RandomBalancer.Balance(serviceName, nodes, opts...)
+	}
+
+	all := o.Nodes
+	if len(all) == 0 {
+		all = nodes
+	}
+	signature := nodesSignature(all)
+
+	var ring *hashRing
+	if v, ok := r.rings.Load(serviceName); ok && v.(*hashRing).signature == signature {
+		ring = v.(*hashRing)
+	} else {
+		ring = r.newHashRing(signature, all)
+		r.rings.Store(serviceName, ring)
+	}
+
+	var available map[string]bool
+	if len(nodes) != len(all) {
+		available = make(map[string]bool, len(nodes))
+		for _, node := range nodes {
+			available[node.Key] = true
+		}
+	}
+
+	if node := ring.get(r.hash([]byte(hashKey)), available); node != nil {
+		return node
+	}
+	return RandomBalancer.Balance(serviceName, nodes, opts...)
+}
+
+// hashRing 是一个服务的哈希环，创建后只读
+type hashRing struct {
+	signature string                    // keys of the nodes on the ring
+	hashes    []uint32                  // sorted hashes of the virtual nodes
+	nodes     map[uint32]*selector.Node // virtual node hash -> node
+}
+
+func (r *consistHashBalancer) newHashRing(signature string, nodes []*selector.Node) *hashRing {
+	ring := &hashRing{
+		signature: signature,
+		hashes:    make([]uint32, 0, len(nodes)*r.replicas),
+		nodes:     make(map[uint32]*selector.Node, len(nodes)*r.replicas),
+	}
+
+	for _, node := range nodes {
+		for i := 0; i < r.replicas; i++ {
+			hash := r.hash([]byte(strconv.Itoa(i) + node.Key))
+			if _, ok := ring.nodes[hash]; ok {
+				continue
+			}
+			ring.nodes[hash] = node
+			ring.hashes = append(ring.hashes, hash)
+		}
+	}
+
+	sort.Slice(ring.hashes, func(i, j int) bool {
+		return ring.hashes[i] < ring.hashes[j]
+	})
+
+	return ring
+}
+
+// get 从第一个哈希值不小于 hash 的虚拟节点开始顺时针查找，返回第一个可用的节点，available 为 nil 表示所有节点都可用
+func (ring *hashRing) get(hash uint32, available map[string]bool) *selector.Node {
+	idx := sort.Search(len(ring.hashes), func(i int) bool {
+		return ring.hashes[i] >= hash
+	})
+
+	for i := 0; i < len(ring.hashes); i++ {
+		node := ring.nodes[ring.hashes[(idx+i)%len(ring.hashes)]]
+		if available == nil || available[node.Key] {
+			return node
+		}
+	}
 	return nil
 }
+
+// nodesSignature 用节点 key 排序后拼接的结果标识一组节点，节点的顺序变化不会导致哈希环重建
+func nodesSignature(nodes []*selector.Node) string {
+	keys := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		keys = append(keys, node.Key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
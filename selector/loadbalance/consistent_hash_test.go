@@ -0,0 +1,157 @@
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/junaozun/go-lrpxc/selector"
+)
+
+func hashNodes(n int) []*selector.Node {
+	nodes := make([]*selector.Node, 0, n)
+	for i := 0; i < n; i++ {
+		nodes = append(nodes, &selector.Node{Key: fmt.Sprintf("svc/10.0.0.%d:8000", i)})
+	}
+	return nodes
+}
+
+func hashKeys(n int) []string {
+	keys := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		keys = append(keys, fmt.Sprintf("user-%d", i))
+	}
+	return keys
+}
+
+func TestConsistentHashSameKeySameNode(t *testing.T) {
+	b := newConsistentHashBalancer()
+	nodes := hashNodes(5)
+
+	for _, key := range hashKeys(100) {
+		first := b.Balance("svc", nodes, selector.WithHashKey(key))
+		for i := 0; i < 3; i++ {
+			if got := b.Balance("svc", nodes, selector.WithHashKey(key)); got != first {
+				t.Fatalf("key %s routed to %s then %s", key, first.Key, got.Key)
+			}
+		}
+	}
+}
+
+func TestConsistentHashRemovingNodeOnlyMovesItsKeys(t *testing.T) {
+	b := newConsistentHashBalancer()
+	nodes := hashNodes(5)
+	keys := hashKeys(1000)
+
+	before := make(map[string]string)
+	for _, key := range keys {
+		before[key] = b.Balance("svc", nodes, selector.WithHashKey(key)).Key
+	}
+
+	removed := nodes[2].Key
+	remaining := append(append([]*selector.Node(nil), nodes[:2]...), nodes[3:]...)
+
+	moved := 0
+	for _, key := range keys {
+		after := b.Balance("svc", remaining, selector.WithHashKey(key)).Key
+		if before[key] != removed && after != before[key] {
+			t.Fatalf("key %s moved from %s to %s although its node was not removed", key, before[key], after)
+		}
+		if before[key] == removed {
+			moved++
+		}
+	}
+	if moved == 0 || moved == len(keys) {
+		t.Fatalf("unexpected number of keys on the removed node: %d", moved)
+	}
+}
+
+func TestConsistentHashFilteredNodesKeepTheRing(t *testing.T) {
+	b := newConsistentHashBalancer()
+	nodes := hashNodes(5)
+	keys := hashKeys(1000)
+
+	before := make(map[string]string)
+	for _, key := range keys {
+		before[key] = b.Balance("svc", nodes, selector.WithHashKey(key), selector.WithNodes(nodes)).Key
+	}
+	ring, _ := b.rings.Load("svc")
+
+	// node 1 is broken, the balancer only receives the available nodes
+	broken := nodes[1].Key
+	available := append(append([]*selector.Node(nil), nodes[:1]...), nodes[2:]...)
+	for _, key := range keys {
+		got := b.Balance("svc", available, selector.WithHashKey(key), selector.WithNodes(nodes)).Key
+		if got == broken {
+			t.Fatalf("key %s routed to the filtered node", key)
+		}
+		if before[key] != broken && got != before[key] {
+			t.Fatalf("key %s moved from %s to %s when another node was filtered", key, before[key], got)
+		}
+	}
+
+	if now, _ := b.rings.Load("svc"); now != ring {
+		t.Fatal("the ring was rebuilt because of filtered nodes")
+	}
+
+	// the node recovers, its keys come back
+	for _, key := range keys {
+		if got := b.Balance("svc", nodes, selector.WithHashKey(key), selector.WithNodes(nodes)).Key; got != before[key] {
+			t.Fatalf("key %s routed to %s after recovery, want %s", key, got, before[key])
+		}
+	}
+}
+
+func TestConsistentHashKeyFromContextAndMetadata(t *testing.T) {
+	b := newConsistentHashBalancer()
+	nodes := hashNodes(5)
+	want := b.Balance("svc", nodes, selector.WithHashKey("user-42"))
+
+	ctx := selector.ContextWithHashKey(context.Background(), "user-42")
+	for i := 0; i < 10; i++ {
+		if got := b.Balance("svc", nodes, selector.WithContext(ctx)); got != want {
+			t.Fatalf("ctx hash key routed to %s, want %s", got.Key, want.Key)
+		}
+	}
+
+	md := map[string][]byte{selector.HashKeyMetadataKey: []byte("user-42")}
+	for i := 0; i < 10; i++ {
+		if got := b.Balance("svc", nodes, selector.WithMetadata(md)); got != want {
+			t.Fatalf("metadata hash key routed to %s, want %s", got.Key, want.Key)
+		}
+	}
+
+	// WithHashKey takes precedence over the ctx and the metadata
+	o := &selector.Options{}
+	for _, opt := range []selector.Option{selector.WithContext(ctx), selector.WithMetadata(md), selector.WithHashKey("explicit")} {
+		opt(o)
+	}
+	if got := o.GetHashKey(); got != "explicit" {
+		t.Fatalf("GetHashKey = %s, want explicit", got)
+	}
+}
+
+func TestPickPassesUnfilteredNodes(t *testing.T) {
+	nodes := hashNodes(5)
+	keys := hashKeys(200)
+
+	before := make(map[string]string)
+	for _, key := range keys {
+		addr, err := Pick(ConsistentHash, "pick-svc", nodes, selector.WithHashKey(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		before[key] = addr
+	}
+
+	excluded := nodes[0].Addr()
+	for _, key := range keys {
+		addr, err := Pick(ConsistentHash, "pick-svc", nodes, selector.WithHashKey(key), selector.WithExclude(excluded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr == excluded || (before[key] != excluded && addr != before[key]) {
+			t.Fatalf("key %s routed to %s with %s excluded, was %s", key, addr, excluded, before[key])
+		}
+	}
+}
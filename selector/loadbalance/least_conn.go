@@ -0,0 +1,54 @@
+package loadbalance
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/selector"
+)
+
+// 最少连接数算法
+// 将请求分发到正在处理的请求数最少的节点上，请求数相同的节点随机选择。请求的处理时长差别较大时，
+// 处理慢的节点上的请求会堆积，最少连接数可以避免继续向这些节点分发请求
+type leastConnBalancer struct {
+	inflight *selector.Inflight
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newLeastConnBalancer(inflight *selector.Inflight) *leastConnBalancer {
+	return &leastConnBalancer{
+		inflight: inflight,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// NewLeastConnBalancer 创建一个根据 inflight 统计的请求数选择节点的最少连接数 Balancer，
+// 默认的实现使用 client transport 更新的 selector.DefaultInflight
+func NewLeastConnBalancer(inflight *selector.Inflight) Balancer {
+	return newLeastConnBalancer(inflight)
+}
+
+func (b *leastConnBalancer) Balance(serviceName string, nodes []*selector.Node, opts ...selector.Option) *selector.Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	var candidates []*selector.Node
+	var min int64
+	for _, node := range nodes {
+		count := b.inflight.Count(node.Addr())
+		if len(candidates) == 0 || count < min {
+			candidates = append(candidates[:0], node)
+			min = count
+		} else if count == min {
+			candidates = append(candidates, node)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return candidates[b.rand.Intn(len(candidates))]
+}
@@ -0,0 +1,151 @@
+package loadbalance
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/selector"
+)
+
+// P2C（power of two choices）算法
+// 每个节点记录调用延迟的指数加权移动平均值（EWMA），每次随机选出两个节点，
+// 选择负载（EWMA 延迟 * (正在处理的请求数 + 1)）较小的节点。超过 decay 没有被选中的节点会被强制选中一次，
+// 避免延迟变高过的节点一直选不到，延迟数据无法更新。节点的调用结果需要通过 Report 反馈给 Balancer，
+// Selector 实现了 selector.Reporter 时，client transport 会在每次调用结束后反馈。
+// 正在处理的请求数读取 client transport 在请求发出时加一、结束时减一的 selector.Inflight，
+// 选中节点后请求没有发出（比如建连失败、被熔断）不会让计数泄漏
+type p2cBalancer struct {
+	decay    time.Duration      // time constant of the EWMA, a sample older than decay weighs about 1/e
+	inflight *selector.Inflight // requests being handled by each node
+
+	mu    sync.Mutex
+	nodes map[string]*p2cNode // serviceName/addr -> statistics
+	rand  *rand.Rand
+}
+
+type p2cNode struct {
+	ewma     float64   // EWMA latency in nanoseconds
+	lastTime time.Time // time of the last sample
+	lastPick time.Time // time of the last pick
+}
+
+const defaultP2CDecay = 10 * time.Second
+
+// P2COption P2C 的参数选项
+type P2COption func(*p2cBalancer)
+
+// WithDecayTime 设置 EWMA 的衰减时间，越小越偏向最近的延迟
+func WithDecayTime(decay time.Duration) P2COption {
+	return func(b *p2cBalancer) {
+		b.decay = decay
+	}
+}
+
+// WithInflight 设置节点正在处理的请求数的来源，默认是 client transport 更新的 selector.DefaultInflight
+func WithInflight(inflight *selector.Inflight) P2COption {
+	return func(b *p2cBalancer) {
+		b.inflight = inflight
+	}
+}
+
+func newP2CBalancer(opts ...P2COption) *p2cBalancer {
+	b := &p2cBalancer{
+		decay:    defaultP2CDecay,
+		inflight: selector.DefaultInflight,
+		nodes:    make(map[string]*p2cNode),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	if b.decay <= 0 {
+		b.decay = defaultP2CDecay
+	}
+	if b.inflight == nil {
+		b.inflight = selector.DefaultInflight
+	}
+	return b
+}
+
+// NewP2CBalancer 创建一个自定义参数的 P2C Balancer，
+// 可以通过 RegisterBalancer(P2C, NewP2CBalancer(...)) 替换默认的实现
+func NewP2CBalancer(opts ...P2COption) Balancer {
+	return newP2CBalancer(opts...)
+}
+
+// Balance 随机选出两个节点，返回负载较小的节点
+func (b *p2cBalancer) Balance(serviceName string, nodes []*selector.Node, opts ...selector.Option) *selector.Node {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var picked *selector.Node
+	if len(nodes) == 1 {
+		picked = nodes[0]
+	} else {
+		i := b.rand.Intn(len(nodes))
+		j := b.rand.Intn(len(nodes) - 1)
+		if j >= i {
+			j++
+		}
+		picked = b.pick(serviceName, nodes[i], nodes[j])
+	}
+
+	b.node(serviceName, picked.Addr()).lastPick = time.Now()
+	return picked
+}
+
+// Report 调用结束后更新节点的 EWMA 延迟
+func (b *p2cBalancer) Report(serviceName string, info selector.DoneInfo) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := b.node(serviceName, info.Addr)
+
+	now := time.Now()
+	latency := float64(info.Latency)
+	if n.lastTime.IsZero() {
+		n.ewma = latency
+	} else {
+		w := math.Exp(-float64(now.Sub(n.lastTime)) / float64(b.decay))
+		n.ewma = n.ewma*w + latency*(1-w)
+	}
+	n.lastTime = now
+}
+
+// pick 从两个节点中选出负载较小的节点，超过 decay 没有被选中的节点优先
+func (b *p2cBalancer) pick(serviceName string, a, c *selector.Node) *selector.Node {
+	na, nc := b.node(serviceName, a.Addr()), b.node(serviceName, c.Addr())
+	now := time.Now()
+	if !nc.lastPick.IsZero() && now.Sub(nc.lastPick) > b.decay {
+		return c
+	}
+	if !na.lastPick.IsZero() && now.Sub(na.lastPick) > b.decay {
+		return a
+	}
+
+	if b.load(nc, c.Addr()) < b.load(na, a.Addr()) {
+		return c
+	}
+	return a
+}
+
+// load 返回节点的负载，新节点没有延迟数据，按正在处理的请求数比较负载
+func (b *p2cBalancer) load(n *p2cNode, addr string) float64 {
+	return (n.ewma + 1) * float64(b.inflight.Count(addr)+1)
+}
+
+func (b *p2cBalancer) node(serviceName, addr string) *p2cNode {
+	key := serviceName + "/" + addr
+	n, ok := b.nodes[key]
+	if !ok {
+		n = &p2cNode{}
+		b.nodes[key] = n
+	}
+	return n
+}
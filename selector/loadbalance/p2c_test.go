@@ -0,0 +1,41 @@
+package loadbalance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/selector"
+)
+
+func TestP2CPrefersFewerInflight(t *testing.T) {
+	inflight := &selector.Inflight{}
+	b := newP2CBalancer(WithInflight(inflight))
+	nodes := []*selector.Node{{Key: "/svc/127.0.0.1:8001"}, {Key: "/svc/127.0.0.1:8002"}}
+
+	inflight.Add("127.0.0.1:8001", 10)
+	for i := 0; i < 20; i++ {
+		if n := b.Balance("svc", nodes); n.Addr() != "127.0.0.1:8002" {
+			t.Fatalf("picked %s, want the node with fewer requests in flight", n.Addr())
+		}
+	}
+}
+
+func TestP2CBalanceWithoutReport(t *testing.T) {
+	inflight := &selector.Inflight{}
+	b := newP2CBalancer(WithInflight(inflight))
+	nodes := []*selector.Node{{Key: "/svc/127.0.0.1:8001"}, {Key: "/svc/127.0.0.1:8002"}}
+
+	// 选中节点之后请求没有发出，也就没有 Report，不能影响之后的选择
+	for i := 0; i < 100; i++ {
+		b.Balance("svc", nodes)
+	}
+	b.Report("svc", selector.DoneInfo{Addr: "127.0.0.1:8001", Latency: time.Millisecond})
+	b.Report("svc", selector.DoneInfo{Addr: "127.0.0.1:8002", Latency: time.Millisecond})
+
+	inflight.Add("127.0.0.1:8002", 1)
+	for i := 0; i < 20; i++ {
+		if n := b.Balance("svc", nodes); n.Addr() != "127.0.0.1:8001" {
+			t.Fatalf("picked %s, want the idle node", n.Addr())
+		}
+	}
+}
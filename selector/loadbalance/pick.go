@@ -0,0 +1,34 @@
+package loadbalance
+
+import (
+	"fmt"
+
+	"github.com/junaozun/go-lrpxc/selector"
+)
+
+// Pick 去掉不可用的节点后，通过名为 balancerName 的 Balancer 选出一个节点，返回节点的地址。
+// Selector 通过服务发现拿到服务节点后，调用 Pick 完成负载均衡
+func Pick(balancerName, serviceName string, nodes []*selector.Node, opts ...selector.Option) (string, error) {
+	o := &selector.Options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	// 负载均衡之前去掉被排除、被熔断和被剔除的异常节点，过滤之前的节点通过 WithNodes 传给 Balancer
+	all := nodes
+	nodes = o.FilterNodes(serviceName, nodes)
+	opts = append(opts[:len(opts):len(opts)], selector.WithNodes(all))
+
+	node := GetBalancer(balancerName).Balance(serviceName, nodes, opts...)
+	if node == nil {
+		return "", fmt.Errorf("no services find in %s", serviceName)
+	}
+
+	return node.Addr(), nil
+}
+
+// Report 将调用结果反馈给名为 balancerName 的 Balancer
+func Report(balancerName, serviceName string, info selector.DoneInfo) {
+	if r, ok := GetBalancer(balancerName).(selector.Reporter); ok {
+		r.Report(serviceName, info)
+	}
+}
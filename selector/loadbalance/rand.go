@@ -15,7 +15,7 @@ func newRandomBalancer() *randomBalancer {
 	return &randomBalancer{}
 }
 
-func (r *randomBalancer) Balance(serviceName string, nodes []*selector.Node) *selector.Node {
+func (r *randomBalancer) Balance(serviceName string, nodes []*selector.Node, opts ...selector.Option) *selector.Node {
 	if len(nodes) == 0 {
 		return nil
 	}
@@ -22,7 +22,7 @@ func newRoundRobinBalancer() *roundRobinBalancer {
 	}
 }
 
-func (r *roundRobinBalancer) Balance(serviceName string, nodes []*selector.Node) *selector.Node {
+func (r *roundRobinBalancer) Balance(serviceName string, nodes []*selector.Node, opts ...selector.Option) *selector.Node {
 
 	var picker *roundRobinPicker
 
@@ -21,7 +21,7 @@ func newWeightedRoundRobinBalancer() *weightedRoundRobinBalancer {
 	}
 }
 
-func (w *weightedRoundRobinBalancer) Balance(serviceName string, nodes []*selector.Node) *selector.Node {
+func (w *weightedRoundRobinBalancer) Balance(serviceName string, nodes []*selector.Node, opts ...selector.Option) *selector.Node {
 	var picker *wRoundRobinPicker
 
 	if p, ok := w.pickers.Load(serviceName); !ok {
@@ -0,0 +1,325 @@
+package outlier
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/selector"
+)
+
+/*
+异常节点检测：根据 client 反馈的调用结果，每隔 Interval 统计一次服务下每个节点的失败率和平均延迟，
+统计在 Report 和 Filter 中进行，没有调用结果反馈时 Selector 选择节点也会触发统计，被剔除的节点能按时恢复。
+失败率达到 FailureRatio，或者平均延迟超过所有节点平均延迟中位数的 LatencyFactor 倍的节点被剔除。
+节点第 n 次被剔除的时长是 BaseEjectionTime * n，不超过 MaxEjectionTime，剔除时间到了之后节点自动恢复，
+之后一直正常的节点剔除次数会逐渐减少。同一时刻被剔除的节点不超过服务节点数的 MaxEjectionPercent%，
+服务节点数以最近一次 Filter 收到的节点列表为准，不在列表中的节点的统计数据会被删除。
+*/
+
+// Options 异常节点检测的参数
+type Options struct {
+	Interval           time.Duration // statistics of the nodes are evaluated every Interval
+	MinRequests        int           // nodes with fewer requests in the interval are not evaluated
+	FailureRatio       float64       // eject nodes whose failure ratio reaches FailureRatio, 0 means disabled
+	LatencyFactor      float64       // eject nodes whose average latency exceeds LatencyFactor * median, 0 means disabled
+	BaseEjectionTime   time.Duration // the nth ejection lasts BaseEjectionTime * n
+	MaxEjectionTime    time.Duration // upper limit of the ejection time
+	MaxEjectionPercent int           // at most MaxEjectionPercent% of the nodes of a service are ejected at the same time
+	// OnEject 节点被剔除或者恢复时的回调，可以用来打日志或者上报监控
+	OnEject func(serviceName, addr string, ejected bool)
+}
+
+type Option func(*Options)
+
+// WithInterval 设置统计的间隔
+func WithInterval(interval time.Duration) Option {
+	return func(o *Options) {
+		o.Interval = interval
+	}
+}
+
+// WithMinRequests 设置节点在一个统计间隔内至少有多少个请求才会被评估
+func WithMinRequests(n int) Option {
+	return func(o *Options) {
+		o.MinRequests = n
+	}
+}
+
+// WithFailureRatio 设置剔除节点的失败率
+func WithFailureRatio(ratio float64) Option {
+	return func(o *Options) {
+		o.FailureRatio = ratio
+	}
+}
+
+// WithLatencyFactor 设置剔除节点的延迟倍数，节点的平均延迟超过所有节点平均延迟中位数的 factor 倍时剔除
+func WithLatencyFactor(factor float64) Option {
+	return func(o *Options) {
+		o.LatencyFactor = factor
+	}
+}
+
+// WithEjectionTime 设置剔除时长，节点第 n 次被剔除的时长是 base * n，不超过 max
+func WithEjectionTime(base, max time.Duration) Option {
+	return func(o *Options) {
+		o.BaseEjectionTime = base
+		o.MaxEjectionTime = max
+	}
+}
+
+// WithMaxEjectionPercent 设置同一时刻最多剔除服务节点数的百分之多少
+func WithMaxEjectionPercent(percent int) Option {
+	return func(o *Options) {
+		o.MaxEjectionPercent = percent
+	}
+}
+
+// WithOnEject 设置节点被剔除或者恢复时的回调
+func WithOnEject(f func(serviceName, addr string, ejected bool)) Option {
+	return func(o *Options) {
+		o.OnEject = f
+	}
+}
+
+// Detector 是异常节点检测器，实现了 selector.Reporter 和 selector.NodeFilter，可以被多个 client 共享
+type Detector struct {
+	opts *Options
+
+	mu       sync.Mutex
+	services map[string]*serviceStats // serviceName -> statistics
+}
+
+type serviceStats struct {
+	lastEval time.Time
+	size     int                   // number of nodes of the service in the latest Filter, 0 means Filter is not called
+	nodes    map[string]*nodeStats // address -> statistics
+}
+
+type nodeStats struct {
+	requests     int
+	failures     int
+	latency      time.Duration // total latency in the interval
+	ejections    int           // the multiplier of the ejection time
+	ejectedUntil time.Time
+}
+
+// New 创建一个异常节点检测器，默认每 10s 统计一次，失败率达到 50% 的节点被剔除 30s 起，最多剔除一半的节点
+func New(opts ...Option) *Detector {
+	o := &Options{
+		Interval:           10 * time.Second,
+		MinRequests:        5,
+		FailureRatio:       0.5,
+		BaseEjectionTime:   30 * time.Second,
+		MaxEjectionTime:    300 * time.Second,
+		MaxEjectionPercent: 50,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return &Detector{
+		opts:     o,
+		services: make(map[string]*serviceStats),
+	}
+}
+
+// Report 记录一次调用的结果，统计间隔到了之后评估服务下的所有节点
+func (d *Detector) Report(serviceName string, info selector.DoneInfo) {
+	// 调用方取消或者调用方的 ctx 超时的请求（比如对冲请求中被取消的请求）不能说明节点的状态，既不算成功也不算失败
+	if errors.Is(info.Err, context.Canceled) || errors.Is(info.Err, context.DeadlineExceeded) {
+		return
+	}
+
+	d.mu.Lock()
+	svc := d.service(serviceName)
+	n, ok := svc.nodes[info.Addr]
+	if !ok {
+		n = &nodeStats{}
+		svc.nodes[info.Addr] = n
+	}
+	n.requests++
+	n.latency += info.Latency
+	if codes.IsNodeError(info.Err) {
+		n.failures++
+	}
+
+	changes, ejected := d.evaluateIfDue(svc)
+	d.mu.Unlock()
+
+	d.notify(serviceName, changes, ejected)
+}
+
+// Filter 去掉被剔除的节点，统计间隔到了之后先评估服务下的所有节点。nodes 是服务当前的所有节点，
+// 已经下线的节点的统计数据会被删除，剔除节点数的上限也按 nodes 计算
+func (d *Detector) Filter(serviceName string, nodes []*selector.Node) []*selector.Node {
+	d.mu.Lock()
+	svc := d.service(serviceName)
+	svc.size = len(nodes)
+	if len(svc.nodes) > 0 {
+		live := make(map[string]bool, len(nodes))
+		for _, node := range nodes {
+			live[node.Addr()] = true
+		}
+		for addr := range svc.nodes {
+			if !live[addr] {
+				delete(svc.nodes, addr)
+			}
+		}
+	}
+	changes, ejected := d.evaluateIfDue(svc)
+
+	now := time.Now()
+	available := make([]*selector.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if n, ok := svc.nodes[node.Addr()]; ok && now.Before(n.ejectedUntil) {
+			continue
+		}
+		available = append(available, node)
+	}
+	d.mu.Unlock()
+
+	d.notify(serviceName, changes, ejected)
+	return available
+}
+
+// Ejected 判断服务下地址为 addr 的节点是否被剔除
+func (d *Detector) Ejected(serviceName, addr string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	svc, ok := d.services[serviceName]
+	if !ok {
+		return false
+	}
+	n, ok := svc.nodes[addr]
+	return ok && time.Now().Before(n.ejectedUntil)
+}
+
+func (d *Detector) service(serviceName string) *serviceStats {
+	svc, ok := d.services[serviceName]
+	if !ok {
+		svc = &serviceStats{
+			lastEval: time.Now(),
+			nodes:    make(map[string]*nodeStats),
+		}
+		d.services[serviceName] = svc
+	}
+	return svc
+}
+
+// evaluateIfDue 在统计间隔到了之后评估服务下的所有节点，调用方需要持有 d.mu
+func (d *Detector) evaluateIfDue(svc *serviceStats) (changes []string, ejected []bool) {
+	if time.Since(svc.lastEval) < d.opts.Interval {
+		return nil, nil
+	}
+	return d.evaluate(svc)
+}
+
+// notify 在释放 d.mu 之后回调 OnEject
+func (d *Detector) notify(serviceName string, changes []string, ejected []bool) {
+	if d.opts.OnEject == nil {
+		return
+	}
+	for i, addr := range changes {
+		d.opts.OnEject(serviceName, addr, ejected[i])
+	}
+}
+
+// evaluate 评估服务下的所有节点，返回被剔除或者恢复的节点
+func (d *Detector) evaluate(svc *serviceStats) (changes []string, ejected []bool) {
+	now := time.Now()
+	svc.lastEval = now
+
+	// 剔除时间到了的节点恢复，一直正常的节点剔除次数逐渐减少
+	ejectedNum := 0
+	for addr, n := range svc.nodes {
+		if n.ejectedUntil.IsZero() {
+			if n.ejections > 0 && n.requests >= d.opts.MinRequests && !d.isOutlier(n, 0) {
+				n.ejections--
+			}
+			continue
+		}
+		if now.Before(n.ejectedUntil) {
+			ejectedNum++
+			continue
+		}
+		n.ejectedUntil = time.Time{}
+		changes = append(changes, addr)
+		ejected = append(ejected, false)
+	}
+
+	// 没有调用过 Filter 时按有调用结果的节点数计算上限
+	size := svc.size
+	if size == 0 {
+		size = len(svc.nodes)
+	}
+	maxEjected := size * d.opts.MaxEjectionPercent / 100
+
+	// 失败率最高的节点优先被剔除
+	median := d.medianLatency(svc)
+	var outliers []string
+	for addr, n := range svc.nodes {
+		if n.ejectedUntil.IsZero() && n.requests >= d.opts.MinRequests && d.isOutlier(n, median) {
+			outliers = append(outliers, addr)
+		}
+	}
+	sort.Slice(outliers, func(i, j int) bool {
+		a, b := svc.nodes[outliers[i]], svc.nodes[outliers[j]]
+		return a.failures*b.requests > b.failures*a.requests
+	})
+
+	for _, addr := range outliers {
+		if ejectedNum >= maxEjected {
+			break
+		}
+		n := svc.nodes[addr]
+		n.ejections++
+		ejection := d.opts.BaseEjectionTime * time.Duration(n.ejections)
+		if d.opts.MaxEjectionTime > 0 && ejection > d.opts.MaxEjectionTime {
+			ejection = d.opts.MaxEjectionTime
+		}
+		n.ejectedUntil = now.Add(ejection)
+		ejectedNum++
+		changes = append(changes, addr)
+		ejected = append(ejected, true)
+	}
+
+	for _, n := range svc.nodes {
+		n.requests, n.failures, n.latency = 0, 0, 0
+	}
+
+	return changes, ejected
+}
+
+// isOutlier 判断节点的失败率或者平均延迟是否异常，median 为 0 时不判断延迟
+func (d *Detector) isOutlier(n *nodeStats, median time.Duration) bool {
+	if n.requests == 0 {
+		return false
+	}
+	if d.opts.FailureRatio > 0 && float64(n.failures)/float64(n.requests) >= d.opts.FailureRatio {
+		return true
+	}
+	avg := n.latency / time.Duration(n.requests)
+	return d.opts.LatencyFactor > 0 && median > 0 && float64(avg) > d.opts.LatencyFactor*float64(median)
+}
+
+// medianLatency 返回请求数足够的节点平均延迟的中位数，节点少于 3 个时无法判断延迟异常，返回 0
+func (d *Detector) medianLatency(svc *serviceStats) time.Duration {
+	var avgs []time.Duration
+	for _, n := range svc.nodes {
+		if n.ejectedUntil.IsZero() && n.requests >= d.opts.MinRequests {
+			avgs = append(avgs, n.latency/time.Duration(n.requests))
+		}
+	}
+	if len(avgs) < 3 {
+		return 0
+	}
+	sort.Slice(avgs, func(i, j int) bool {
+		return avgs[i] < avgs[j]
+	})
+	return avgs[len(avgs)/2]
+}
@@ -0,0 +1,181 @@
+package outlier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/selector"
+)
+
+const serviceName = "test.Service"
+
+var addrs = []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003", "127.0.0.1:8004"}
+
+func testNodes(addrs ...string) []*selector.Node {
+	nodes := make([]*selector.Node, 0, len(addrs))
+	for _, addr := range addrs {
+		nodes = append(nodes, &selector.Node{Key: "/" + serviceName + "/" + addr})
+	}
+	return nodes
+}
+
+// events 记录 OnEject 回调
+type events struct {
+	mu       sync.Mutex
+	ejected  []string
+	restored []string
+}
+
+func (e *events) record(_, addr string, ejected bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ejected {
+		e.ejected = append(e.ejected, addr)
+	} else {
+		e.restored = append(e.restored, addr)
+	}
+}
+
+func (e *events) counts() (ejected, restored int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.ejected), len(e.restored)
+}
+
+func report(d *Detector, addr string, n int, err error) {
+	for i := 0; i < n; i++ {
+		d.Report(serviceName, selector.DoneInfo{Addr: addr, Err: err, Latency: time.Millisecond})
+	}
+}
+
+func TestMaxEjectionPercent(t *testing.T) {
+	ev := &events{}
+	d := New(WithInterval(20*time.Millisecond), WithMinRequests(3), WithFailureRatio(0.5),
+		WithEjectionTime(time.Minute, time.Minute), WithMaxEjectionPercent(50), WithOnEject(ev.record))
+
+	nodes := testNodes(addrs...)
+	if got := d.Filter(serviceName, nodes); len(got) != len(nodes) {
+		t.Fatalf("Filter returned %d nodes, want %d", len(got), len(nodes))
+	}
+
+	// 所有节点都失败，最多剔除一半的节点
+	for _, addr := range addrs {
+		report(d, addr, 3, codes.NetworkError)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	if got := d.Filter(serviceName, nodes); len(got) != 2 {
+		t.Fatalf("Filter returned %d nodes, want 2 with MaxEjectionPercent 50", len(got))
+	}
+	if ejected, _ := ev.counts(); ejected != 2 {
+		t.Fatalf("%d nodes ejected, want 2", ejected)
+	}
+
+	// 下一个统计间隔剔除数仍然不超过上限
+	for _, addr := range addrs {
+		report(d, addr, 3, codes.NetworkError)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	if got := d.Filter(serviceName, nodes); len(got) != 2 {
+		t.Fatalf("Filter returned %d nodes in the next interval, want 2", len(got))
+	}
+	if ejected, _ := ev.counts(); ejected != 2 {
+		t.Fatalf("%d nodes ejected in total, want 2", ejected)
+	}
+}
+
+func TestBusinessErrorsDoNotEject(t *testing.T) {
+	d := New(WithInterval(20*time.Millisecond), WithMinRequests(3), WithFailureRatio(0.5), WithMaxEjectionPercent(100))
+
+	nodes := testNodes(addrs...)
+	d.Filter(serviceName, nodes)
+	report(d, addrs[0], 5, codes.New(1000, "biz"))
+	time.Sleep(30 * time.Millisecond)
+
+	if got := d.Filter(serviceName, nodes); len(got) != len(nodes) {
+		t.Fatalf("Filter returned %d nodes, business errors should not eject a node", len(got))
+	}
+}
+
+func TestCallerAbortsAreNeutral(t *testing.T) {
+	d := New(WithInterval(20*time.Millisecond), WithMinRequests(3), WithFailureRatio(0.5), WithMaxEjectionPercent(100))
+
+	nodes := testNodes(addrs...)
+	d.Filter(serviceName, nodes)
+
+	// 被取消或者调用方超时的请求既不算失败也不算成功：addrs[0] 的请求数不够，addrs[1] 的失败率达到 2/3
+	for _, addr := range addrs[:2] {
+		report(d, addr, 1, nil)
+		report(d, addr, 2, context.Canceled)
+		report(d, addr, 2, fmt.Errorf("call: %w", context.DeadlineExceeded))
+	}
+	report(d, addrs[0], 1, codes.NetworkError)
+	report(d, addrs[1], 2, codes.NetworkError)
+	time.Sleep(30 * time.Millisecond)
+
+	d.Filter(serviceName, nodes)
+	if d.Ejected(serviceName, addrs[0]) {
+		t.Fatalf("%s is ejected, aborted calls were counted as failures", addrs[0])
+	}
+	if !d.Ejected(serviceName, addrs[1]) {
+		t.Fatalf("%s is not ejected, aborted calls were counted as successes", addrs[1])
+	}
+}
+
+func TestFilterEvaluatesWithoutReports(t *testing.T) {
+	ev := &events{}
+	d := New(WithInterval(20*time.Millisecond), WithMinRequests(3), WithFailureRatio(0.5),
+		WithEjectionTime(30*time.Millisecond, time.Minute), WithMaxEjectionPercent(50), WithOnEject(ev.record))
+
+	nodes := testNodes(addrs...)
+	d.Filter(serviceName, nodes)
+	report(d, addrs[0], 3, codes.NetworkError)
+	time.Sleep(30 * time.Millisecond)
+
+	// 没有新的调用结果，Filter 触发统计把节点剔除
+	if got := d.Filter(serviceName, nodes); len(got) != len(nodes)-1 {
+		t.Fatalf("Filter returned %d nodes, want %d", len(got), len(nodes)-1)
+	}
+	if !d.Ejected(serviceName, addrs[0]) {
+		t.Fatalf("%s is not ejected", addrs[0])
+	}
+
+	// 剔除时间到了之后，Filter 触发统计让节点恢复
+	time.Sleep(40 * time.Millisecond)
+	if got := d.Filter(serviceName, nodes); len(got) != len(nodes) {
+		t.Fatalf("Filter returned %d nodes after the ejection time, want %d", len(got), len(nodes))
+	}
+	if ejected, restored := ev.counts(); ejected != 1 || restored != 1 {
+		t.Fatalf("OnEject got %d ejections and %d restorations, want 1 and 1", ejected, restored)
+	}
+}
+
+func TestMaxEjectionPercentAfterScaleDown(t *testing.T) {
+	ev := &events{}
+	d := New(WithInterval(20*time.Millisecond), WithMinRequests(3), WithFailureRatio(0.5),
+		WithEjectionTime(time.Minute, time.Minute), WithMaxEjectionPercent(50), WithOnEject(ev.record))
+
+	removed := []string{"127.0.0.1:9001", "127.0.0.1:9002", "127.0.0.1:9003", "127.0.0.1:9004"}
+	d.Filter(serviceName, testNodes(append(removed, addrs...)...))
+	report(d, removed[0], 3, codes.NetworkError)
+
+	// 缩容到 4 个节点之后，剔除数的上限按当前的节点数计算，已经下线的节点的统计数据被删除
+	nodes := testNodes(addrs...)
+	d.Filter(serviceName, nodes)
+	for _, addr := range addrs {
+		report(d, addr, 3, codes.NetworkError)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	if got := d.Filter(serviceName, nodes); len(got) != 2 {
+		t.Fatalf("Filter returned %d nodes after the scale down, want 2 with MaxEjectionPercent 50", len(got))
+	}
+	if ejected, _ := ev.counts(); ejected != 2 {
+		t.Fatalf("%d nodes ejected, want 2", ejected)
+	}
+}
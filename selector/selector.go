@@ -1,18 +1,125 @@
 package selector
 
+import "context"
+
 //Selector 通过服务发现和负载均衡获取服务节点
 type Selector interface {
-	Select(string) (string, error)
+	Select(serviceName string, opts ...Option) (string, error)
 }
 
 type defaultSelector struct {
 }
 
+// Options 是一次调用选择节点时的参数，由 client 传给 Selector，再由 Selector 传给 Balancer，
+// Balancer 可以根据调用的 ctx、方法名和元数据实现一致性哈希、标签路由、就近路由等策略
 type Options struct {
+	Ctx      context.Context   // 调用的 ctx，可以从中获取超时时间等信息
+	Method   string            // 调用的方法名
+	Metadata map[string][]byte // client 透传给 server 的元数据
+	HashKey  string            // 一致性哈希使用的 key，比如用户 ID，相同 key 的请求会被路由到同一个节点
+	Nodes    []*Node           // 过滤之前的全部服务节点，一致性哈希用它建环，过滤条件变化时哈希环保持不变
+	Exclude  []string          // 不希望被选中的节点地址，比如对冲请求已经发送过的节点
+	Breaker  Breaker           // 节点熔断器，nil 表示不熔断
+	Filters  []NodeFilter      // 负载均衡之前过滤服务节点，比如异常节点检测
+}
+
+// Breaker 是按节点地址熔断的熔断器，Selector 通过它跳过熔断中的节点，circuitbreaker.Breaker 实现了这个接口
+type Breaker interface {
+	Allow(addr string) bool
+}
+
+// Available 判断地址为 addr 的节点能否被选中：不在 Exclude 中，并且没有被熔断
+func (o *Options) Available(addr string) bool {
+	for _, e := range o.Exclude {
+		if addr == e {
+			return false
+		}
+	}
+	return o.Breaker == nil || o.Breaker.Allow(addr)
 }
 
 type Option func(*Options)
 
+// Context 返回调用的 ctx，没有设置时返回 context.Background()
+func (o *Options) Context() context.Context {
+	if o.Ctx == nil {
+		return context.Background()
+	}
+	return o.Ctx
+}
+
+// WithContext 设置调用的 ctx
+func WithContext(ctx context.Context) Option {
+	return func(o *Options) {
+		o.Ctx = ctx
+	}
+}
+
+// WithMethod 设置调用的方法名
+func WithMethod(method string) Option {
+	return func(o *Options) {
+		o.Method = method
+	}
+}
+
+// WithExclude 设置不希望被选中的节点地址，Selector 在还有其他节点时应该避开这些节点
+func WithExclude(addrs ...string) Option {
+	return func(o *Options) {
+		o.Exclude = addrs
+	}
+}
+
+// WithBreaker 设置节点熔断器
+func WithBreaker(b Breaker) Option {
+	return func(o *Options) {
+		o.Breaker = b
+	}
+}
+
+// WithMetadata 设置调用的元数据
+func WithMetadata(md map[string][]byte) Option {
+	return func(o *Options) {
+		o.Metadata = md
+	}
+}
+
+// WithHashKey 设置一致性哈希使用的 key
+func WithHashKey(hashKey string) Option {
+	return func(o *Options) {
+		o.HashKey = hashKey
+	}
+}
+
+// WithNodes 设置过滤之前的全部服务节点，loadbalance.Pick 在过滤节点之后调用 Balancer 时设置
+func WithNodes(nodes []*Node) Option {
+	return func(o *Options) {
+		o.Nodes = nodes
+	}
+}
+
+// HashKeyMetadataKey 是一致性哈希的 key 在元数据中的名字，每次调用可以通过元数据设置不同的 key
+const HashKeyMetadataKey = "lrpc-hash-key"
+
+type hashKeyCtx struct{}
+
+// ContextWithHashKey 返回一个带有一致性哈希 key 的 ctx，只对使用这个 ctx 的调用生效
+func ContextWithHashKey(ctx context.Context, hashKey string) context.Context {
+	return context.WithValue(ctx, hashKeyCtx{}, hashKey)
+}
+
+// GetHashKey 返回这次调用一致性哈希使用的 key，优先级依次是 WithHashKey、ctx 中的 key、元数据中的 key
+func (o *Options) GetHashKey() string {
+	if o.HashKey != "" {
+		return o.HashKey
+	}
+	if o.Ctx != nil {
+		if hashKey, ok := o.Ctx.Value(hashKeyCtx{}).(string); ok && hashKey != "" {
+			return hashKey
+		}
+	}
+	return string(o.Metadata[HashKeyMetadataKey])
+}
+
 func init() {
 	RegisterSelector("default", DefaultSelector)
 }
@@ -30,11 +137,30 @@ func RegisterSelector(name string, selector Selector) {
 	selectorMap[name] = selector
 }
 
-func (d *defaultSelector) Select(serviceName string) (string, error) {
+func (d *defaultSelector) Select(serviceName string, opts ...Option) (string, error) {
 
 	return "", nil
 }
 
+// LegacySelector 是只根据服务名选择节点的旧版 Selector
+type LegacySelector interface {
+	Select(serviceName string) (string, error)
+}
+
+// AdaptLegacySelector 将旧版 Selector 包装成 Selector，调用参数会被忽略，
+// 比如 RegisterSelector("xxx", AdaptLegacySelector(s))
+func AdaptLegacySelector(s LegacySelector) Selector {
+	return &legacySelector{s}
+}
+
+type legacySelector struct {
+	s LegacySelector
+}
+
+func (l *legacySelector) Select(serviceName string, opts ...Option) (string, error) {
+	return l.s.Select(serviceName)
+}
+
 // GetSelector get a selector by a given selector name
 func GetSelector(name string) Selector {
 	if selector, ok := selectorMap[name]; ok {
@@ -44,7 +170,8 @@ func GetSelector(name string) Selector {
 }
 
 type Node struct {
-	Key    string
-	Value  []byte
-	Weight int
+	Key     string
+	Value   []byte
+	Weight  int
+	Address string // address of the node, e.g. 127.0.0.1:8000 or /tmp/lrpc.sock, Addr parses it from Key when empty
 }
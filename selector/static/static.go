@@ -0,0 +1,147 @@
+package static
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/junaozun/go-lrpxc/selector"
+	"github.com/junaozun/go-lrpxc/selector/loadbalance"
+)
+
+/*
+静态 Selector：服务节点是创建时给定的地址列表，比如 static://10.0.0.1:8000,10.0.0.2:8000;weight=2，
+地址之间用逗号分隔，地址后面可以用 ;weight=N 设置节点的权重，默认权重是 1。
+没有注册中心时可以用它在多个节点之间做负载均衡，所有服务名都使用同一组节点。
+*/
+
+// Scheme 是静态地址列表的前缀
+const Scheme = "static"
+
+const defaultWeight = 1
+
+// Options 静态 Selector 的参数
+type Options struct {
+	BalancerName string // load balance mode, random, roundRobin, weightedRoundRobin, consistentHash, p2c, leastConn
+}
+
+type Option func(*Options)
+
+func init() {
+	selector.RegisterBuilder(Scheme, build)
+}
+
+// build 根据 static://addr1,addr2?balancer=roundRobin 创建静态 Selector
+func build(target *selector.Target) (selector.Selector, error) {
+	addrs := target.Authority
+	if addrs == "" {
+		addrs = target.Endpoint
+	}
+
+	var opts []Option
+	if balancerName := target.Query.Get("balancer"); balancerName != "" {
+		opts = append(opts, WithBalancerName(balancerName))
+	}
+	return New(addrs, opts...)
+}
+
+// WithBalancerName 设置负载均衡方式，默认随机
+func WithBalancerName(balancerName string) Option {
+	return func(o *Options) {
+		o.BalancerName = balancerName
+	}
+}
+
+// Selector 从一组固定的节点中选择节点
+type Selector struct {
+	opts  *Options
+	nodes []*selector.Node
+}
+
+// New 根据地址列表创建一个静态 Selector，target 可以带 static:// 前缀
+func New(target string, opts ...Option) (*Selector, error) {
+	o := &Options{
+		BalancerName: loadbalance.Random,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	nodes, err := ParseNodes(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Selector{
+		opts:  o,
+		nodes: nodes,
+	}, nil
+}
+
+// ParseNodes 解析逗号分隔的地址列表，比如 static://10.0.0.1:8000,10.0.0.2:8000;weight=2
+func ParseNodes(target string) ([]*selector.Node, error) {
+	target = strings.TrimPrefix(target, Scheme+"://")
+
+	var nodes []*selector.Node
+	for _, s := range strings.Split(target, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		addr, weight := s, defaultWeight
+		if i := strings.Index(s, ";"); i >= 0 {
+			addr = s[:i]
+			w, err := parseWeight(s[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid static node %s : %v", s, err)
+			}
+			weight = w
+		}
+		if addr == "" {
+			return nil, fmt.Errorf("invalid static node %s : addr is empty", s)
+		}
+
+		nodes = append(nodes, &selector.Node{
+			Key:     addr,
+			Weight:  weight,
+			Address: addr,
+		})
+	}
+
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no static nodes in %s", target)
+	}
+	return nodes, nil
+}
+
+// parseWeight 解析 weight=N
+func parseWeight(query string) (int, error) {
+	kv := strings.SplitN(query, "=", 2)
+	if len(kv) != 2 || kv[0] != "weight" {
+		return 0, fmt.Errorf("unknown param %s", query)
+	}
+	w, err := strconv.Atoi(kv[1])
+	if err != nil {
+		return 0, err
+	}
+	if w <= 0 {
+		return 0, fmt.Errorf("weight must be positive")
+	}
+	return w, nil
+}
+
+// Select 通过负载均衡从静态节点中选出一个节点
+func (s *Selector) Select(serviceName string, opts ...selector.Option) (string, error) {
+	return loadbalance.Pick(s.opts.BalancerName, serviceName, s.nodes, opts...)
+}
+
+// Report 将调用结果反馈给 Balancer
+func (s *Selector) Report(serviceName string, info selector.DoneInfo) {
+	loadbalance.Report(s.opts.BalancerName, serviceName, info)
+}
+
+// Nodes 返回所有静态节点
+func (s *Selector) Nodes() []*selector.Node {
+	return s.nodes
+}
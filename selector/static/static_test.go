@@ -0,0 +1,76 @@
+package static
+
+import (
+	"testing"
+
+	"github.com/junaozun/go-lrpxc/selector"
+	"github.com/junaozun/go-lrpxc/selector/loadbalance"
+)
+
+func TestParseNodes(t *testing.T) {
+	nodes, err := ParseNodes("static://10.0.0.1:8000, 10.0.0.2:8000;weight=2,/tmp/lrpc.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		addr   string
+		weight int
+	}{
+		{"10.0.0.1:8000", 1},
+		{"10.0.0.2:8000", 2},
+		{"/tmp/lrpc.sock", 1},
+	}
+	if len(nodes) != len(want) {
+		t.Fatalf("got %d nodes, want %d", len(nodes), len(want))
+	}
+	for i, w := range want {
+		if nodes[i].Addr() != w.addr || nodes[i].Weight != w.weight {
+			t.Errorf("node %d = %s;weight=%d, want %s;weight=%d", i, nodes[i].Addr(), nodes[i].Weight, w.addr, w.weight)
+		}
+	}
+}
+
+func TestParseNodesInvalid(t *testing.T) {
+	for _, target := range []string{
+		"static://",
+		"10.0.0.1:8000;weight=0",
+		"10.0.0.1:8000;weight=x",
+		"10.0.0.1:8000;w=2",
+		";weight=2",
+	} {
+		if _, err := ParseNodes(target); err == nil {
+			t.Errorf("ParseNodes(%q) succeeded, want an error", target)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	s, err := New("static://10.0.0.1:8000,/tmp/lrpc.sock", WithBalancerName(loadbalance.RoundRobin))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]int)
+	for i := 0; i < 4; i++ {
+		addr, err := s.Select("helloworld.Greeter")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[addr]++
+	}
+	if got["10.0.0.1:8000"] != 2 || got["/tmp/lrpc.sock"] != 2 {
+		t.Fatalf("round robin selected %v, want each node twice", got)
+	}
+
+	// 被排除的节点不会被选中
+	for i := 0; i < 4; i++ {
+		addr, err := s.Select("helloworld.Greeter", selector.WithExclude("10.0.0.1:8000"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != "/tmp/lrpc.sock" {
+			t.Fatalf("selected %s, want the node that is not excluded", addr)
+		}
+	}
+}
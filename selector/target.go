@@ -0,0 +1,127 @@
+package selector
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Target 是解析后的 client target，格式是 scheme://authority/endpoint?query，比如
+// consul://127.0.0.1:8500/helloworld.Greeter?balancer=roundRobin 解析为
+// Scheme consul、Authority 127.0.0.1:8500、Endpoint helloworld.Greeter、Query balancer=roundRobin
+type Target struct {
+	Scheme    string     // e.g. : ip、unix、static、dns、file、consul
+	Authority string     // e.g. : address of the registry or the name server
+	Endpoint  string     // e.g. : service name, host:port or file path, without the leading "/"
+	Query     url.Values // e.g. : balancer=roundRobin、network=udp
+}
+
+// ParseTarget 解析 target，没有 scheme 的 target（比如 127.0.0.1:8000）整个作为 Endpoint
+func ParseTarget(target string) (*Target, error) {
+	t := &Target{Query: url.Values{}}
+
+	i := strings.Index(target, "://")
+	if i < 0 {
+		t.Endpoint = target
+		return t, nil
+	}
+	t.Scheme = target[:i]
+	if t.Scheme == "" {
+		return nil, fmt.Errorf("invalid target %s : scheme is empty", target)
+	}
+
+	rest := target[i+len("://"):]
+	if j := strings.Index(rest, "?"); j >= 0 {
+		query, err := url.ParseQuery(rest[j+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %s : %v", target, err)
+		}
+		t.Query = query
+		rest = rest[:j]
+	}
+
+	if j := strings.Index(rest, "/"); j >= 0 {
+		t.Authority, t.Endpoint = rest[:j], rest[j+1:]
+	} else {
+		t.Authority = rest
+	}
+
+	return t, nil
+}
+
+// Path 返回 unix:///tmp/x.sock、file:///etc/nodes.yaml 这类 target 中的路径
+func (t *Target) Path() string {
+	if t.Authority == "" {
+		return "/" + t.Endpoint
+	}
+	if t.Endpoint == "" {
+		return t.Authority
+	}
+	return t.Authority + "/" + t.Endpoint
+}
+
+// Builder 根据 target 创建 Selector
+type Builder func(target *Target) (Selector, error)
+
+var (
+	builderMap = make(map[string]Builder)
+
+	builtMu sync.Mutex
+	built   = make(map[string]Selector) // target -> selector
+)
+
+// RegisterBuilder 注册 scheme 对应的 Builder，client 的 target 使用这个 scheme 时通过它创建 Selector
+func RegisterBuilder(scheme string, builder Builder) {
+	builderMap[scheme] = builder
+}
+
+// GetBuilder 返回 scheme 对应的 Builder，没有注册时返回 nil
+func GetBuilder(scheme string) Builder {
+	return builderMap[scheme]
+}
+
+// BuildSelector 通过 target 的 scheme 对应的 Builder 创建 Selector。
+// 相同的 target 只创建一次，Selector 在后台刷新节点时不会因为每次调用都创建而泄漏
+func BuildSelector(target string) (Selector, error) {
+	builtMu.Lock()
+	defer builtMu.Unlock()
+
+	if s, ok := built[target]; ok {
+		return s, nil
+	}
+
+	t, err := ParseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	builder := GetBuilder(t.Scheme)
+	if builder == nil {
+		return nil, fmt.Errorf("unknown target scheme %s", t.Scheme)
+	}
+
+	s, err := builder(t)
+	if err != nil {
+		return nil, err
+	}
+	built[target] = s
+	return s, nil
+}
+
+// CloseSelectors 关闭所有通过 BuildSelector 创建并且实现了 io.Closer 的 Selector，比如停止后台刷新节点，返回第一个错误
+func CloseSelectors() error {
+	builtMu.Lock()
+	defer builtMu.Unlock()
+
+	var err error
+	for target, s := range built {
+		if c, ok := s.(io.Closer); ok {
+			if e := c.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+		delete(built, target)
+	}
+	return err
+}
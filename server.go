@@ -7,11 +7,22 @@ import (
 	"os"
 	"os/signal"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/junaozun/go-lrpxc/codec"
+	"github.com/junaozun/go-lrpxc/codes"
 	"github.com/junaozun/go-lrpxc/interceptor"
 	"github.com/junaozun/go-lrpxc/plugin"
 	"github.com/junaozun/go-lrpxc/plugin/jaeger"
+	"github.com/junaozun/go-lrpxc/protocol"
+	"github.com/junaozun/go-lrpxc/transport"
+	"github.com/junaozun/go-lrpxc/transport/server_transport"
+	"github.com/junaozun/go-lrpxc/utils"
 )
 
 /*
@@ -22,38 +33,37 @@ server 侧定义服务，发布服务，接收到服务的请求后，根据服
 */
 
 type Server struct {
-	opts    *ServerOptions  // 选项模型，用来透传业务自己指定的一些参数，比如服务监听的地址 address，网络类型 network 是 tcp 还是 udp，后端服务的超时时间 timeout 等。
-	service Service         // 每个 Service 表示一个服务，一个 server 可以发布多个服务，用服务名 serviceName 作 map 的 key
-	plugins []plugin.Plugin // Server 中添加 plugins 成员变量，它是一个插件数组。
-	closing bool            // whether the server is closing
+	opts      *ServerOptions            // 选项模型，用来透传业务自己指定的一些参数，比如服务监听的地址 address，网络类型 network 是 tcp 还是 udp，后端服务的超时时间 timeout 等。
+	services  map[string]Service        // 每个 Service 表示一个服务，一个 server 可以发布多个服务，用服务名 serviceName 作 map 的 key
+	plugins   []plugin.Plugin           // Server 中添加 plugins 成员变量，它是一个插件数组。
+	transport transport.ServerTransport // 所有 service 共用的 server transport
+	ctx       context.Context           // server 上下文，所有 service 共用一个监听
+	cancel    context.CancelFunc        // context 的控制器
+	closing   bool                      // whether the server is closing
+	stopOnce  sync.Once                 // plugins are stopped only once
 }
 
+const defaultShutdownTimeout = 10 * time.Second
+
 func NewServer(opt ...ServerOption) *Server {
 	s := &Server{
-		opts: &ServerOptions{},
+		opts:     &ServerOptions{},
+		services: make(map[string]Service),
 	}
 
-	s.service = NewService(s.opts)
-
 	for _, o := range opt {
 		o(s.opts)
 	}
-	// 当调用 server.New 函数时，遍历插件 PluginMap，将所有插件 Plugin 添加到 plugins 中去
-	for pluginName, plugin := range plugin.PluginMap {
+	// 当调用 server.New 函数时，按注册顺序遍历插件 PluginMap，将所有插件 Plugin 添加到 plugins 中去
+	for _, pluginName := range plugin.Names() {
 		if !containPlugin(pluginName, s.opts.pluginNames) {
 			continue
 		}
-		s.plugins = append(s.plugins, plugin)
+		s.plugins = append(s.plugins, plugin.PluginMap[pluginName])
 	}
 	return s
 }
 
-func NewService(opts *ServerOptions) Service {
-	return &service{
-		opts: opts,
-	}
-}
-
 func containPlugin(pluginName string, plugins []string) bool {
 	for _, plugin := range plugins {
 		if pluginName == plugin {
@@ -64,28 +74,180 @@ func containPlugin(pluginName string, plugins []string) bool {
 }
 
 func (s *Server) Serve() {
+	// 压缩算法名写错时启动失败，而不是悄悄地不压缩
+	if err := codec.CheckCompressor(s.opts.compressor); err != nil {
+		panic(err)
+	}
 	// 在调用 Server.Serve() 方法时，在 server 中的所有 service 提供服务之前，调用 InitPlugins 方法进行插件的配置初始化。
 	err := s.InitPlugins()
 	if err != nil {
 		panic(err)
 	}
-	// 遍历 service map 里面所有的 service，然后运行 service 的 Serve 方法
-	// for _, service := range s.service {
-	//	go service.Serve(s.opts)
-	// }
+	if err := plugin.Start(context.Background(), s.plugins...); err != nil {
+		panic(err)
+	}
+	// server 中所有的 service 共用一个 transport 监听，transport 收到请求后交给 Server.Handle 按服务名路由到对应的 service
+	transportOpts := []server_transport.ServerTransportOption{
+		server_transport.WithServerAddress(s.opts.address),
+		server_transport.WithServerNetwork(s.opts.network),
+		server_transport.WithHandler(s),
+		server_transport.WithServerTimeout(s.opts.timeout),
+		server_transport.WithSerializationType(s.opts.serializationType),
+		server_transport.WithProtocol(s.opts.protocol),
+		server_transport.WithCompressor(s.opts.compressor, s.opts.compressMinSize),
+		server_transport.WithHeartbeat(s.opts.heartbeatInterval, s.opts.maxMissedHeartbeats),
+	}
+
+	s.transport = server_transport.GetServerTransport(s.opts.protocol)
 
-	s.service.Serve(s.opts)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	if err := s.transport.ListenAndServe(s.ctx, transportOpts...); err != nil {
+		fmt.Printf("%s serve error, %v", s.opts.network, err)
+		return
+	}
+
+	fmt.Printf("%s server serving at %s, services : %v ... \n", s.opts.protocol, s.opts.address, s.serviceNames())
 
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGSEGV)
 	<-ch
 
+	timeout := s.opts.shutdownTimeout
+	if timeout == 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := s.Shutdown(ctx); err != nil {
+		fmt.Printf("server shutdown error, %v\n", err)
+	}
+}
+
+// Shutdown 优雅退出：transport 停止接收新的连接并关闭监听，等待正在处理的请求完成（最多等到 ctx 到期），
+// 然后将服务从服务发现插件中注销，最后关闭所有 service
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closing = true
+
+	var err error
+	if s.transport != nil {
+		err = s.transport.Shutdown(ctx)
+	}
+
+	if e := s.deregisterPlugins(); e != nil && err == nil {
+		err = e
+	}
+
+	if e := s.stopPlugins(ctx); e != nil && err == nil {
+		err = e
+	}
+
 	s.Close()
+
+	return err
+}
+
+func (s *Server) deregisterPlugins() error {
+	var err error
+	for _, p := range s.plugins {
+		val, ok := p.(plugin.Deregisterer)
+		if !ok {
+			continue
+		}
+
+		pluginOpts := []plugin.Option{
+			plugin.WithSvrAddr(s.opts.address),
+			plugin.WithServices(s.serviceNames()),
+		}
+		if e := val.Deregister(pluginOpts...); e != nil {
+			fmt.Printf("resolver deregister codes, %v\n", e)
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	return err
 }
 
 func (s *Server) Close() {
-	s.closing = false
-	s.service.Close()
+	s.closing = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	for _, service := range s.services {
+		service.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+	defer cancel()
+	if err := s.stopPlugins(ctx); err != nil {
+		fmt.Printf("plugins stop error, %v\n", err)
+	}
+}
+
+// stopPlugins 按注册的相反顺序停止插件，Shutdown 和 Close 都会调用，只有第一次调用生效
+func (s *Server) stopPlugins(ctx context.Context) error {
+	var err error
+	s.stopOnce.Do(func() {
+		err = plugin.Stop(ctx, s.plugins...)
+	})
+	return err
+}
+
+// Handle 实现了 server_transport.Handler，解析出请求的服务名 serviceName 和方法名 methodName，
+// 然后交给对应 service 的 Handle 去处理
+func (s *Server) Handle(ctx context.Context, reqbuf []byte) ([]byte, error) {
+
+	// parse protocol header
+	request := &protocol.Request{}
+	if err := proto.Unmarshal(reqbuf, request); err != nil {
+		return nil, err
+	}
+
+	serviceName, method, err := utils.ParseServicePath(request.ServicePath)
+	if err != nil {
+		return nil, codes.New(codes.ClientMsgErrorCode, "method is invalid")
+	}
+
+	service, ok := s.services[serviceName]
+	if !ok {
+		return nil, codes.New(codes.ClientMsgErrorCode, fmt.Sprintf("service %s not found", serviceName))
+	}
+
+	return service.Handle(ctx, request, method)
+}
+
+// HandleStream 和 Handle 一样按服务名路由，由对应 service 的 StreamHandler 处理流
+func (s *Server) HandleStream(ctx context.Context, reqbuf []byte, st server_transport.Stream) error {
+
+	// parse protocol header
+	request := &protocol.Request{}
+	if err := proto.Unmarshal(reqbuf, request); err != nil {
+		return err
+	}
+
+	serviceName, method, err := utils.ParseServicePath(request.ServicePath)
+	if err != nil {
+		return codes.New(codes.ClientMsgErrorCode, "method is invalid")
+	}
+
+	service, ok := s.services[serviceName]
+	if !ok {
+		return codes.New(codes.ClientMsgErrorCode, fmt.Sprintf("service %s not found", serviceName))
+	}
+
+	return service.HandleStream(ctx, request, method, st)
+}
+
+// serviceNames 返回 server 上注册的所有服务名
+func (s *Server) serviceNames() []string {
+	services := make([]string, 0, len(s.services))
+	for serviceName := range s.services {
+		services = append(services, serviceName)
+	}
+	sort.Strings(services)
+	return services
 }
 
 func (s *Server) InitPlugins() error {
@@ -95,14 +257,14 @@ func (s *Server) InitPlugins() error {
 		switch val := p.(type) {
 
 		case plugin.ResolverPlugin:
-			var services []string
-			services = append(services, s.service.Name())
+			services := s.serviceNames()
 
 			pluginOpts := []plugin.Option{
 				plugin.WithSelectorSvrAddr(s.opts.selectorSvrAddr),
 				plugin.WithSvrAddr(s.opts.address),
 				plugin.WithServices(services),
 			}
+			pluginOpts = append(pluginOpts, s.opts.pluginOpts...)
 			if err := val.Init(pluginOpts...); err != nil {
 				fmt.Printf("resolver init codes, %v", err)
 				return err
@@ -122,6 +284,19 @@ func (s *Server) InitPlugins() error {
 
 			s.opts.interceptors = append(s.opts.interceptors, jaeger.OpenTracingServerInterceptor(tracer, s.opts.tracingSpanName))
 
+		case plugin.Tracer:
+
+			pluginOpts := []plugin.Option{
+				plugin.WithTracingSvrAddr(s.opts.tracingSvrAddr),
+			}
+
+			if err := val.Init(pluginOpts...); err != nil {
+				fmt.Printf("tracing init codes, %v", err)
+				return err
+			}
+
+			s.opts.interceptors = append(s.opts.interceptors, val.ServerInterceptor(s.opts.tracingSpanName))
+
 		default:
 
 		}
@@ -214,17 +389,28 @@ func (s *Server) Register(sd *ServiceDesc, svr interface{}) {
 		log.Fatalf("handlerType %v not match service : %v ", ht, st)
 	}
 
+	// 服务名统一去掉前缀 "/"，与 utils.ParseServicePath 解析出的服务名保持一致
+	serviceName := strings.TrimPrefix(sd.ServiceName, "/")
+	if _, ok := s.services[serviceName]; ok {
+		log.Fatalf("service %s already registered", serviceName)
+	}
+
 	ser := &service{
 		svr:         svr,
-		serviceName: sd.ServiceName,
+		serviceName: serviceName,
 		handlers:    make(map[string]Handler),
+		opts:        s.opts,
 	}
 
 	for _, method := range sd.Methods {
 		ser.handlers[method.MethodName] = method.Handler
 	}
 
-	s.service = ser
+	for _, stream := range sd.Streams {
+		ser.RegisterStream(stream.StreamName, stream.Handler)
+	}
+
+	s.services[serviceName] = ser
 }
 
 func checkMethod(method reflect.Type) error {
@@ -4,20 +4,27 @@ import (
 	"time"
 
 	"github.com/junaozun/go-lrpxc/interceptor"
+	"github.com/junaozun/go-lrpxc/plugin"
 )
 
 // ServerOptions defines the server serve parameters
 type ServerOptions struct {
-	address           string        // listening address, e.g. :( ip://127.0.0.1:8080、 dns://www.google.com)
-	network           string        // network type, e.g. : tcp、udp
-	protocol          string        // protocol type, e.g. : proto、json
-	timeout           time.Duration // timeout
-	serializationType string        // serialization type, default: proto
-
-	selectorSvrAddr string   // service discovery server address, required when using the third-party service discovery plugin
-	tracingSvrAddr  string   // tracing plugin server address, required when using the third-party tracing plugin
-	tracingSpanName string   // tracing span name, required when using the third-party tracing plugin
-	pluginNames     []string // plugin name
+	address             string        // listening address, e.g. :( ip://127.0.0.1:8080、 dns://www.google.com)
+	network             string        // network type, e.g. : tcp、udp
+	protocol            string        // protocol type, e.g. : proto、json
+	timeout             time.Duration // timeout
+	serializationType   string        // serialization type, default: proto
+	shutdownTimeout     time.Duration // max time to wait for in-flight requests when shutting down, default: 10s
+	compressor          string        // compressor name of responses, e.g. : gzip、zlib、snappy、zstd, default: no compression
+	compressMinSize     int           // responses smaller than compressMinSize are not compressed
+	heartbeatInterval   time.Duration // the interval that clients send heartbeats, 0 means no heartbeat check
+	maxMissedHeartbeats int           // close the connection after missing maxMissedHeartbeats heartbeats
+
+	selectorSvrAddr string          // service discovery server address, required when using the third-party service discovery plugin
+	tracingSvrAddr  string          // tracing plugin server address, required when using the third-party tracing plugin
+	tracingSpanName string          // tracing span name, required when using the third-party tracing plugin
+	pluginNames     []string        // plugin name
+	pluginOpts      []plugin.Option // options passed to the plugins when they are initialized, e.g. tags、weight、health check
 	interceptors    []interceptor.ServerInterceptor
 }
 
@@ -47,6 +54,32 @@ func WithTimeout(timeout time.Duration) ServerOption {
 	}
 }
 
+func WithShutdownTimeout(timeout time.Duration) ServerOption {
+	return func(o *ServerOptions) {
+		o.shutdownTimeout = timeout
+	}
+}
+
+func WithCompressor(compressor string) ServerOption {
+	return func(o *ServerOptions) {
+		o.compressor = compressor
+	}
+}
+
+func WithCompressMinSize(size int) ServerOption {
+	return func(o *ServerOptions) {
+		o.compressMinSize = size
+	}
+}
+
+// WithHeartbeat 开启心跳检查，client 连续 maxMissed 个心跳周期 interval 没有发送任何帧时，server 关闭连接
+func WithHeartbeat(interval time.Duration, maxMissed int) ServerOption {
+	return func(o *ServerOptions) {
+		o.heartbeatInterval = interval
+		o.maxMissedHeartbeats = maxMissed
+	}
+}
+
 func WithSerializationType(serializationType string) ServerOption {
 	return func(o *ServerOptions) {
 		o.serializationType = serializationType
@@ -65,6 +98,13 @@ func WithPlugin(pluginName ...string) ServerOption {
 	}
 }
 
+// WithPluginOptions 设置插件初始化时的参数，比如服务发现插件注册服务实例时的标签、权重和健康检查
+func WithPluginOptions(opts ...plugin.Option) ServerOption {
+	return func(o *ServerOptions) {
+		o.pluginOpts = append(o.pluginOpts, opts...)
+	}
+}
+
 func WithTracingSvrAddr(addr string) ServerOption {
 	return func(o *ServerOptions) {
 		o.tracingSvrAddr = addr
@@ -76,3 +116,10 @@ func WithTracingSpanName(name string) ServerOption {
 		o.tracingSpanName = name
 	}
 }
+
+// WithInterceptor 添加 server 拦截器，拦截器按添加的顺序执行，e.g. WithInterceptor(metrics.ServerInterceptor())
+func WithInterceptor(interceptors ...interceptor.ServerInterceptor) ServerOption {
+	return func(o *ServerOptions) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
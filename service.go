@@ -5,32 +5,31 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/golang/protobuf/proto"
-	"github.com/junaozun/go-lrpxc/codes"
 	"github.com/junaozun/go-lrpxc/interceptor"
 	"github.com/junaozun/go-lrpxc/metadata"
 	"github.com/junaozun/go-lrpxc/protocol"
 	"github.com/junaozun/go-lrpxc/serialization"
+	"github.com/junaozun/go-lrpxc/stream"
 	"github.com/junaozun/go-lrpxc/transport/server_transport"
-	"github.com/junaozun/go-lrpxc/utils"
 )
 
-// Service 的接口定义了每个服务需要提供的通用能力，包括 Register （处理函数 Handler 的注册）、提供服务 Serve，服务关闭 Close 等方法
+// Service 的接口定义了每个服务需要提供的通用能力，包括 Register （处理函数 Handler 的注册）、处理请求 Handle，服务关闭 Close 等方法
 type Service interface {
 	Register(string, Handler)
-	Serve(*ServerOptions)
+	RegisterStream(string, StreamHandler)
+	Handle(context.Context, *protocol.Request, string) ([]byte, error)
+	HandleStream(context.Context, *protocol.Request, string, server_transport.Stream) error
 	Close()
 	Name() string
 }
 
 // 是 Service 接口的具体实现。它的核心是 handlers 这个 map，每一类请求会分配一个 Handler 进行处理
 type service struct {
-	svr         interface{}        // server
-	ctx         context.Context    // 每一个 service 一个上下文进行管理
-	cancel      context.CancelFunc // context 的控制器
-	serviceName string             // 服务名
-	handlers    map[string]Handler // 方法名：Handler
-	opts        *ServerOptions     // 参数选项
+	svr         interface{}              // server
+	serviceName string                   // 服务名
+	handlers    map[string]Handler       // 方法名：Handler
+	streams     map[string]StreamHandler // 流式方法名：StreamHandler
+	opts        *ServerOptions           // 参数选项
 
 	closing bool // whether the service is closing
 }
@@ -40,6 +39,7 @@ type ServiceDesc struct {
 	Svr         interface{}
 	ServiceName string
 	Methods     []*MethodDesc
+	Streams     []*StreamDesc
 	HandlerType interface{}
 }
 
@@ -49,9 +49,20 @@ type MethodDesc struct {
 	Handler    Handler
 }
 
+// StreamDesc is a detailed description of a streaming method
+type StreamDesc struct {
+	StreamName    string
+	Handler       StreamHandler
+	ServerStreams bool // the server sends a stream of messages
+	ClientStreams bool // the client sends a stream of messages
+}
+
 // Handler is the handler of a method
 type Handler func(context.Context, interface{}, func(interface{}) error, []interceptor.ServerInterceptor) (interface{}, error)
 
+// StreamHandler is the handler of a streaming method
+type StreamHandler func(svr interface{}, stream ServerStream) error
+
 func (s *service) Register(handlerName string, handler Handler) {
 	if s.handlers == nil {
 		s.handlers = make(map[string]Handler)
@@ -59,55 +70,27 @@ func (s *service) Register(handlerName string, handler Handler) {
 	s.handlers[handlerName] = handler
 }
 
-// 构建 transport ，监听客户端请求，根据请求的服务名 serviceName 和请求的方法名 methodName
-// 调用相应的 handler 去处理请求，然后进行回包。
-func (s *service) Serve(opts *ServerOptions) {
-	s.opts = opts
-
-	transportOpts := []server_transport.ServerTransportOption{
-		server_transport.WithServerAddress(s.opts.address),
-		server_transport.WithServerNetwork(s.opts.network),
-		server_transport.WithHandler(s),
-		server_transport.WithServerTimeout(s.opts.timeout),
-		server_transport.WithSerializationType(s.opts.serializationType),
-		server_transport.WithProtocol(s.opts.protocol),
-	}
-
-	serverTransport := server_transport.GetServerTransport(s.opts.protocol)
-
-	s.ctx, s.cancel = context.WithCancel(context.Background())
-
-	if err := serverTransport.ListenAndServe(s.ctx, transportOpts...); err != nil {
-		fmt.Printf("%s serve error, %v", s.opts.network, err)
-		return
+func (s *service) RegisterStream(streamName string, handler StreamHandler) {
+	if s.streams == nil {
+		s.streams = make(map[string]StreamHandler)
 	}
-
-	fmt.Printf("%s service serving at %s ... \n", s.opts.protocol, s.opts.address)
-
-	<-s.ctx.Done()
+	s.streams[streamName] = handler
 }
 
 func (s *service) Close() {
 	s.closing = true
-	if s.cancel != nil {
-		s.cancel()
-	}
-	fmt.Println("service closing ...")
+	fmt.Printf("service %s closing ...\n", s.serviceName)
 }
 
 func (s *service) Name() string {
 	return s.serviceName
 }
 
-func (s *service) Handle(ctx context.Context, reqbuf []byte) ([]byte, error) {
-
-	// parse protocol header
-	request := &protocol.Request{}
-	if err := proto.Unmarshal(reqbuf, request); err != nil {
-		return nil, err
-	}
+// Handle 根据请求的方法名 methodName 调用相应的 handler 去处理请求，request 已经由 Server 按服务名 serviceName 路由到当前 service
+func (s *service) Handle(ctx context.Context, request *protocol.Request, method string) ([]byte, error) {
 
 	ctx = metadata.WithServerMetadata(ctx, request.Metadata)
+	ctx = s.withServerStream(ctx, method)
 
 	serverSerialization := serialization.GetSerialization(s.opts.serializationType)
 
@@ -125,11 +108,6 @@ func (s *service) Handle(ctx context.Context, reqbuf []byte) ([]byte, error) {
 		defer cancel()
 	}
 
-	_, method, err := utils.ParseServicePath(string(request.ServicePath))
-	if err != nil {
-		return nil, codes.New(codes.ClientMsgErrorCode, "method is invalid")
-	}
-
 	handler := s.handlers[method]
 	if handler == nil {
 		return nil, errors.New("handlers is nil")
@@ -147,3 +125,30 @@ func (s *service) Handle(ctx context.Context, reqbuf []byte) ([]byte, error) {
 
 	return rspbuf, nil
 }
+
+// HandleStream 根据流式方法名 method 调用相应的 StreamHandler 处理一个流，request 是建立流时的包头。
+// 流的生命周期由 client 决定，server 的超时时间不作用于流
+func (s *service) HandleStream(ctx context.Context, request *protocol.Request, method string, st server_transport.Stream) error {
+
+	handler := s.streams[method]
+	if handler == nil {
+		return errors.New("stream handler is nil")
+	}
+
+	ctx = metadata.WithServerMetadata(ctx, request.Metadata)
+	ctx = s.withServerStream(ctx, method)
+
+	return handler(s.svr, &serverStream{
+		ctx:           ctx,
+		stream:        st,
+		serialization: serialization.GetSerialization(s.opts.serializationType),
+	})
+}
+
+// withServerStream 为请求创建一个带有服务名、方法名和 client 地址的 ServerStream，拦截器可以从 ctx 中获取
+func (s *service) withServerStream(ctx context.Context, method string) context.Context {
+	ss := stream.GetServerStream2(ctx).Clone()
+	ss.ServiceName = s.serviceName
+	ss.WithMethod(method)
+	return stream.WithServerStream(ctx, ss)
+}
@@ -0,0 +1,52 @@
+package github
+
+import (
+	"context"
+
+	"github.com/junaozun/go-lrpxc/protocol"
+	"github.com/junaozun/go-lrpxc/serialization"
+	"github.com/junaozun/go-lrpxc/transport/server_transport"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// ServerStream 是流式方法在 server 端的流，消息使用 server 配置的序列化方式编解码
+type ServerStream interface {
+	Context() context.Context
+	// SendMsg 发送一条消息给 client
+	SendMsg(m interface{}) error
+	// RecvMsg 接收 client 的一条消息，client 结束发送后返回 io.EOF
+	RecvMsg(m interface{}) error
+}
+
+type serverStream struct {
+	ctx           context.Context
+	stream        server_transport.Stream
+	serialization serialization.Serialization
+}
+
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *serverStream) SendMsg(m interface{}) error {
+	payload, err := ss.serialization.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return ss.stream.Send(payload)
+}
+
+func (ss *serverStream) RecvMsg(m interface{}) error {
+	reqbuf, err := ss.stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	request := &protocol.Request{}
+	if err = proto.Unmarshal(reqbuf, request); err != nil {
+		return err
+	}
+
+	return ss.serialization.Unmarshal(request.Payload, m)
+}
@@ -3,10 +3,12 @@ package stream
 import "context"
 
 type ServerStream struct {
-	ctx     context.Context
-	Method  string // 方法名
-	RetCode uint32 // 返回码 0—成功 非0-失败
-	RetMsg  string // 返回信息 OK-成功，失败返回具体信息
+	ctx         context.Context
+	ServiceName string // 服务名
+	Method      string // 方法名
+	RemoteAddr  string // client 的地址
+	RetCode     uint32 // 返回码 0—成功 非0-失败
+	RetMsg      string // 返回信息 OK-成功，失败返回具体信息
 }
 
 const ServerStreamKey = StreamContextKey("GORPC_SERVER_STREAM")
@@ -39,10 +41,18 @@ func (ss *ServerStream) WithMethod(method string) *ServerStream {
 
 func (ss *ServerStream) Clone() *ServerStream {
 	return &ServerStream{
-		Method: ss.Method,
+		ServiceName: ss.ServiceName,
+		Method:      ss.Method,
+		RemoteAddr:  ss.RemoteAddr,
 	}
 }
 
+// WithServerStream 将 ss 放进 ctx，连接上的每个请求使用自己的 ServerStream，避免并发的请求互相覆盖
+func WithServerStream(ctx context.Context, ss *ServerStream) context.Context {
+	ss.ctx = ctx
+	return context.WithValue(ctx, ServerStreamKey, ss)
+}
+
 func NewServerStream(ctx context.Context) (context.Context, *ServerStream) {
 	var ss *ServerStream
 	v := ctx.Value(ServerStreamKey)
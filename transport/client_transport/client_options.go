@@ -5,16 +5,27 @@ import (
 
 	"github.com/junaozun/go-lrpxc/pool/connpool"
 	"github.com/junaozun/go-lrpxc/selector"
+	"github.com/junaozun/go-lrpxc/selector/circuitbreaker"
+	"github.com/junaozun/go-lrpxc/selector/outlier"
 )
 
 // ClientTransportOptions includes all ClientTransport parameter options
 type ClientTransportOptions struct {
 	Target      string
 	ServiceName string
+	Method      string
 	Network     string
 	Pool        connpool.Pool
 	Selector    selector.Selector
 	Timeout     time.Duration
+	HashKey     string                  // hash key of the consistent hash balancer
+	Breaker     *circuitbreaker.Breaker // per-node circuit breaker, nil means no circuit breaker
+	// outlier detector fed with the result of each request, nil means no outlier detection
+	OutlierDetector *outlier.Detector
+	Multiplexed     bool // whether concurrent requests share one connection per address
+	// heartbeat of multiplexed connections, heartbeats of pooled connections are configured on the Pool
+	HeartbeatInterval   time.Duration // interval of sending heartbeats on idle connections, 0 means no heartbeat
+	MaxMissedHeartbeats int           // close the connection after missing MaxMissedHeartbeats heartbeats
 }
 
 // Use the Options mode to wrap the ClientTransportOptions
@@ -27,6 +38,13 @@ func WithServiceName(serviceName string) ClientTransportOption {
 	}
 }
 
+// WithMethod returns a ClientTransportOption which sets the value for method
+func WithMethod(method string) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.Method = method
+	}
+}
+
 // WithClientTarget returns a ClientTransportOption which sets the value for target
 func WithClientTarget(target string) ClientTransportOption {
 	return func(o *ClientTransportOptions) {
@@ -61,3 +79,39 @@ func WithTimeout(timeout time.Duration) ClientTransportOption {
 		o.Timeout = timeout
 	}
 }
+
+// WithMultiplexed returns a ClientTransportOption which sets the value for multiplexed
+func WithMultiplexed(multiplexed bool) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.Multiplexed = multiplexed
+	}
+}
+
+// WithHeartbeat returns a ClientTransportOption which sets the value for heartbeatInterval and maxMissedHeartbeats
+func WithHeartbeat(interval time.Duration, maxMissed int) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.HeartbeatInterval = interval
+		o.MaxMissedHeartbeats = maxMissed
+	}
+}
+
+// WithHashKey returns a ClientTransportOption which sets the value for hashKey
+func WithHashKey(hashKey string) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.HashKey = hashKey
+	}
+}
+
+// WithBreaker returns a ClientTransportOption which sets the value for breaker
+func WithBreaker(breaker *circuitbreaker.Breaker) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.Breaker = breaker
+	}
+}
+
+// WithOutlierDetector returns a ClientTransportOption which sets the value for outlierDetector
+func WithOutlierDetector(detector *outlier.Detector) ClientTransportOption {
+	return func(o *ClientTransportOptions) {
+		o.OutlierDetector = detector
+	}
+}
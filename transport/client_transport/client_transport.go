@@ -2,13 +2,30 @@ package client_transport
 
 import (
 	"context"
+	"errors"
+	"net"
+	"time"
 
+	"github.com/junaozun/go-lrpxc/codec"
 	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/metadata"
+	"github.com/junaozun/go-lrpxc/selector"
 	"github.com/junaozun/go-lrpxc/transport"
 )
 
+// Stream 是多路复用连接上的一个流，流式调用的所有帧都通过同一个流收发
+type Stream interface {
+	// Send 发送一个完整的帧，帧头中的流 ID 会被替换成这个流的流 ID
+	Send([]byte) error
+	// Recv 按顺序返回流上收到的帧
+	Recv(context.Context) ([]byte, error)
+	// Close 释放这个流
+	Close()
+}
+
 type clientTransport struct {
 	opts *ClientTransportOptions
+	mux  *muxPool // multiplexed connections, shared by all requests
 }
 
 var clientTransportMap = make(map[string]transport.ClientTransport)
@@ -42,69 +59,262 @@ var DefaultClientTransport = New()
 var New = func() transport.ClientTransport {
 	return &clientTransport{
 		opts: &ClientTransportOptions{},
+		mux:  newMuxPool(),
 	}
 }
 
 func (c *clientTransport) Send(ctx context.Context, req []byte, opts ...ClientTransportOption) ([]byte, error) {
 
+	// the ClientTransport is shared by concurrent requests, opts only take effect on this request
+	c = c.clone()
 	for _, o := range opts {
 		o(c.opts)
 	}
 
-	if c.opts.Network == "tcp" {
+	switch c.opts.Network {
+	case "tcp", "tcp4", "tcp6", "unix":
 		return c.SendTcpReq(ctx, req)
+	case "udp", "udp4", "udp6":
+		return c.SendUdpReq(ctx, req)
+	default:
+		return nil, codes.NetworkNotSupportedError
 	}
+}
 
-	if c.opts.Network == "udp" {
-		return c.SendUdpReq(ctx, req)
+// NewStream 流式调用总是使用多路复用连接，只支持 tcp 和 unix socket
+func (c *clientTransport) NewStream(ctx context.Context, opts ...ClientTransportOption) (Stream, error) {
+
+	c = c.clone()
+	for _, o := range opts {
+		o(c.opts)
 	}
 
-	return nil, codes.NetworkNotSupportedError
-}
+	switch c.opts.Network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return nil, codes.NetworkNotSupportedError
+	}
 
-func (c *clientTransport) SendTcpReq(ctx context.Context, req []byte) ([]byte, error) {
+	addr, err := c.selectAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	// service discovery
-	addr, err := c.opts.Selector.Select(c.opts.ServiceName)
+	mc, err := c.mux.get(ctx, c.opts.Network, addr, c.opts.HeartbeatInterval, c.opts.MaxMissedHeartbeats)
 	if err != nil {
 		return nil, err
 	}
 
+	return mc.newStream()
+}
+
+// selectAddress 通过服务发现选出下游地址，调用的 ctx、方法名和元数据会传给 Selector
+func (c *clientTransport) selectAddress(ctx context.Context) (string, error) {
+	opts := []selector.Option{
+		selector.WithContext(ctx),
+		selector.WithMethod(c.opts.Method),
+		selector.WithMetadata(metadata.ClientMetadata(ctx)),
+		selector.WithHashKey(c.opts.HashKey),
+	}
+	if c.opts.Breaker != nil {
+		opts = append(opts, selector.WithBreaker(c.opts.Breaker))
+	}
+	if c.opts.OutlierDetector != nil {
+		opts = append(opts, selector.WithFilters(c.opts.OutlierDetector))
+	}
+
+	addr, err := c.opts.Selector.Select(c.opts.ServiceName, opts...)
+	if err != nil {
+		return "", err
+	}
+
 	// defaultSelector returns "", use the target as address
 	if addr == "" {
 		addr = c.opts.Target
 	}
 
+	return addr, nil
+}
+
+// SendTcpReq 发送请求帧并等待回包，请求帧完整写到连接上之前发生的错误用 codes.NotSent 标记
+func (c *clientTransport) SendTcpReq(ctx context.Context, req []byte) ([]byte, error) {
+
+	// service discovery
+	addr, err := c.selectAddress(ctx)
+	if err != nil {
+		return nil, codes.NotSent(err)
+	}
+
+	// 熔断中的节点直接返回错误，请求的结果会被熔断器统计
+	var done func(error)
+	if c.opts.Breaker != nil {
+		if done, err = c.opts.Breaker.Acquire(addr); err != nil {
+			return nil, codes.NotSent(err)
+		}
+	}
+
+	selector.DefaultInflight.Add(addr, 1)
+	start := time.Now()
+	rsp, err := c.sendTcpReq(ctx, addr, req)
+	selector.DefaultInflight.Add(addr, -1)
+	// 被中断的请求也要调用 done 释放半开状态的探测名额，熔断器不统计 ctx 的错误
+	if done != nil {
+		done(err)
+	}
+	// 因为 ctx 结束而中断的请求，比如对冲请求中较慢的请求，不能说明节点的状态，不反馈给 Selector 和异常节点检测器
+	if !aborted(err) {
+		c.report(addr, start, err)
+	}
+
+	return rsp, err
+}
+
+// report 将调用的结果反馈给实现了 selector.Reporter 的 Selector 和异常节点检测器
+func (c *clientTransport) report(addr string, start time.Time, err error) {
+	info := selector.DoneInfo{
+		Addr:    addr,
+		Err:     err,
+		Latency: time.Since(start),
+	}
+	if r, ok := c.opts.Selector.(selector.Reporter); ok {
+		r.Report(c.opts.ServiceName, info)
+	}
+	if c.opts.OutlierDetector != nil {
+		c.opts.OutlierDetector.Report(c.opts.ServiceName, info)
+	}
+}
+
+// sendTcpReq 通过多路复用连接或者连接池中的连接向 addr 发送请求帧
+func (c *clientTransport) sendTcpReq(ctx context.Context, addr string, req []byte) ([]byte, error) {
+	if c.opts.Multiplexed {
+		mc, err := c.mux.get(ctx, c.opts.Network, addr, c.opts.HeartbeatInterval, c.opts.MaxMissedHeartbeats)
+		if err != nil {
+			return nil, codes.NotSent(ctxErr(ctx, err))
+		}
+		if isOneWay(req) {
+			return nil, codes.NotSent(ctxErr(ctx, mc.write(req)))
+		}
+		rsp, err := mc.roundTrip(ctx, req)
+		return rsp, ctxErr(ctx, err)
+	}
+
 	conn, err := c.opts.Pool.Get(ctx, c.opts.Network, addr)
 	//	conn, err := net.DialTimeout("tcp", addr, c.opts.Timeout);
 	if err != nil {
-		return nil, err
+		return nil, codes.NotSent(ctxErr(ctx, err))
 	}
 
 	defer conn.Close()
 
+	// 读写都受 ctx 的超时时间限制，超时的连接会被关闭，不会放回连接池
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// ctx 被取消时中断读写，比如对冲请求中较慢的请求会被取消
+	defer interruptOnDone(ctx, conn)()
+
 	sendNum := 0
 	num := 0
 	for sendNum < len(req) {
 		num, err = conn.Write(req[sendNum:])
 		if err != nil {
-			return nil, err
+			return nil, codes.NotSent(ctxErr(ctx, err))
 		}
 		sendNum += num
 
 		if err = isDone(ctx); err != nil {
+			if sendNum < len(req) {
+				return nil, codes.NotSent(err)
+			}
 			return nil, err
 		}
 	}
 
-	// parse frame
+	if isOneWay(req) {
+		return nil, nil
+	}
+
+	// parse frame, skip the late heartbeat responses of the pool's heartbeat check
 	wrapperConn := transport.WrapConn(conn)
-	frame, err := wrapperConn.Framer.ReadFrame(conn)
-	if err != nil {
-		return nil, err
+	for {
+		frame, err := wrapperConn.Framer.ReadFrame(conn)
+		if err != nil {
+			return nil, ctxErr(ctx, err)
+		}
+
+		header, err := codec.ParseFrameHeader(frame)
+		if err != nil {
+			return nil, err
+		}
+		if header.MsgType != codec.MsgTypeHeartbeat {
+			return frame, nil
+		}
 	}
+}
+
+func (c *clientTransport) clone() *clientTransport {
+	opts := *c.opts
+	return &clientTransport{
+		opts: &opts,
+		mux:  c.mux,
+	}
+}
+
+// interruptOnDone ctx 结束时让 conn 上阻塞的读写立即返回，返回的函数用于停止监听，
+// 需要在连接放回连接池之前调用
+func interruptOnDone(ctx context.Context, conn net.Conn) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-exited
+	}
+}
+
+// isOneWay 只发不收的请求写完请求帧后直接返回，server 不会回包
+func isOneWay(req []byte) bool {
+	header, err := codec.ParseFrameHeader(req)
+	return err == nil && header.ReqType == codec.ReqTypeSendOnly
+}
+
+// ctxErr 在 ctx 已经结束时返回 ctx.Err()，并保留 codes.NotSent 的标记。ctx 结束时 interruptOnDone 让读写返回 i/o timeout，
+// 这个错误是调用方造成的，不能被当成节点的网络错误
+func ctxErr(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	cerr := ctx.Err()
+	// 连接的读写 deadline 就是 ctx 的 deadline，读写超时可能比 ctx 的超时先返回
+	if deadline, ok := ctx.Deadline(); cerr == nil && ok && !time.Now().Before(deadline) {
+		cerr = context.DeadlineExceeded
+	}
+	if cerr == nil {
+		return err
+	}
+
+	if codes.IsNotSent(err) {
+		return codes.NotSent(cerr)
+	}
+	return cerr
+}
 
-	return frame, err
+// aborted 判断请求是否因为 ctx 被取消或者超时而中断，这样的错误都被 ctxErr 换成了 ctx 的错误
+func aborted(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
 }
 
 func isDone(ctx context.Context) error {
@@ -0,0 +1,314 @@
+package client_transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/codec"
+	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/metrics"
+	"github.com/junaozun/go-lrpxc/transport"
+)
+
+/*
+多路复用：连接池模式下一条连接同一时刻只能承载一个请求，请求发出后需要独占连接等待回包。多路复用模式下，
+同一个地址的所有并发请求共用一条 tcp 连接，每个请求分配一个流 ID 写在帧头的 StreamID 中，
+server 回包时带回这个流 ID，client 由一个读协程读取回包，根据流 ID 分发给对应的请求。
+流式调用同样建立在多路复用连接上，一个流占用一个流 ID，流上的所有帧按顺序放入这个流的队列。
+*/
+
+var errMuxConnClosed = codes.NewFrameworkError(codes.NetworkErrorCode, "multiplexed connection closed ...")
+var errStreamIDExhausted = errors.New("no stream id available on multiplexed connection ...")
+var errHeartbeatTimeout = codes.NewFrameworkError(codes.NetworkErrorCode, "multiplexed connection heartbeat timeout ...")
+
+const defaultMuxDialTimeout = 200 * time.Millisecond
+
+// muxPool 管理每个地址对应的多路复用连接
+type muxPool struct {
+	mu      sync.Mutex
+	conns   map[string]*muxConn // address -> muxConn
+	pending map[string]*muxDial // address -> dial in progress
+}
+
+// muxDial 是一次正在进行的建连，同一个地址的并发请求等待同一次建连的结果
+type muxDial struct {
+	done chan struct{} // closed when the dial is finished
+	mc   *muxConn
+	err  error
+}
+
+func newMuxPool() *muxPool {
+	return &muxPool{
+		conns:   make(map[string]*muxConn),
+		pending: make(map[string]*muxDial),
+	}
+}
+
+// get returns the multiplexed connection of address, dials a new one if there is none or the old one is broken.
+// the dial happens outside the lock, so a slow dial only blocks the requests to the same address, and
+// concurrent requests to the same address share one dial.
+// heartbeatInterval and maxMissedHeartbeats only take effect when a new connection is dialed
+func (p *muxPool) get(ctx context.Context, network string, address string,
+	heartbeatInterval time.Duration, maxMissedHeartbeats int) (*muxConn, error) {
+	p.mu.Lock()
+
+	if mc, ok := p.conns[address]; ok && !mc.isClosed() {
+		p.mu.Unlock()
+		return mc, nil
+	}
+
+	if d, ok := p.pending[address]; ok {
+		p.mu.Unlock()
+		select {
+		case <-d.done:
+			return d.mc, d.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	d := &muxDial{
+		done: make(chan struct{}),
+	}
+	p.pending[address] = d
+	p.mu.Unlock()
+
+	dialer := &net.Dialer{
+		Timeout: defaultMuxDialTimeout,
+	}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		d.err = err
+	} else {
+		d.mc = newMuxConn(conn, heartbeatInterval, maxMissedHeartbeats)
+	}
+
+	p.mu.Lock()
+	delete(p.pending, address)
+	if d.mc != nil {
+		p.conns[address] = d.mc
+	}
+	p.mu.Unlock()
+	close(d.done)
+
+	return d.mc, d.err
+}
+
+// muxConn 是一条多路复用连接
+type muxConn struct {
+	conn    *transport.ConnWrapper
+	writeMu sync.Mutex // serializes frame writes
+
+	mu      sync.Mutex
+	streams map[uint16]*transport.FrameQueue // stream id -> frames received on the stream
+	lastID  uint16                           // last allocated stream id
+	err     error                            // the reason why the connection is closed
+	done    chan struct{}                    // closed when the connection is closed
+
+	lastActive int64 // unix nano of the last frame written or read
+	missed     int32 // number of consecutive heartbeats without any frame read
+}
+
+func newMuxConn(conn net.Conn, heartbeatInterval time.Duration, maxMissedHeartbeats int) *muxConn {
+	mc := &muxConn{
+		conn:       transport.WrapConn(conn),
+		streams:    make(map[uint16]*transport.FrameQueue),
+		done:       make(chan struct{}),
+		lastActive: time.Now().UnixNano(),
+	}
+	metrics.GetSink("default").AddGauge(metrics.ClientMuxConnections, mc.labels(), 1)
+
+	go mc.readLoop()
+
+	if heartbeatInterval > 0 && maxMissedHeartbeats > 0 {
+		go mc.heartbeatLoop(heartbeatInterval, maxMissedHeartbeats)
+	}
+
+	return mc
+}
+
+// newStream 分配一个未被占用的流 ID，流 ID 0 保留给非多路复用的请求和心跳
+func (mc *muxConn) newStream() (*muxStream, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.err != nil {
+		return nil, mc.err
+	}
+
+	for i := 0; i < 1<<16; i++ {
+		mc.lastID++
+		if mc.lastID == 0 {
+			continue
+		}
+		if _, ok := mc.streams[mc.lastID]; ok {
+			continue
+		}
+		queue := transport.NewFrameQueue()
+		mc.streams[mc.lastID] = queue
+		return &muxStream{id: mc.lastID, mc: mc, queue: queue}, nil
+	}
+
+	return nil, errStreamIDExhausted
+}
+
+func (mc *muxConn) closeStream(id uint16) {
+	mc.mu.Lock()
+	delete(mc.streams, id)
+	mc.mu.Unlock()
+}
+
+// roundTrip 在多路复用连接上发送一个请求帧，并等待流 ID 对应的回包帧，请求帧发出之前的错误用 codes.NotSent 标记
+func (mc *muxConn) roundTrip(ctx context.Context, req []byte) ([]byte, error) {
+	st, err := mc.newStream()
+	if err != nil {
+		return nil, codes.NotSent(err)
+	}
+	defer st.Close()
+
+	if err = st.Send(req); err != nil {
+		return nil, codes.NotSent(err)
+	}
+
+	return st.Recv(ctx)
+}
+
+func (mc *muxConn) write(frame []byte) error {
+	mc.writeMu.Lock()
+	defer mc.writeMu.Unlock()
+
+	sendNum := 0
+	for sendNum < len(frame) {
+		num, err := mc.conn.Write(frame[sendNum:])
+		if err != nil {
+			mc.close(err)
+			return err
+		}
+		sendNum += num
+	}
+	atomic.StoreInt64(&mc.lastActive, time.Now().UnixNano())
+
+	return nil
+}
+
+// heartbeatLoop 连接空闲超过一个心跳周期时发送心跳帧，心跳帧使用保留的流 ID 0。
+// 读到任何帧都说明连接是活的，连续 maxMissed 个心跳都没有读到任何帧时关闭连接，等待中的请求会立即返回错误
+func (mc *muxConn) heartbeatLoop(interval time.Duration, maxMissed int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mc.done:
+			return
+		case <-ticker.C:
+		}
+
+		if int(atomic.LoadInt32(&mc.missed)) >= maxMissed {
+			mc.close(errHeartbeatTimeout)
+			return
+		}
+
+		if time.Since(time.Unix(0, atomic.LoadInt64(&mc.lastActive))) < interval {
+			continue
+		}
+
+		atomic.AddInt32(&mc.missed, 1)
+		if err := mc.write(codec.NewHeartbeatFrame(0)); err != nil {
+			return
+		}
+	}
+}
+
+// readLoop 持续读取回包帧，按帧头中的流 ID 分发给等待中的请求，已经超时退出的请求的回包直接丢弃
+func (mc *muxConn) readLoop() {
+	for {
+		frame, err := mc.conn.Framer.ReadFrame(mc.conn)
+		if err != nil {
+			mc.close(err)
+			return
+		}
+
+		header, err := codec.ParseFrameHeader(frame)
+		if err != nil {
+			mc.close(err)
+			return
+		}
+
+		atomic.StoreInt32(&mc.missed, 0)
+		atomic.StoreInt64(&mc.lastActive, time.Now().UnixNano())
+		if header.MsgType == codec.MsgTypeHeartbeat {
+			continue
+		}
+
+		mc.mu.Lock()
+		queue, ok := mc.streams[header.StreamID]
+		mc.mu.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		queue.Push(frame)
+	}
+}
+
+func (mc *muxConn) close(err error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.err != nil {
+		return
+	}
+	if err == nil {
+		err = errMuxConnClosed
+	}
+
+	mc.err = err
+	close(mc.done)
+	mc.conn.Close()
+	metrics.GetSink("default").AddGauge(metrics.ClientMuxConnections, mc.labels(), -1)
+
+	for _, queue := range mc.streams {
+		queue.Close(err)
+	}
+}
+
+func (mc *muxConn) labels() metrics.Labels {
+	return metrics.Labels{
+		"network": mc.conn.RemoteAddr().Network(),
+	}
+}
+
+func (mc *muxConn) isClosed() bool {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	return mc.err != nil
+}
+
+// muxStream 是多路复用连接上的一个流，实现了 Stream
+type muxStream struct {
+	id    uint16
+	mc    *muxConn
+	queue *transport.FrameQueue
+}
+
+// Send 将流 ID 写入帧头后发送
+func (st *muxStream) Send(frame []byte) error {
+	codec.SetStreamID(frame, st.id)
+	return st.mc.write(frame)
+}
+
+// Recv 按顺序返回流上收到的帧，连接断开时返回连接断开的原因
+func (st *muxStream) Recv(ctx context.Context) ([]byte, error) {
+	return st.queue.Pop(ctx)
+}
+
+// Close 释放流 ID，之后收到的这个流的帧会被丢弃
+func (st *muxStream) Close() {
+	st.mc.closeStream(st.id)
+}
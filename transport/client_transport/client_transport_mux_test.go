@@ -0,0 +1,156 @@
+package client_transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/junaozun/go-lrpxc/codec"
+	"github.com/junaozun/go-lrpxc/transport"
+)
+
+// reverseServer 收齐 n 个请求帧之后按相反的顺序回包，回包的包体和请求相同，用来检查 client 按流 ID 分发回包
+func reverseServer(t *testing.T, n int) (string, *int32) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+
+	var accepted int32
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			go func() {
+				defer conn.Close()
+				framer := transport.NewFramer()
+				var frames [][]byte
+				for len(frames) < n {
+					frame, err := framer.ReadFrame(conn)
+					if err != nil {
+						return
+					}
+					frames = append(frames, append([]byte(nil), frame...))
+				}
+				for i := len(frames) - 1; i >= 0; i-- {
+					if _, err := conn.Write(frames[i]); err != nil {
+						return
+					}
+				}
+				// keep the conn open until the client closes it
+				framer.ReadFrame(conn)
+			}()
+		}
+	}()
+
+	return lis.Addr().String(), &accepted
+}
+
+func TestMuxRoutesResponsesByStreamID(t *testing.T) {
+	const n = 8
+	addr, _ := reverseServer(t, n)
+
+	pool := newMuxPool()
+	mc, err := pool.get(context.Background(), "tcp", addr, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.close(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := []byte(fmt.Sprintf("req-%d", i))
+			req, err := codec.GetFrameCodec("proto").EncodeFrame(&codec.FrameHeader{}, payload)
+			if err != nil {
+				errs <- err
+				return
+			}
+			rsp, err := mc.roundTrip(ctx, req)
+			if err != nil {
+				errs <- err
+				return
+			}
+			_, body, err := codec.GetFrameCodec("proto").DecodeFrame(rsp)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if string(body) != string(payload) {
+				errs <- fmt.Errorf("request %q got response %q", payload, body)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestMuxPoolSharesConcurrentDials(t *testing.T) {
+	addr, accepted := reverseServer(t, 1)
+
+	pool := newMuxPool()
+	conns := make([]*muxConn, 16)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mc, err := pool.get(context.Background(), "tcp", addr, 0, 0)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conns[i] = mc
+		}(i)
+	}
+	wg.Wait()
+
+	for _, mc := range conns[1:] {
+		if mc != conns[0] {
+			t.Fatal("concurrent gets returned different connections")
+		}
+	}
+	conns[0].close(nil)
+
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(accepted); n != 1 {
+		t.Fatalf("server accepted %d connections, want 1", n)
+	}
+}
+
+func TestMuxConnClosedFailsPendingStreams(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	mc := newMuxConn(client, 0, 0)
+	st, err := mc.newStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mc.close(nil)
+	if _, err := st.Recv(context.Background()); err != errMuxConnClosed {
+		t.Fatalf("Recv after close got %v, want %v", err, errMuxConnClosed)
+	}
+	if _, err := mc.newStream(); err != errMuxConnClosed {
+		t.Fatalf("newStream after close got %v, want %v", err, errMuxConnClosed)
+	}
+}
@@ -3,36 +3,47 @@ package client_transport
 import (
 	"context"
 	"net"
+	"time"
 
 	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/selector"
 )
 
 func (c *clientTransport) SendUdpReq(ctx context.Context, req []byte) ([]byte, error) {
 	// service discovery
-	addr, err := c.opts.Selector.Select(c.opts.ServiceName)
+	addr, err := c.selectAddress(ctx)
 	if err != nil {
-		return nil, err
+		return nil, codes.NotSent(err)
 	}
 
-	// defaultSelector returns "", use the target as address
-	if addr == "" {
-		addr = c.opts.Target
-	}
+	selector.DefaultInflight.Add(addr, 1)
+	start := time.Now()
+	rsp, err := c.sendUdpReq(ctx, addr, req)
+	selector.DefaultInflight.Add(addr, -1)
+	c.report(addr, start, err)
+
+	return rsp, err
+}
 
+func (c *clientTransport) sendUdpReq(ctx context.Context, addr string, req []byte) ([]byte, error) {
 	udpAddr, err := net.ResolveUDPAddr(c.opts.Network, addr)
 	if err != nil {
-		return nil, codes.NewFrameworkError(codes.ClientMsgErrorCode, "addr invalid ...")
+		return nil, codes.NotSent(codes.NewFrameworkError(codes.ClientMsgErrorCode, "addr invalid ..."))
 	}
 
 	conn, err := net.DialUDP(c.opts.Network, nil, udpAddr)
 	if err != nil {
-		return nil, err
+		return nil, codes.NotSent(err)
 	}
 
 	defer conn.Close()
 
 	if n, err := conn.Write(req); n != len(req) || err != nil {
-		return nil, err
+		return nil, codes.NotSent(err)
+	}
+
+	if isOneWay(req) {
+		return nil, nil
 	}
 
 	recvBuf := make([]byte, 65536)
@@ -7,13 +7,17 @@ import (
 
 // ServerTransportOptions includes all ServerTransport parameter options
 type ServerTransportOptions struct {
-	Address           string        // address，e.g: ip://127.0.0.1：8080
-	Network           string        // network type
-	Protocol          string        // protocol type, e.g. : proto、json
-	Timeout           time.Duration // transport layer request timeout ，default: 2 min
-	Handler           Handler       // handler
-	SerializationType string        // serialization type, e.g : proto、json、msgpack
-	KeepAlivePeriod   time.Duration // keepalive period
+	Address             string        // address，e.g: ip://127.0.0.1：8080
+	Network             string        // network type
+	Protocol            string        // protocol type, e.g. : proto、json
+	Timeout             time.Duration // transport layer request timeout ，default: 2 min
+	Handler             Handler       // handler
+	SerializationType   string        // serialization type, e.g : proto、json、msgpack
+	KeepAlivePeriod     time.Duration // keepalive period
+	Compressor          string        // compressor name of responses
+	CompressMinSize     int           // responses smaller than CompressMinSize are not compressed
+	HeartbeatInterval   time.Duration // the interval that clients send heartbeats, 0 means no heartbeat check
+	MaxMissedHeartbeats int           // close the connection after missing MaxMissedHeartbeats heartbeats
 }
 
 // Handler defines a common interface for handling packets
@@ -21,6 +25,12 @@ type Handler interface {
 	Handle(context.Context, []byte) ([]byte, error)
 }
 
+// StreamHandler 由支持流式请求的 Handler 实现，reqbuf 是建立流时 client 发送的包头，
+// 返回的 error 会转换成返回码放在结束帧中发送给 client
+type StreamHandler interface {
+	HandleStream(ctx context.Context, reqbuf []byte, stream Stream) error
+}
+
 // Use the Options mode to wrap the ServerTransportOptions
 type ServerTransportOption func(*ServerTransportOptions)
 
@@ -72,3 +82,19 @@ func WithKeepAlivePeriod(keepAlivePeriod time.Duration) ServerTransportOption {
 		o.KeepAlivePeriod = keepAlivePeriod
 	}
 }
+
+// WithCompressor returns a ServerTransportOption which sets the value for compressor and compressMinSize
+func WithCompressor(compressor string, minSize int) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.Compressor = compressor
+		o.CompressMinSize = minSize
+	}
+}
+
+// WithHeartbeat returns a ServerTransportOption which sets the value for heartbeatInterval and maxMissedHeartbeats
+func WithHeartbeat(interval time.Duration, maxMissed int) ServerTransportOption {
+	return func(o *ServerTransportOptions) {
+		o.HeartbeatInterval = interval
+		o.MaxMissedHeartbeats = maxMissed
+	}
+}
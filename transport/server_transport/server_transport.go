@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/junaozun/go-lrpxc/codec"
 	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/metrics"
 	"github.com/junaozun/go-lrpxc/protocol"
 	"github.com/junaozun/go-lrpxc/stream"
 	"github.com/junaozun/go-lrpxc/transport"
@@ -19,6 +22,13 @@ import (
 
 type serverTransport struct {
 	opts *ServerTransportOptions
+
+	mu          sync.Mutex
+	listeners   map[net.Listener]struct{}           // tcp listeners
+	packetConns map[net.PacketConn]struct{}         // udp listeners
+	conns       map[*transport.ConnWrapper]struct{} // active tcp connections
+	wg          sync.WaitGroup                      // active tcp connections and udp requests
+	closing     int32                               // whether the transport is shutting down
 }
 
 var serverTransportMap = make(map[string]transport.ServerTransport)
@@ -51,7 +61,10 @@ var DefaultServerTransport = NewServerTransport()
 // Use the singleton pattern to create a server transport
 var NewServerTransport = func() transport.ServerTransport {
 	return &serverTransport{
-		opts: &ServerTransportOptions{},
+		opts:        &ServerTransportOptions{},
+		listeners:   make(map[net.Listener]struct{}),
+		packetConns: make(map[net.PacketConn]struct{}),
+		conns:       make(map[*transport.ConnWrapper]struct{}),
 	}
 }
 
@@ -62,7 +75,7 @@ func (s *serverTransport) ListenAndServe(ctx context.Context, opts ...ServerTran
 	}
 
 	switch s.opts.Network {
-	case "tcp", "tcp4", "tcp6":
+	case "tcp", "tcp4", "tcp6", "unix":
 		return s.ListenAndServeTcp(ctx, opts...)
 	case "udp", "udp4", "udp6":
 		return s.ListenAndServeUdp(ctx, opts...)
@@ -78,6 +91,10 @@ func (s *serverTransport) ListenAndServeTcp(ctx context.Context, opts ...ServerT
 		return err
 	}
 
+	s.mu.Lock()
+	s.listeners[lis] = struct{}{}
+	s.mu.Unlock()
+
 	go func() {
 		if err = s.serve(ctx, lis); err != nil {
 			fmt.Printf("transport serve error, %v", err)
@@ -91,11 +108,6 @@ func (s *serverTransport) serve(ctx context.Context, lis net.Listener) error {
 
 	var tempDelay time.Duration
 
-	tl, ok := lis.(*net.TCPListener)
-	if !ok {
-		return codes.NetworkNotSupportedError
-	}
-
 	for {
 
 		// check upstream ctx is done
@@ -105,8 +117,12 @@ func (s *serverTransport) serve(ctx context.Context, lis net.Listener) error {
 		default:
 		}
 
-		conn, err := tl.AcceptTCP()
+		conn, err := lis.Accept()
 		if err != nil {
+			// listener is closed by Shutdown
+			if s.isClosing() {
+				return nil
+			}
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				if tempDelay == 0 {
 					tempDelay = 5 * time.Millisecond
@@ -122,20 +138,31 @@ func (s *serverTransport) serve(ctx context.Context, lis net.Listener) error {
 			return err
 		}
 
-		if err = conn.SetKeepAlive(true); err != nil {
-			return err
+		// unix socket 没有 keepalive
+		if tc, ok := conn.(*net.TCPConn); ok {
+			if err = tc.SetKeepAlive(true); err != nil {
+				return err
+			}
+
+			if s.opts.KeepAlivePeriod != 0 {
+				tc.SetKeepAlivePeriod(s.opts.KeepAlivePeriod)
+			}
 		}
 
-		if s.opts.KeepAlivePeriod != 0 {
-			conn.SetKeepAlivePeriod(s.opts.KeepAlivePeriod)
+		wrapperConn := transport.WrapConn(conn)
+		if !s.trackConn(wrapperConn) {
+			conn.Close()
+			return nil
 		}
 
 		go func() {
+			defer s.untrackConn(wrapperConn)
 
 			// build stream
-			ctx, _ := stream.NewServerStream(ctx)
+			ctx, ss := stream.NewServerStream(ctx)
+			ss.RemoteAddr = conn.RemoteAddr().String()
 
-			if err := s.handleConn(ctx, transport.WrapConn(conn)); err != nil {
+			if err := s.handleConn(ctx, wrapperConn); err != nil {
 				fmt.Printf("gorpc handle tcp conn error, %v", err)
 			}
 
@@ -146,11 +173,26 @@ func (s *serverTransport) serve(ctx context.Context, lis net.Listener) error {
 	return nil
 }
 
+// handleConn 循环从连接上读取请求帧，每一帧交给一个协程并发处理，回包的帧头中带回请求的流 ID，
+// 多路复用的 client 通过流 ID 将回包分发给对应的请求。流式请求的帧交给 connStreams 按流 ID 分发。
+// 同一条连接上的回包写入需要加锁
 func (s *serverTransport) handleConn(ctx context.Context, conn *transport.ConnWrapper) error {
 
+	var (
+		writeMu  sync.Mutex
+		frameWg  sync.WaitGroup // frames being handled on this connection
+		inflight int32          // number of frames being handled on this connection
+	)
+	streams := newConnStreams(s, conn, &writeMu, &frameWg, &inflight)
+
 	// close the connection before return
 	// the connection closes only if a network read or write fails
-	defer conn.Close()
+	// cancel the streams and wait for the frames being handled before closing the connection
+	defer func() {
+		streams.cancelAll()
+		frameWg.Wait()
+		conn.Close()
+	}()
 
 	for {
 		// check upstream ctx is done
@@ -160,6 +202,18 @@ func (s *serverTransport) handleConn(ctx context.Context, conn *transport.ConnWr
 		default:
 		}
 
+		// client 至少每个心跳周期发送一次心跳，连续 MaxMissedHeartbeats 个周期没有收到任何帧时关闭连接
+		idleTimeout := s.opts.HeartbeatInterval * time.Duration(s.opts.MaxMissedHeartbeats)
+		if idleTimeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		}
+
+		// stop reading new frames once the transport is shutting down
+		// checked after setting the idle deadline, so that the deadline set by Shutdown is not overwritten
+		if s.isClosing() {
+			return nil
+		}
+
 		frame, err := s.read(ctx, conn)
 		if err == io.EOF {
 			// read compeleted
@@ -167,21 +221,138 @@ func (s *serverTransport) handleConn(ctx context.Context, conn *transport.ConnWr
 		}
 
 		if err != nil {
+			// the blocking read is interrupted by Shutdown
+			if s.isClosing() {
+				return nil
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && idleTimeout > 0 {
+				// the client is waiting for a long request, it is not idle
+				if atomic.LoadInt32(&inflight) > 0 {
+					continue
+				}
+				return codes.NewFrameworkError(codes.ClientMsgErrorCode, "heartbeat timeout ...")
+			}
 			return err
 		}
 
-		rsp, err := s.handle(ctx, frame)
-		if err != nil {
-			fmt.Printf("s.handle err is not nil, %v", err)
+		if header, err := codec.ParseFrameHeader(frame); err == nil &&
+			header.MsgType == codec.MsgTypeNormal && codec.IsStreamReqType(header.ReqType) {
+			streams.dispatch(ctx, frame)
+			continue
 		}
 
-		if err = s.write(ctx, conn, rsp); err != nil {
-			return err
+		if !s.trackRequest() {
+			return nil
 		}
+		frameWg.Add(1)
+		atomic.AddInt32(&inflight, 1)
+
+		go func() {
+			defer func() {
+				atomic.AddInt32(&inflight, -1)
+				frameWg.Done()
+				s.wg.Done()
+			}()
+
+			rsp, err := s.handle(ctx, frame)
+			if err != nil {
+				fmt.Printf("s.handle err is not nil, %v", err)
+			}
+
+			// one-way requests have no response
+			if len(rsp) == 0 {
+				return
+			}
+
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			if err = s.write(ctx, conn, rsp); err != nil {
+				fmt.Printf("s.write err is not nil, %v", err)
+			}
+		}()
 	}
 
 }
 
+// Shutdown 优雅退出：先关闭监听不再接收新连接，再唤醒阻塞在读帧上的空闲连接，正在处理请求的连接写完当前回包后退出，
+// 等待所有连接和请求处理完成后返回。ctx 到期时强制关闭剩余的连接并返回 ctx.Err()
+func (s *serverTransport) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.closing, 1)
+
+	s.mu.Lock()
+	for lis := range s.listeners {
+		lis.Close()
+	}
+	for conn := range s.packetConns {
+		conn.Close()
+	}
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *serverTransport) isClosing() bool {
+	return atomic.LoadInt32(&s.closing) == 1
+}
+
+// trackConn records an active connection, it returns false if the transport is shutting down
+func (s *serverTransport) trackConn(conn *transport.ConnWrapper) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isClosing() {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	metrics.GetSink("default").AddGauge(metrics.ServerConnections, connLabels(conn), 1)
+	return true
+}
+
+// trackRequest records an in-flight request, it returns false if the transport is shutting down.
+// the caller calls s.wg.Done() when the request is finished
+func (s *serverTransport) trackRequest() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isClosing() {
+		return false
+	}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *serverTransport) untrackConn(conn *transport.ConnWrapper) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+	metrics.GetSink("default").AddGauge(metrics.ServerConnections, connLabels(conn), -1)
+	s.wg.Done()
+}
+
+func connLabels(conn net.Conn) metrics.Labels {
+	return metrics.Labels{
+		"network": conn.LocalAddr().Network(),
+	}
+}
+
 func (s *serverTransport) read(ctx context.Context, conn *transport.ConnWrapper) ([]byte, error) {
 
 	frame, err := conn.Framer.ReadFrame(conn)
@@ -196,19 +367,29 @@ func (s *serverTransport) read(ctx context.Context, conn *transport.ConnWrapper)
 func (s *serverTransport) handle(ctx context.Context, frame []byte) ([]byte, error) {
 
 	// parse reqbuf into req interface {}
-	serverCodec := codec.GetCodec(s.opts.Protocol)
+	serverCodec := codec.GetFrameCodec(s.opts.Protocol)
 
-	reqbuf, err := serverCodec.Decode(frame)
+	reqHeader, reqbuf, err := serverCodec.DecodeFrame(frame)
 	if err != nil {
 		fmt.Printf("server Decode error: %v", err)
 		return nil, err
 	}
 
+	// 心跳帧直接回一个心跳帧，不需要交给 Handler 处理
+	if reqHeader.MsgType == codec.MsgTypeHeartbeat {
+		return codec.NewHeartbeatFrame(reqHeader.StreamID), nil
+	}
+
 	rspbuf, err := s.opts.Handler.Handle(ctx, reqbuf)
 	if err != nil {
 		fmt.Printf("server Handle error: %v", err)
 	}
 
+	// 只发不收的请求执行完 Handler 后不需要编码和回包
+	if reqHeader.ReqType == codec.ReqTypeSendOnly {
+		return nil, nil
+	}
+
 	response := addRspHeader(rspbuf, err)
 
 	rspPb, err := proto.Marshal(response)
@@ -217,7 +398,18 @@ func (s *serverTransport) handle(ctx context.Context, frame []byte) ([]byte, err
 		return nil, err
 	}
 
-	rspbody, err := serverCodec.Encode(rspPb)
+	compressType, err := codec.GetCompressType(s.opts.Compressor, len(rspPb), s.opts.CompressMinSize)
+	if err != nil {
+		return nil, err
+	}
+
+	// echo the stream id so that the multiplexed client can route the response
+	rspHeader := &codec.FrameHeader{
+		StreamID:     reqHeader.StreamID,
+		CompressType: compressType,
+	}
+
+	rspbody, err := serverCodec.EncodeFrame(rspHeader, rspPb)
 	if err != nil {
 		fmt.Printf("server Encode error, response: %v, err: %v", response, err)
 		return nil, err
@@ -0,0 +1,254 @@
+package server_transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/junaozun/go-lrpxc/codec"
+	"github.com/junaozun/go-lrpxc/codes"
+	"github.com/junaozun/go-lrpxc/protocol"
+	"github.com/junaozun/go-lrpxc/transport"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+流式请求：client 在多路复用连接上用一个流 ID 建立流，第一帧（StreamFrameInit）的包头中带有服务路径和元数据，
+server 收到后启动一个协程调用 StreamHandler 处理这个流。之后 client 的数据帧按顺序放入流的队列，由 Recv 读取，
+client 的结束帧让 Recv 返回 io.EOF，取消帧会取消流的 ctx。StreamHandler 返回后，server 发送带有返回码的结束帧。
+连接的读协程在多个流和普通请求之间共用，分发数据帧时不会阻塞：一个流缓存的数据帧超过上限时只重置这个流，
+取消流的 ctx 并用 errStreamOverflow 结束这个流，连接上的其他流和请求不受影响。
+*/
+
+// Stream 是流式请求在 server 传输层的一个流
+type Stream interface {
+	Context() context.Context
+	// Recv 返回 client 发送的下一个包头+包体，client 结束发送后返回 io.EOF
+	Recv() ([]byte, error)
+	// Send 将 payload 作为回包的包体发送给 client
+	Send([]byte) error
+}
+
+// streamQueueSize 是一个流上缓存的 client 数据帧的上限，handler 读取慢、缓存的数据帧超过上限时重置这个流
+const streamQueueSize = 64
+
+var errStreamOverflow = codes.NewFrameworkError(codes.ClientMsgErrorCode,
+	"stream reset, the client sends faster than the handler receives ...")
+
+// connStreams 管理一条连接上正在处理的流
+type connStreams struct {
+	s        *serverTransport
+	conn     *transport.ConnWrapper
+	writeMu  *sync.Mutex     // serializes frame writes of the connection
+	frameWg  *sync.WaitGroup // frames and streams being handled on the connection
+	inflight *int32          // number of frames and streams being handled on the connection
+
+	mu      sync.Mutex
+	streams map[uint16]*serverStream
+}
+
+func newConnStreams(s *serverTransport, conn *transport.ConnWrapper, writeMu *sync.Mutex,
+	frameWg *sync.WaitGroup, inflight *int32) *connStreams {
+	return &connStreams{
+		s:        s,
+		conn:     conn,
+		writeMu:  writeMu,
+		frameWg:  frameWg,
+		inflight: inflight,
+		streams:  make(map[uint16]*serverStream),
+	}
+}
+
+// dispatch 根据帧头保留位中的帧类型处理流上的一帧
+func (cs *connStreams) dispatch(ctx context.Context, frame []byte) {
+	header, body, err := codec.GetFrameCodec(cs.s.opts.Protocol).DecodeFrame(frame)
+	if err != nil {
+		fmt.Printf("server Decode error: %v", err)
+		return
+	}
+
+	if header.Reserved == codec.StreamFrameInit {
+		cs.open(ctx, header, body)
+		return
+	}
+
+	cs.mu.Lock()
+	st, ok := cs.streams[header.StreamID]
+	cs.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	switch header.Reserved {
+	case codec.StreamFrameData:
+		if !st.queue.TryPush(body) {
+			st.reset(errStreamOverflow)
+		}
+	case codec.StreamFrameEnd:
+		st.queue.Close(io.EOF)
+	case codec.StreamFrameCancel:
+		st.cancel()
+	}
+}
+
+// open 建立一个流，并启动一个协程调用 StreamHandler 处理这个流
+func (cs *connStreams) open(ctx context.Context, header *codec.FrameHeader, reqbuf []byte) {
+	ctx, cancel := context.WithCancel(ctx)
+	st := &serverStream{
+		ctx:     ctx,
+		cancel:  cancel,
+		id:      header.StreamID,
+		reqType: header.ReqType,
+		queue:   transport.NewBoundedFrameQueue(streamQueueSize),
+		cs:      cs,
+	}
+
+	handler, ok := cs.s.opts.Handler.(StreamHandler)
+	if !ok {
+		cancel()
+		st.end(codes.NewFrameworkError(codes.ClientMsgErrorCode, "streaming is not supported ..."))
+		return
+	}
+
+	if !cs.s.trackRequest() {
+		cancel()
+		st.end(codes.NewFrameworkError(codes.ServerInternalErrorCode, "server is shutting down ..."))
+		return
+	}
+
+	cs.mu.Lock()
+	cs.streams[st.id] = st
+	cs.mu.Unlock()
+
+	cs.frameWg.Add(1)
+	atomic.AddInt32(cs.inflight, 1)
+
+	go func() {
+		defer func() {
+			cs.mu.Lock()
+			delete(cs.streams, st.id)
+			cs.mu.Unlock()
+
+			st.cancel()
+			atomic.AddInt32(cs.inflight, -1)
+			cs.frameWg.Done()
+			cs.s.wg.Done()
+		}()
+
+		err := handler.HandleStream(st.ctx, reqbuf, st)
+		if err != nil {
+			fmt.Printf("server HandleStream error: %v", err)
+		}
+
+		// the handler of a reset stream returns the error of the canceled ctx, tell the client why the stream is reset
+		if resetErr := st.resetError(); resetErr != nil {
+			err = resetErr
+		}
+
+		if err = st.end(err); err != nil {
+			fmt.Printf("stream end error: %v", err)
+		}
+	}()
+}
+
+// cancelAll 连接断开时取消连接上所有的流
+func (cs *connStreams) cancelAll() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	for _, st := range cs.streams {
+		st.cancel()
+	}
+}
+
+func (cs *connStreams) write(frame []byte) error {
+	cs.writeMu.Lock()
+	defer cs.writeMu.Unlock()
+
+	_, err := cs.conn.Write(frame)
+	return err
+}
+
+// serverStream 实现了 Stream
+type serverStream struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	id      uint16
+	reqType uint8
+	queue   *transport.FrameQueue // packets of the data frames received on the stream
+	cs      *connStreams
+
+	mu       sync.Mutex
+	resetErr error // the reason why the stream is reset by the server
+}
+
+// reset 结束一个流：之后的 Recv 返回 err，流的 ctx 被取消，StreamHandler 返回后结束帧中带有 err 的返回码
+func (st *serverStream) reset(err error) {
+	st.mu.Lock()
+	if st.resetErr == nil {
+		st.resetErr = err
+	}
+	st.mu.Unlock()
+
+	st.queue.Close(err)
+	st.cancel()
+}
+
+func (st *serverStream) resetError() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.resetErr
+}
+
+func (st *serverStream) Context() context.Context {
+	return st.ctx
+}
+
+func (st *serverStream) Recv() ([]byte, error) {
+	if err := st.resetError(); err != nil {
+		return nil, err
+	}
+	return st.queue.Pop(st.ctx)
+}
+
+func (st *serverStream) Send(payload []byte) error {
+	if err := st.ctx.Err(); err != nil {
+		return err
+	}
+	return st.send(codec.StreamFrameData, addRspHeader(payload, nil))
+}
+
+// end 发送结束帧，结束帧中带有 StreamHandler 返回的错误对应的返回码
+func (st *serverStream) end(err error) error {
+	return st.send(codec.StreamFrameEnd, addRspHeader(nil, err))
+}
+
+func (st *serverStream) send(frameType uint32, response *protocol.Response) error {
+	rspPb, err := proto.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	opts := st.cs.s.opts
+	compressType, err := codec.GetCompressType(opts.Compressor, len(rspPb), opts.CompressMinSize)
+	if err != nil {
+		return err
+	}
+
+	header := &codec.FrameHeader{
+		ReqType:      st.reqType,
+		StreamID:     st.id,
+		Reserved:     frameType,
+		CompressType: compressType,
+	}
+
+	frame, err := codec.GetFrameCodec(opts.Protocol).EncodeFrame(header, rspPb)
+	if err != nil {
+		return err
+	}
+
+	return st.cs.write(frame)
+}
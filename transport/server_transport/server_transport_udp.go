@@ -12,13 +12,29 @@ import (
 func (s *serverTransport) ListenAndServeUdp(ctx context.Context, opts ...ServerTransportOption) error {
 
 	conn, err := net.ListenPacket(s.opts.Network, s.opts.Address)
-	defer conn.Close()
-
-	buffer := make([]byte, 65536)
 	if err != nil {
 		return err
 	}
 
+	s.mu.Lock()
+	s.packetConns[conn] = struct{}{}
+	s.mu.Unlock()
+
+	go func() {
+		if err = s.serveUdp(ctx, conn); err != nil {
+			fmt.Printf("transport serve udp error, %v", err)
+		}
+	}()
+
+	return nil
+}
+
+func (s *serverTransport) serveUdp(ctx context.Context, conn net.PacketConn) error {
+
+	defer conn.Close()
+
+	buffer := make([]byte, 65536)
+
 	var tempDelay time.Duration
 
 	for {
@@ -31,6 +47,10 @@ func (s *serverTransport) ListenAndServeUdp(ctx context.Context, opts ...ServerT
 
 		num, addr, err := conn.ReadFrom(buffer)
 		if err != nil {
+			// packet conn is closed by Shutdown
+			if s.isClosing() {
+				return nil
+			}
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
 				if tempDelay == 0 {
 					tempDelay = 5 * time.Millisecond
@@ -46,12 +66,20 @@ func (s *serverTransport) ListenAndServeUdp(ctx context.Context, opts ...ServerT
 			return err
 		}
 
-		req := buffer[:num]
+		// buffer is reused by the next ReadFrom, copy the request out
+		req := make([]byte, num)
+		copy(req, buffer[:num])
+
+		if !s.trackRequest() {
+			return nil
+		}
 
 		go func() {
+			defer s.wg.Done()
 
 			// build stream
-			ctx, _ := stream.NewServerStream(ctx)
+			ctx, ss := stream.NewServerStream(ctx)
+			ss.RemoteAddr = addr.String()
 
 			if err := s.handleUdpConn(ctx, conn, addr, req); err != nil {
 				fmt.Printf("gorpc handle udp conn error, %v", err)
@@ -61,8 +89,6 @@ func (s *serverTransport) ListenAndServeUdp(ctx context.Context, opts ...ServerT
 
 	}
 
-	return nil
-
 }
 
 func (s *serverTransport) handleUdpConn(ctx context.Context, conn net.PacketConn, addr net.Addr, req []byte) error {
@@ -72,6 +98,11 @@ func (s *serverTransport) handleUdpConn(ctx context.Context, conn net.PacketConn
 		return err
 	}
 
+	// one-way requests have no response
+	if len(rsp) == 0 {
+		return nil
+	}
+
 	_, err = conn.WriteTo(rsp, addr)
 	return err
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"io"
 	"net"
+	"sync"
 
 	"github.com/junaozun/go-lrpxc/codec"
 	"github.com/junaozun/go-lrpxc/codes"
@@ -22,11 +23,15 @@ const MaxPayloadLength = 4 * 1024 * 1024
 // server 传输层主要提供一种监听和处理请求的能力
 type ServerTransport interface {
 	ListenAndServe(context.Context, ...server_transport.ServerTransportOption) error
+	// Shutdown 停止接收新的连接和请求，等待正在处理的请求完成后返回，ctx 到期时强制关闭剩余连接
+	Shutdown(context.Context) error
 }
 
 // client 传输层主要提供一种向下游发送请求的能力
 type ClientTransport interface {
 	Send(context.Context, []byte, ...client_transport.ClientTransportOption) ([]byte, error)
+	// NewStream 在多路复用连接上创建一个流，流式调用的所有帧都使用这个流的流 ID
+	NewStream(context.Context, ...client_transport.ClientTransportOption) (client_transport.Stream, error)
 }
 
 // 从网络流中读取数据
@@ -97,3 +102,101 @@ func WrapConn(rawConn net.Conn) *ConnWrapper {
 		Framer: NewFramer(),
 	}
 }
+
+// FrameQueue 是一个帧队列，一个队列只能有一个读取方。Push 和 TryPush 都不会阻塞，连接的读协程向队列写入帧时
+// 不会被某个读取慢的流阻塞；有界的队列通过 TryPush 写入，队列满时写入失败，server 用它限制 client 发送的流式数据帧，
+// 避免 client 发送比 handler 读取快时 server 内存无限增长
+type FrameQueue struct {
+	mu     sync.Mutex
+	frames [][]byte
+	limit  int           // max frames buffered by TryPush, 0 means unbounded
+	err    error         // returned by Pop after all frames are popped
+	notify chan struct{} // signaled when a frame is pushed or the queue is closed
+}
+
+func NewFrameQueue() *FrameQueue {
+	return NewBoundedFrameQueue(0)
+}
+
+// NewBoundedFrameQueue 创建一个最多缓存 limit 个帧的队列，limit 为 0 表示不限制
+func NewBoundedFrameQueue(limit int) *FrameQueue {
+	return &FrameQueue{
+		limit:  limit,
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// Push appends a frame without blocking, frames pushed after Close are dropped
+func (q *FrameQueue) Push(frame []byte) {
+	q.mu.Lock()
+	if q.err != nil {
+		q.mu.Unlock()
+		return
+	}
+	q.frames = append(q.frames, frame)
+	q.mu.Unlock()
+
+	q.signal()
+}
+
+// TryPush appends a frame without blocking, it returns false if the queue already holds limit frames.
+// frames pushed after Close are dropped
+func (q *FrameQueue) TryPush(frame []byte) bool {
+	q.mu.Lock()
+	if q.err != nil {
+		q.mu.Unlock()
+		return true
+	}
+	if q.limit > 0 && len(q.frames) >= q.limit {
+		q.mu.Unlock()
+		return false
+	}
+	q.frames = append(q.frames, frame)
+	q.mu.Unlock()
+
+	q.signal()
+	return true
+}
+
+func (q *FrameQueue) Close(err error) {
+	q.mu.Lock()
+	if q.err == nil {
+		q.err = err
+	}
+	q.mu.Unlock()
+
+	q.signal()
+}
+
+// Pop blocks until there is a frame, the queue is closed or ctx is done
+func (q *FrameQueue) Pop(ctx context.Context) ([]byte, error) {
+	for {
+		q.mu.Lock()
+		if len(q.frames) > 0 {
+			frame := q.frames[0]
+			q.frames[0] = nil
+			q.frames = q.frames[1:]
+			q.mu.Unlock()
+			return frame, nil
+		}
+		err := q.err
+		q.mu.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (q *FrameQueue) signal() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
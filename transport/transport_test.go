@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+func TestFrameQueueTryPushFailsWhenFull(t *testing.T) {
+	q := NewBoundedFrameQueue(2)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if !q.TryPush([]byte{byte(i)}) {
+			t.Fatalf("TryPush %d failed on a queue with space", i)
+		}
+	}
+	if q.TryPush([]byte{2}) {
+		t.Fatal("TryPush succeeded on a full queue")
+	}
+
+	frame, err := q.Pop(ctx)
+	if err != nil || frame[0] != 0 {
+		t.Fatalf("Pop got %v, %v", frame, err)
+	}
+	if !q.TryPush([]byte{2}) {
+		t.Fatal("TryPush failed after Pop freed space")
+	}
+
+	for want := byte(1); want <= 2; want++ {
+		frame, err := q.Pop(ctx)
+		if err != nil || frame[0] != want {
+			t.Fatalf("Pop got %v, %v, want frame %d", frame, err, want)
+		}
+	}
+}
+
+func TestFrameQueueTryPushAfterClose(t *testing.T) {
+	q := NewBoundedFrameQueue(1)
+	if !q.TryPush([]byte{0}) {
+		t.Fatal("TryPush failed on an empty queue")
+	}
+	q.Close(io.EOF)
+
+	// frames pushed after Close are dropped instead of reported as overflow
+	if !q.TryPush([]byte{1}) {
+		t.Fatal("TryPush after Close reported a full queue")
+	}
+
+	// frames already in the queue are still popped before the close error
+	if frame, err := q.Pop(context.Background()); err != nil || frame[0] != 0 {
+		t.Fatalf("Pop got %v, %v", frame, err)
+	}
+	if _, err := q.Pop(context.Background()); err != io.EOF {
+		t.Fatalf("Pop got %v, want %v", err, io.EOF)
+	}
+}
+
+func TestFrameQueuePushIsUnbounded(t *testing.T) {
+	q := NewFrameQueue()
+	for i := 0; i < 1000; i++ {
+		q.Push([]byte{byte(i)})
+	}
+	for i := 0; i < 1000; i++ {
+		frame, err := q.Pop(context.Background())
+		if err != nil || frame[0] != byte(i) {
+			t.Fatalf("Pop %d got %v, %v", i, frame, err)
+		}
+	}
+}